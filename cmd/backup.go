@@ -0,0 +1,103 @@
+package kvcli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"github.com/spf13/cobra"
+)
+
+func BackupCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "backup FILE",
+		Short: "Writes a consistent snapshot of the KVD service to a file",
+		Long: `Streams a point-in-time snapshot of every key, with its flags and expiry, to
+FILE. The snapshot is checksummed and can be loaded with "kv restore". The
+file is only put in place once the whole snapshot has been received.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := args[0]
+			tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+			if err != nil {
+				return fmt.Errorf("could not create %s: %w", path, err)
+			}
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			w := bufio.NewWriter(tmp)
+			client := newClient()
+			size, err := client.Backup(ctx, w)
+			if err == nil {
+				err = w.Flush()
+			}
+			if err == nil {
+				err = tmp.Sync()
+			}
+			if err == nil {
+				err = tmp.Close()
+			}
+			if err != nil {
+				return fmt.Errorf("could not back up: %w", err)
+			}
+
+			if err := os.Rename(tmp.Name(), path); err != nil {
+				return fmt.Errorf("could not write %s: %w", path, err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Wrote %d byte backup to %s\n", size, path)
+			return nil
+		},
+	}
+}
+
+func RestoreCmd() *cobra.Command {
+	var merge bool
+
+	cmd := &cobra.Command{
+		Use:   "restore FILE",
+		Short: "Loads a snapshot written by kv backup into the KVD service",
+		Long: `Sends the snapshot in FILE to the server, which verifies its checksum and
+then applies it atomically. By default every existing key is replaced; with
+--merge, keys not in the snapshot are kept.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return fmt.Errorf("could not open %s: %w", args[0], err)
+			}
+			defer f.Close()
+
+			mode := kvd.RestoreReplace
+			if merge {
+				mode = kvd.RestoreMerge
+			}
+
+			client := newClient()
+			result, err := client.Restore(context.Background(), bufio.NewReader(f), mode)
+			if err != nil {
+				return fmt.Errorf("could not restore: %w", err)
+			}
+
+			fmt.Printf("Restored %d keys (%d removed, %d already expired)\n", result.Restored, result.Removed, result.Expired)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&merge, "merge", false, "Keep existing keys that are not in the snapshot")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(BackupCmd())
+	rootCmd.AddCommand(RestoreCmd())
+}
@@ -2,12 +2,12 @@ package kvcli
 
 import (
 	"fmt"
-	"github.com/drewnix/kvd/pkg/kvcli"
 	"github.com/spf13/cobra"
 )
 
 func DeleteCmd() *cobra.Command {
-	return &cobra.Command{
+	var partial bool
+	cmd := &cobra.Command{
 		Use:     "delete",
 		Aliases: []string{"del"},
 		Short:   "Delete a set of keys in the KVD service",
@@ -16,7 +16,7 @@ func DeleteCmd() *cobra.Command {
 				return fmt.Errorf("no keys provided to delete")
 			}
 			
-			client := kvcli.NewClient(ServerAddress)
+			client := newClient()
 			
 			if len(args) == 1 {
 				// Single key delete
@@ -24,6 +24,17 @@ func DeleteCmd() *cobra.Command {
 				if err != nil {
 					return fmt.Errorf("could not delete key: %w", err)
 				}
+			} else if partial {
+				// Bulk delete of whichever keys exist
+				deleted, missing, err := client.BulkDeletePartial(args)
+				if err != nil {
+					return fmt.Errorf("could not delete keys: %w", err)
+				}
+				for _, key := range missing {
+					fmt.Printf("%s: key not found\n", key)
+				}
+				fmt.Printf("%d keys deleted\n", len(deleted))
+				return nil
 			} else {
 				// Bulk delete
 				err := client.BulkDelete(args)
@@ -36,6 +47,9 @@ func DeleteCmd() *cobra.Command {
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&partial, "partial", false, "Delete the keys that exist instead of none when some are missing")
+
+	return cmd
 }
 
 func init() {
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/drewnix/kvd/pkg/kvcli"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +17,7 @@ func GetCmd() *cobra.Command {
 				return fmt.Errorf("no keys provided to get")
 			}
 			
-			client := kvcli.NewClient(ServerAddress)
+			client := newClient()
 			
 			if len(args) == 1 {
 				// Single key get
@@ -31,11 +30,14 @@ func GetCmd() *cobra.Command {
 					return err
 				}
 			} else {
-				// Bulk get
-				results, err := client.BulkGet(args)
+				// Bulk get, printing whichever keys exist
+				results, missing, err := client.BulkGetPartial(args)
 				if err != nil {
 					return fmt.Errorf("could not get keys: %w", err)
 				}
+				for _, key := range missing {
+					fmt.Fprintf(os.Stderr, "%s: key not found\n", key)
+				}
 				
 				// Print in order of requested keys
 				for _, key := range args {
@@ -0,0 +1,90 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func LockCmd() *cobra.Command {
+	var ttl time.Duration
+	var wait time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "lock NAME -- COMMAND [ARGS...]",
+		Short: "Runs a command while holding a lock in the KVD service",
+		Long: `Acquires the named lock, runs the command while keeping the lock alive, and
+releases the lock when the command exits. The fencing token is passed to the
+command in the KVD_LOCK_TOKEN environment variable.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			command := args[1:]
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			client := newClient()
+
+			lockCtx := ctx
+			if wait > 0 {
+				var cancel context.CancelFunc
+				lockCtx, cancel = context.WithTimeout(ctx, wait)
+				defer cancel()
+			}
+
+			lock, err := client.Lock(lockCtx, name, ttl)
+			if err != nil {
+				return fmt.Errorf("could not acquire lock %s: %w", name, err)
+			}
+
+			// Stop the command if the lock is lost or we are interrupted
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				select {
+				case <-lock.Lost():
+					fmt.Fprintf(os.Stderr, "Lost lock %s, stopping command\n", name)
+					cancel()
+				case <-runCtx.Done():
+				}
+			}()
+
+			child := exec.CommandContext(runCtx, command[0], command[1:]...)
+			child.Stdin = os.Stdin
+			child.Stdout = os.Stdout
+			child.Stderr = os.Stderr
+			child.Env = append(os.Environ(),
+				"KVD_LOCK_NAME="+name,
+				"KVD_LOCK_TOKEN="+strconv.FormatInt(lock.Token, 10),
+			)
+			runErr := child.Run()
+
+			if err := client.Unlock(context.Background(), lock); err != nil {
+				fmt.Fprintf(os.Stderr, "Error releasing lock %s: %v\n", name, err)
+			}
+
+			if runErr != nil {
+				return fmt.Errorf("command failed: %w", runErr)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&ttl, "ttl", 10*time.Second, "Lease TTL for the lock")
+	cmd.Flags().DurationVar(&wait, "wait", 0, "Maximum time to wait for the lock (0 waits forever)")
+
+	return cmd
+}
+
+func init() {
+	var lockCmd = LockCmd()
+	rootCmd.AddCommand(lockCmd)
+}
@@ -0,0 +1,38 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func LogLevelCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "loglevel [LEVEL]",
+		Short: "Shows or changes the log level of the KVD service",
+		Long: `Prints the server's log level, or sets it to LEVEL (debug, info, warn or
+error) without restarting the server.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client := newClient()
+
+			var level string
+			var err error
+			if len(args) == 1 {
+				if level, err = client.SetLogLevel(context.Background(), args[0]); err != nil {
+					return fmt.Errorf("could not set log level: %w", err)
+				}
+			} else if level, err = client.LogLevel(context.Background()); err != nil {
+				return fmt.Errorf("could not get log level: %w", err)
+			}
+
+			fmt.Println(level)
+			return nil
+		},
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(LogLevelCmd())
+}
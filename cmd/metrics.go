@@ -2,8 +2,10 @@ package kvcli
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
-	"github.com/drewnix/kvd/pkg/kvcli"
+	"github.com/drewnix/kvd/pkg/kvd"
 	"github.com/spf13/cobra"
 )
 
@@ -14,7 +16,7 @@ func init() {
 		Short:   "Gets metrics from the KVD service",
 		Args:    cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client := kvcli.NewClient(ServerAddress)
+			client := newClient()
 			
 			metrics, err := client.GetMetrics()
 			if err != nil {
@@ -26,6 +28,58 @@ func init() {
 			fmt.Println("Set Operations:", metrics.SetOps)
 			fmt.Println("Get Operations:", metrics.GetOps)
 			fmt.Println("Delete Operations:", metrics.DelOps)
+			fmt.Printf("Lock Wait: %v over %d acquisitions\n", time.Duration(metrics.LockWaitNanos), metrics.LockAcquisitions)
+			
+			if len(metrics.Latency) > 0 {
+				ops := make([]string, 0, len(metrics.Latency))
+				for op := range metrics.Latency {
+					ops = append(ops, op)
+				}
+				sort.Strings(ops)
+				
+				fmt.Println("Latency:")
+				for _, op := range ops {
+					h := metrics.Latency[op]
+					if h.Count == 0 {
+						continue
+					}
+					fmt.Printf("  %s: count=%d mean=%v p50=%v p90=%v p99=%v max=%v\n", op, h.Count,
+						time.Duration(h.Mean()), time.Duration(h.Quantile(0.5)), time.Duration(h.Quantile(0.9)),
+						time.Duration(h.Quantile(0.99)), time.Duration(h.Max))
+				}
+			}
+			printSizes("Value Size (bytes)", metrics.ValueSize)
+			printSizes("Bulk Batch Size", metrics.BatchSize)
+			if metrics.AuditWritten+metrics.AuditDropped+metrics.AuditFailed > 0 {
+				fmt.Printf("Audit Entries: written=%d dropped=%d failed=%d\n", metrics.AuditWritten, metrics.AuditDropped, metrics.AuditFailed)
+			}
+			
+			if len(metrics.Channels) > 0 {
+				channels := make([]string, 0, len(metrics.Channels))
+				for name := range metrics.Channels {
+					channels = append(channels, name)
+				}
+				sort.Strings(channels)
+				
+				fmt.Println("Channels:")
+				for _, name := range channels {
+					c := metrics.Channels[name]
+					fmt.Printf("  %s: published=%d delivered=%d dropped=%d\n", name, c.Published, c.Delivered, c.Dropped)
+				}
+			}
+			
+			if len(metrics.RateLimited) > 0 {
+				scopes := make([]string, 0, len(metrics.RateLimited))
+				for scope := range metrics.RateLimited {
+					scopes = append(scopes, scope)
+				}
+				sort.Strings(scopes)
+				
+				fmt.Println("Rate Limited Requests:")
+				for _, scope := range scopes {
+					fmt.Printf("  %s: %d\n", scope, metrics.RateLimited[scope])
+				}
+			}
 			
 			return nil
 		},
@@ -33,3 +87,12 @@ func init() {
 
 	rootCmd.AddCommand(metricsCmd)
 }
+
+// printSizes prints a summary of a size histogram
+func printSizes(name string, h kvd.HistogramSnapshot) {
+	if h.Count == 0 {
+		return
+	}
+	fmt.Printf("%s: count=%d mean=%.0f p50=%d p90=%d p99=%d max=%d\n", name, h.Count,
+		h.Mean(), h.Quantile(0.5), h.Quantile(0.9), h.Quantile(0.99), h.Max)
+}
@@ -0,0 +1,109 @@
+package kvcli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Environment variables read by kv
+const (
+	TokenEnv   = "KVD_TOKEN"
+	ProfileEnv = "KVD_PROFILE"
+)
+
+// defaultProfile is used when no profile is named
+const defaultProfile = "default"
+
+// Profile holds the connection settings for one server. Profiles are kept
+// by name in profiles.json under the user's config directory, e.g.
+// ~/.config/kvd/profiles.json.
+type Profile struct {
+	Server   string `json:"Server,omitempty"`
+	Token    string `json:"Token,omitempty"`
+	CACert   string `json:"CACert,omitempty"`
+	Cert     string `json:"Cert,omitempty"`
+	Key      string `json:"Key,omitempty"`
+	Insecure bool   `json:"Insecure,omitempty"`
+}
+
+// ProfileName selects a profile from the profiles file
+var ProfileName string
+
+// Token authenticates requests to servers that require it
+var Token string
+
+// profilesPath returns the location of the profiles file
+func profilesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find config directory: %w", err)
+	}
+	return filepath.Join(dir, "kvd", "profiles.json"), nil
+}
+
+// loadProfile reads a profile by name. Without a profiles file, or without
+// the default profile in it, the default profile is empty; other profiles
+// must exist.
+func loadProfile(name string) (Profile, error) {
+	path, err := profilesPath()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	var profiles map[string]Profile
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Profile{}, fmt.Errorf("could not read profiles: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return Profile{}, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+	}
+
+	profile, ok := profiles[name]
+	if !ok && name != defaultProfile {
+		return Profile{}, fmt.Errorf("no profile %q in %s", name, path)
+	}
+	return profile, nil
+}
+
+// applyProfile fills in the connection settings not given as flags from
+// the environment and then the selected profile
+func applyProfile() error {
+	flags := rootCmd.PersistentFlags()
+
+	name := ProfileName
+	if !flags.Changed("profile") {
+		if env := os.Getenv(ProfileEnv); env != "" {
+			name = env
+		}
+	}
+	profile, err := loadProfile(name)
+	if err != nil {
+		return err
+	}
+
+	if !flags.Changed("token") {
+		Token = os.Getenv(TokenEnv)
+		if Token == "" {
+			Token = profile.Token
+		}
+	}
+	fromProfile := func(flag string, target *string, value string) {
+		if !flags.Changed(flag) && value != "" {
+			*target = value
+		}
+	}
+	fromProfile("server", &ServerAddress, profile.Server)
+	fromProfile("cacert", &CACertFile, profile.CACert)
+	fromProfile("cert", &ClientCertFile, profile.Cert)
+	fromProfile("key", &ClientKeyFile, profile.Key)
+	if !flags.Changed("insecure") && profile.Insecure {
+		Insecure = true
+	}
+	return nil
+}
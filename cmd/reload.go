@@ -0,0 +1,44 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func ReloadCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reload",
+		Short: "Reloads the configuration of the KVD service",
+		Long: `Makes the server read its configuration file and KVD_* environment
+variables again, as SIGHUP does. Log level, request and rate limits and the
+other live settings change at once; changes to settings such as the ports
+are listed as needing a restart.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := newClient().Reload(context.Background())
+			if err != nil {
+				return fmt.Errorf("could not reload configuration: %w", err)
+			}
+
+			if len(result.Applied) == 0 {
+				fmt.Println("Applied: no changes")
+			} else {
+				fmt.Println("Applied:", strings.Join(result.Applied, ", "))
+			}
+			if result.TokensReloaded {
+				fmt.Println("Auth tokens reloaded")
+			}
+			if len(result.RestartRequired) > 0 {
+				fmt.Println("Restart required for:", strings.Join(result.RestartRequired, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(ReloadCmd())
+}
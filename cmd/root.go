@@ -1,19 +1,35 @@
 package kvcli
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
 
+	"github.com/drewnix/kvd/pkg/kvcli"
+	"github.com/drewnix/kvd/pkg/kvd"
 	"github.com/spf13/cobra"
 )
 
 // ServerAddress is the default address for connecting to the KVD server
 var ServerAddress string
 
+// LegacyBulkRoutes makes bulk commands use the pre-_mget routes, and makes
+// serve accept them as well
+var LegacyBulkRoutes bool
+
+// TLS settings for https:// servers
+var (
+	CACertFile     string
+	ClientCertFile string
+	ClientKeyFile  string
+	Insecure       bool
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "kv",
-	Short: "kv - a simple CLI to store key value pairs in the kvd service",
+	Use:     "kv",
+	Version: kvd.Version,
+	Short:   "kv - a simple CLI to store key value pairs in the kvd service",
 	Long: `kv is a command line interface for interacting with the kvd key-value store service.
 It allows you to set, get, and delete keys, as well as view metrics about the store.`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -35,4 +51,56 @@ func Execute() {
 func init() {
 	// Define persistent flags used by all commands
 	rootCmd.PersistentFlags().StringVar(&ServerAddress, "server", "http://localhost:8080", "Server address (e.g., http://localhost:8080)")
+	rootCmd.PersistentFlags().BoolVar(&LegacyBulkRoutes, "legacy-bulk-routes", false, "Send bulk operations using the routes of older servers; with serve, also accept them")
+	rootCmd.PersistentFlags().StringVar(&CACertFile, "cacert", "", "CA bundle to verify the server's certificate with")
+	rootCmd.PersistentFlags().StringVar(&ClientCertFile, "cert", "", "Client certificate for servers that require one")
+	rootCmd.PersistentFlags().StringVar(&ClientKeyFile, "key", "", "Private key of the client certificate")
+	rootCmd.PersistentFlags().BoolVarP(&Insecure, "insecure", "k", false, "Skip verification of the server's certificate")
+	rootCmd.PersistentFlags().StringVar(&Token, "token", "", "API token (default: $"+TokenEnv+" or the profile's token)")
+	rootCmd.PersistentFlags().StringVar(&ProfileName, "profile", defaultProfile, "Profile to read the server, token and TLS files from (or $"+ProfileEnv+")")
+}
+
+// newClient creates a client for ServerAddress configured from the
+// persistent flags
+func newClient() *kvcli.Client {
+	err := applyProfile()
+	var opts []kvcli.ClientOption
+	if err == nil {
+		opts, err = clientOptions()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	client := kvcli.NewClient(ServerAddress, opts...)
+	client.UseLegacyBulkRoutes(LegacyBulkRoutes)
+	return client
+}
+
+// clientOptions loads the TLS files and token named by the persistent
+// flags
+func clientOptions() ([]kvcli.ClientOption, error) {
+	var opts []kvcli.ClientOption
+	if CACertFile != "" {
+		pool, err := kvcli.LoadCertPool(CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kvcli.WithRootCAs(pool))
+	}
+	if ClientCertFile != "" || ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(ClientCertFile, ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		opts = append(opts, kvcli.WithClientCertificate(cert))
+	}
+	if Insecure {
+		opts = append(opts, kvcli.WithInsecureSkipVerify())
+	}
+	if Token != "" {
+		opts = append(opts, kvcli.WithToken(Token))
+	}
+	return opts, nil
 }
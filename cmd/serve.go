@@ -4,32 +4,48 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/drewnix/kvd/pkg/kvd"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
 )
 
+// configFileEnv names the configuration file when --config is not given
+const configFileEnv = "KVD_CONFIG"
+
+// flagSettings maps the serve flags not named after their setting
+var flagSettings = map[string]string{
+	"rate-limit-route":  "rate_limit_routes",
+	"rate-limit-prefix": "rate_limit_prefixes",
+}
+
 func ServeCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:     "serve",
 		Aliases: []string{"srv"},
 		Short:   "Run the KVD Service",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			startService()
+			startService(*kvd.DefaultConfig(), nil)
 			return nil
 		},
 	}
 }
 
-func startService() {
+// startService runs the service until it is stopped. load, when not nil,
+// reads the configuration again on SIGHUP or POST /admin/reload.
+func startService(config kvd.Config, load func() (*kvd.Config, error)) {
 	//res := kvd.StartService
 	var svc = kvd.Kvd{}
-	const defaultPort = 4000
-	var config = kvd.Config{}
-
-	config.Port = defaultPort
+	if load != nil {
+		svc.SetConfigLoader(load)
+	}
 
-	svc.Init(&config)
+	if err := svc.Init(&config); err != nil {
+		fmt.Println("Error starting service: ", err)
+		os.Exit(1)
+	}
 
 	ctx, err := svc.StartService(context.Background())
 	if err != nil {
@@ -41,8 +57,45 @@ func startService() {
 	fmt.Println("Shutting down KVD service")
 }
 
+// loadServeConfig builds the server configuration from, in increasing
+// precedence, the built-in defaults, the configuration file, the KVD_*
+// environment variables and the flags given on the command line, whose
+// values are in flagConfig
+func loadServeConfig(flags *pflag.FlagSet, flagConfig *kvd.Config, configFile string) (*kvd.Config, error) {
+	config := kvd.DefaultConfig()
+
+	if configFile == "" {
+		configFile = os.Getenv(configFileEnv)
+	}
+	if configFile != "" {
+		if err := kvd.LoadConfigFile(configFile, config); err != nil {
+			return nil, err
+		}
+	}
+	if err := kvd.ApplyConfigEnv(config, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	flags.Visit(func(f *pflag.Flag) {
+		name := strings.ReplaceAll(f.Name, "-", "_")
+		if setting, ok := flagSettings[f.Name]; ok {
+			name = setting
+		}
+		// Flags such as --config are not settings
+		if to, ok := kvd.ConfigSetting(config, name); ok {
+			from, _ := kvd.ConfigSetting(flagConfig, name)
+			to.Set(from)
+		}
+	})
+
+	return config, config.Validate()
+}
+
 func init() {
 	var daemon bool
+	var configFile string
+	var printConfig bool
+	config := *kvd.DefaultConfig()
 	var serveCmd = &cobra.Command{
 		Use:     "serve",
 		Aliases: []string{"srv"},
@@ -52,10 +105,73 @@ func init() {
 			if daemon {
 				fmt.Println("gonne start", daemon)
 			}
-			startService()
+
+			// --legacy-bulk-routes is the root's persistent flag, shared
+			// with the client commands
+			config.LegacyBulkRoutes = LegacyBulkRoutes
+			effective, err := loadServeConfig(cmd.Flags(), &config, configFile)
+			if err != nil {
+				fmt.Println("Error loading configuration:", err)
+				os.Exit(1)
+			}
+			if printConfig {
+				encoder := yaml.NewEncoder(os.Stdout)
+				encoder.SetIndent(2)
+				if err := encoder.Encode(effective); err != nil {
+					fmt.Println("Error printing configuration:", err)
+					os.Exit(1)
+				}
+				return
+			}
+			startService(*effective, func() (*kvd.Config, error) {
+				return loadServeConfig(cmd.Flags(), &config, configFile)
+			})
 		},
 	}
 	serveCmd.Flags().BoolVarP(&daemon, "deamon", "d", false, "is daemon?")
+	serveCmd.Flags().StringVar(&configFile, "config", "", "YAML or TOML configuration file (default: $"+configFileEnv+"); KVD_* variables override it and flags override both")
+	serveCmd.Flags().BoolVar(&printConfig, "print-config", false, "Print the effective configuration as YAML and exit")
+	serveCmd.Flags().StringVar(&config.Host, "host", config.Host, "Address to listen on")
+	serveCmd.Flags().IntVar(&config.Port, "port", config.Port, "Port for the HTTP API")
+	serveCmd.Flags().IntVar(&config.MaxRecords, "max-records", 0, "Most keys to store; writes of new keys beyond it fail with 507 (0 means no limit)")
+	serveCmd.Flags().StringVar(&config.DataDir, "data-dir", "", "Directory to save the data to on shutdown and load it from on start (empty keeps nothing)")
+	serveCmd.Flags().DurationVar(&config.SnapshotInterval, "snapshot-interval", 0, "How often to also save the data while running (0 saves only on shutdown)")
+	serveCmd.Flags().Int64Var(&config.MaxMemory, "max-memory", 0, "Heap size in bytes over which /readyz reports not ready (0 means no limit)")
+	serveCmd.Flags().DurationVar(&config.DrainDelay, "drain-delay", 0, "How long /readyz reports draining before the server stops on shutdown")
+	serveCmd.Flags().IntVar(&config.ScriptMaxSteps, "script-max-steps", config.ScriptMaxSteps, "Most steps a server-side script may run")
+	serveCmd.Flags().DurationVar(&config.ScriptTimeout, "script-timeout", config.ScriptTimeout, "Longest a server-side script may run")
+	serveCmd.Flags().IntVar(&config.RedisPort, "redis-port", 0, "Port for the Redis protocol listener (0 disables it)")
+	serveCmd.Flags().IntVar(&config.GRPCPort, "grpc-port", 0, "Port for the gRPC API (0 disables it)")
+	serveCmd.Flags().IntVar(&config.HotKeys, "hot-keys", 0, "Track the most read and written keys among this many candidates (0 disables it)")
+	serveCmd.Flags().StringVar(&config.TraceEndpoint, "trace-endpoint", "", "OTLP/HTTP URL to export OpenTelemetry traces to, e.g. http://localhost:4318 (tracing is off when empty)")
+	serveCmd.Flags().StringVar(&config.LogLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	serveCmd.Flags().StringVar(&config.LogFormat, "log-format", kvd.LogFormatText, "Log format: text or json")
+	serveCmd.Flags().StringVar(&config.LogKeys, "log-keys", kvd.LogKeysPlain, "How keys appear in logs: plain, hash or redact")
+	serveCmd.Flags().IntVar(&config.MemcachedPort, "memcached-port", 0, "Port for the memcached protocol listener (0 disables it)")
+	serveCmd.Flags().StringVar(&config.AuditFile, "audit-file", "", "Record every set and delete to this file, rotating it as it grows")
+	serveCmd.Flags().StringVar(&config.AuditWebhook, "audit-webhook", "", "Post audit entries as NDJSON to this URL instead of a file")
+	serveCmd.Flags().Int64Var(&config.AuditMaxSize, "audit-max-size", kvd.DefaultAuditMaxSize, "Size in bytes at which the audit file is rotated")
+	serveCmd.Flags().IntVar(&config.AuditMaxBackups, "audit-max-backups", kvd.DefaultAuditMaxBackups, "Rotated audit files to keep")
+	serveCmd.Flags().IntVar(&config.AuditQueueSize, "audit-queue-size", kvd.DefaultAuditQueueSize, "Audit entries to queue before dropping them")
+	serveCmd.Flags().BoolVar(&config.AuditHashValues, "audit-hash-values", false, "Include the SHA-256 of written values in audit entries")
+	serveCmd.Flags().StringVar(&config.TLSCertFile, "tls-cert", "", "Certificate file to serve HTTPS with; changes are picked up without a restart")
+	serveCmd.Flags().StringVar(&config.TLSKeyFile, "tls-key", "", "Private key file of the TLS certificate")
+	serveCmd.Flags().StringVar(&config.TLSMinVersion, "tls-min-version", kvd.TLSVersion12, "Minimum TLS version: 1.2 or 1.3")
+	serveCmd.Flags().StringSliceVar(&config.TLSCipherSuites, "tls-cipher-suites", nil, "Comma-separated TLS 1.2 cipher suites to allow (default: Go's secure suites)")
+	serveCmd.Flags().StringVar(&config.TLSClientCAFile, "tls-client-ca", "", "Require client certificates signed by a CA in this bundle")
+	serveCmd.Flags().BoolVar(&config.TLSClientCertOptional, "tls-client-cert-optional", false, "Only verify client certificates that are presented")
+	serveCmd.Flags().StringVar(&config.AuthFile, "auth-file", "", "Require API tokens, kept in this JSON file (an admin token is created if it has none)")
+	serveCmd.Flags().BoolVar(&config.AllowUnauthenticatedListeners, "allow-unauthenticated-listeners", false, "Allow the Redis, memcached and gRPC listeners with --auth-file, although they do not check tokens")
+
+	serveCmd.Flags().Float64Var(&config.RateLimit, "rate-limit", 0, "HTTP requests per second allowed to each client (0 means no limit)")
+	serveCmd.Flags().IntVar(&config.RateLimitBurst, "rate-limit-burst", 0, "Requests a client may send at once under --rate-limit (default: one second's worth)")
+	serveCmd.Flags().StringArrayVar(&config.RateLimitRoutes, "rate-limit-route", nil, "Per-client limit for a route, as 'POST /v1/_mset=RATE[:BURST]' (repeatable)")
+	serveCmd.Flags().StringArrayVar(&config.RateLimitPrefixes, "rate-limit-prefix", nil, "Per-client limit for keys with a prefix, as 'PREFIX=RATE[:BURST]' (repeatable)")
+	serveCmd.Flags().IntVar(&config.MaxKeyLength, "max-key-length", kvd.DefaultMaxKeyLength, "Longest key in bytes accepted over HTTP and gRPC")
+	serveCmd.Flags().IntVar(&config.MaxValueSize, "max-value-size", kvd.DefaultMaxValueSize, "Largest value in bytes accepted over HTTP and gRPC")
+	serveCmd.Flags().IntVar(&config.MaxBulkRecords, "max-bulk-records", kvd.DefaultMaxBulkRecords, "Most keys or records accepted in one bulk request")
+	serveCmd.Flags().Int64Var(&config.MaxBodySize, "max-body-size", kvd.DefaultMaxBodySize, "Largest JSON request body in bytes (streamed imports are checked per record)")
+	serveCmd.Flags().StringSliceVar(&config.ReservedPrefixes, "reserved-prefixes", []string{kvd.DefaultReservedPrefix}, "Key prefixes kept for internal use, which clients cannot write")
 
 	rootCmd.AddCommand(serveCmd)
 }
@@ -1,10 +1,55 @@
 package kvcli
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"github.com/spf13/pflag"
 )
 
 // Skip test for now since we're not running the server during tests
 func TestServe(t *testing.T) {
 	t.Skip("Skipping test that requires a running server")
 }
+
+func TestLoadServeConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kvd.yaml")
+	if err := os.WriteFile(file, []byte("port: 5000\nhost: 127.0.0.1\nlog_level: debug\nmax_records: 10\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configFileEnv, file)
+	t.Setenv("KVD_LOG_LEVEL", "warn")
+	t.Setenv("KVD_MAX_RECORDS", "20")
+
+	var flagConfig kvd.Config
+	flags := pflag.NewFlagSet("serve", pflag.ContinueOnError)
+	flags.IntVar(&flagConfig.Port, "port", 8080, "")
+	flags.IntVar(&flagConfig.MaxRecords, "max-records", 0, "")
+	flags.StringArrayVar(&flagConfig.RateLimitPrefixes, "rate-limit-prefix", nil, "")
+	if err := flags.Parse([]string{"--max-records", "30", "--rate-limit-prefix", "a/=1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadServeConfig(flags, &flagConfig, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flags beat the environment, which beats the file, which beats the
+	// defaults; unset flags do not override anything
+	if config.Port != 5000 || config.Host != "127.0.0.1" || config.LogLevel != "warn" || config.MaxRecords != 30 {
+		t.Errorf("Unexpected precedence: %+v", config)
+	}
+	if len(config.RateLimitPrefixes) != 1 || config.RateLimitPrefixes[0] != "a/=1" {
+		t.Errorf("Expected the rate limit prefix flag to apply, got %q", config.RateLimitPrefixes)
+	}
+	if config.MaxKeyLength != kvd.DefaultMaxKeyLength {
+		t.Errorf("Expected the default key length, got %d", config.MaxKeyLength)
+	}
+
+	t.Setenv("KVD_PORT", "0x10")
+	if _, err := loadServeConfig(flags, &flagConfig, ""); err == nil {
+		t.Error("Expected an error for a bad environment variable")
+	}
+}
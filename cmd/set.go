@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/drewnix/kvd/pkg/kvcli"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +17,7 @@ func SetCmd() *cobra.Command {
 				return fmt.Errorf("no key-value pairs provided to set")
 			}
 			
-			client := kvcli.NewClient(ServerAddress)
+			client := newClient()
 			
 			// Parse key=value pairs
 			if len(args) == 1 && strings.Contains(args[0], "=") {
@@ -0,0 +1,91 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"github.com/spf13/cobra"
+)
+
+func TokenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manages the API tokens of the KVD service",
+		Long: `Lists, creates and revokes API tokens. Managing tokens needs an admin
+token.`,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "Lists the API tokens",
+		Args:  cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tokens, err := newClient().Tokens(context.Background())
+			if err != nil {
+				return fmt.Errorf("could not list tokens: %w", err)
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tROLE\tPREFIXES\tCREATED")
+			for _, token := range tokens {
+				prefixes := strings.Join(token.Prefixes, ",")
+				if prefixes == "" {
+					prefixes = "*"
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", token.Name, token.Role, prefixes, token.Created.Format("2006-01-02 15:04"))
+			}
+			return tw.Flush()
+		},
+	})
+
+	var role string
+	var prefixes []string
+	create := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Creates an API token and prints its secret",
+		Long: `Creates an API token and prints its secret, which is not shown again.
+Roles are read, readwrite and admin. With --prefix the token can only
+reach keys starting with one of the given prefixes.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := newClient().CreateToken(context.Background(), kvd.TokenInfo{
+				Name:     args[0],
+				Role:     role,
+				Prefixes: prefixes,
+			})
+			if err != nil {
+				return fmt.Errorf("could not create token: %w", err)
+			}
+
+			fmt.Println(token.Token)
+			return nil
+		},
+	}
+	create.Flags().StringVar(&role, "role", kvd.RoleRead, "Role: read, readwrite or admin")
+	create.Flags().StringSliceVar(&prefixes, "prefix", nil, "Limit the token to keys with this prefix (repeatable)")
+	cmd.AddCommand(create)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete NAME",
+		Short: "Revokes an API token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := newClient().DeleteToken(context.Background(), args[0]); err != nil {
+				return fmt.Errorf("could not delete token: %w", err)
+			}
+
+			fmt.Println("Token deleted")
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(TokenCmd())
+}
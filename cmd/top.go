@@ -0,0 +1,133 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"github.com/spf13/cobra"
+)
+
+func TopCmd() *cobra.Command {
+	var limit int
+	var interval time.Duration
+	var once bool
+	var biggest bool
+
+	cmd := &cobra.Command{
+		Use:   "top",
+		Short: "Shows the most read and written keys and the largest values",
+		Long: `Shows the hottest keys, as tracked by a server started with --hot-keys, and
+the keys with the largest values, refreshing every --interval until
+interrupted. Rates are the change in a key's count since the last refresh.
+Finding the largest values walks every key on the server; --biggest=false
+skips it.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			client := newClient()
+			info, _ := os.Stdout.Stat()
+			live := !once && info != nil && info.Mode()&os.ModeCharDevice != 0
+
+			var previous *kvd.TopReport
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				report, err := client.Top(ctx, limit, biggest)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return fmt.Errorf("could not get top keys: %w", err)
+				}
+
+				if live {
+					// Clear the screen and move the cursor home
+					fmt.Print("\033[H\033[2J")
+				}
+				printTop(os.Stdout, report, previous, interval)
+				if once {
+					return nil
+				}
+				previous = report
+
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		},
+	}
+
+	cmd.Flags().IntVar(&limit, "limit", kvd.DefaultTopLimit, "Keys to show in each list")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "Time between refreshes")
+	cmd.Flags().BoolVar(&once, "once", false, "Print the report once and exit")
+	cmd.Flags().BoolVar(&biggest, "biggest", true, "Also list the keys with the largest values")
+
+	return cmd
+}
+
+// printTop writes a top report, with per-second rates when a previous
+// report is given
+func printTop(out io.Writer, report, previous *kvd.TopReport, interval time.Duration) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "kvd top - %s\n\n", time.Now().Format(time.TimeOnly))
+	if !report.Tracking {
+		fmt.Fprintln(w, "Hot-key tracking is off; start the server with --hot-keys to enable it.")
+	} else {
+		printHotKeys(w, "READS", report.Reads, previousHotKeys(previous, true), interval)
+		printHotKeys(w, "WRITES", report.Writes, previousHotKeys(previous, false), interval)
+	}
+
+	if report.Biggest != nil {
+		fmt.Fprintln(w, "BIGGEST VALUES\tBYTES")
+		for _, k := range report.Biggest {
+			fmt.Fprintf(w, "%s\t%d\n", k.Key, k.Size)
+		}
+	}
+}
+
+// previousHotKeys indexes the counts of the previous report's reads or
+// writes by key
+func previousHotKeys(previous *kvd.TopReport, reads bool) map[string]int64 {
+	counts := map[string]int64{}
+	if previous == nil {
+		return counts
+	}
+	keys := previous.Writes
+	if reads {
+		keys = previous.Reads
+	}
+	for _, k := range keys {
+		counts[k.Key] = k.Count
+	}
+	return counts
+}
+
+// printHotKeys writes one list of hot keys
+func printHotKeys(w io.Writer, title string, keys []kvd.HotKey, previous map[string]int64, interval time.Duration) {
+	fmt.Fprintf(w, "%s\tCOUNT\t±\tPER SEC\n", title)
+	for _, k := range keys {
+		rate := "-"
+		if before, ok := previous[k.Key]; ok {
+			rate = fmt.Sprintf("%.1f", float64(k.Count-before)/interval.Seconds())
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", k.Key, k.Count, k.Error, rate)
+	}
+	fmt.Fprintln(w)
+}
+
+func init() {
+	rootCmd.AddCommand(TopCmd())
+}
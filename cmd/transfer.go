@@ -0,0 +1,106 @@
+package kvcli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"github.com/spf13/cobra"
+)
+
+func ImportCmd() *cobra.Command {
+	var batchSize int
+
+	cmd := &cobra.Command{
+		Use:   "import [FILE]",
+		Short: "Loads NDJSON records from a file or stdin into the KVD service",
+		Long: `Reads one {"Key":...,"Value":...} record per line from FILE, or from stdin
+when FILE is omitted or "-", and stores them in batches. Progress is printed
+to stderr. Batches stored before an error are kept.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var in io.Reader = os.Stdin
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("could not open %s: %w", args[0], err)
+				}
+				defer f.Close()
+				in = f
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			client := newClient()
+			result, err := client.Import(ctx, bufio.NewReader(in), batchSize, func(p kvd.ImportProgress) {
+				if !p.Done && p.Error == "" {
+					fmt.Fprintf(os.Stderr, "Imported %d records\n", p.Imported)
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("could not import: %w", err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Imported %d records in %d batches\n", result.Imported, result.Batches)
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVar(&batchSize, "batch-size", kvd.DefaultTransferBatch, "Records stored per batch")
+
+	return cmd
+}
+
+func ExportCmd() *cobra.Command {
+	var pattern string
+
+	cmd := &cobra.Command{
+		Use:   "export [FILE]",
+		Short: "Writes records from the KVD service as NDJSON to a file or stdout",
+		Long: `Writes one {"Key":...,"Value":...} record per line, in key order, to FILE, or
+to stdout when FILE is omitted or "-".`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var out io.Writer = os.Stdout
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Create(args[0])
+				if err != nil {
+					return fmt.Errorf("could not create %s: %w", args[0], err)
+				}
+				defer f.Close()
+				out = f
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			w := bufio.NewWriter(out)
+			client := newClient()
+			count, err := client.Export(ctx, w, pattern)
+			if flushErr := w.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				return fmt.Errorf("could not export: %w", err)
+			}
+
+			fmt.Fprintf(os.Stderr, "Exported %d records\n", count)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&pattern, "pattern", "", "Only export keys matching this glob pattern")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(ImportCmd())
+	rootCmd.AddCommand(ExportCmd())
+}
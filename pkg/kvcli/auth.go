@@ -0,0 +1,54 @@
+package kvcli
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// WithToken authenticates requests with an API token
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// tokenTransport adds the client's token to each request
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+// RoundTrip sets the Authorization header and sends the request
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// Tokens lists the server's API tokens
+func (c *Client) Tokens(ctx context.Context) ([]kvd.TokenInfo, error) {
+	var tokens []kvd.TokenInfo
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/tokens", nil, http.StatusOK, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// CreateToken creates an API token and returns it with its secret, which
+// the server does not show again
+func (c *Client) CreateToken(ctx context.Context, info kvd.TokenInfo) (*kvd.NewToken, error) {
+	var token kvd.NewToken
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/tokens", info, http.StatusCreated, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteToken revokes an API token
+func (c *Client) DeleteToken(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/admin/tokens/"+url.PathEscape(name), nil, http.StatusNoContent, nil)
+}
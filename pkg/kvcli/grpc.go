@@ -0,0 +1,226 @@
+package kvcli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"github.com/drewnix/kvd/pkg/kvdpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// GRPCClient talks to the KVD gRPC API. Its methods mirror Client, with
+// Scan and Watch streaming results instead of polling.
+type GRPCClient struct {
+	conn    *grpc.ClientConn
+	kv      kvdpb.KVClient
+	timeout time.Duration
+}
+
+// NewGRPCClient connects to a KVD gRPC listener such as "localhost:4001".
+// Without options the connection is unencrypted.
+func NewGRPCClient(target string, opts ...grpc.DialOption) (*GRPCClient, error) {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+
+	return &GRPCClient{
+		conn:    conn,
+		kv:      kvdpb.NewKVClient(conn),
+		timeout: 10 * time.Second,
+	}, nil
+}
+
+// Close closes the underlying connection
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// Get retrieves a value for a given key
+func (c *GRPCClient) Get(key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("key cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.kv.Get(ctx, &kvdpb.GetRequest{Key: key})
+	if err != nil {
+		return "", fmt.Errorf("failed to get key: %w", err)
+	}
+	return resp.GetRecord().GetValue(), nil
+}
+
+// BulkGet retrieves multiple key-value pairs
+func (c *GRPCClient) BulkGet(keys []string) (map[string]string, error) {
+	if len(keys) == 0 {
+		return make(map[string]string), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.kv.BulkGet(ctx, &kvdpb.BulkGetRequest{Keys: keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	result := make(map[string]string, len(resp.Records))
+	for _, record := range resp.Records {
+		result[record.Key] = record.Value
+	}
+	return result, nil
+}
+
+// Set sets a value for a given key
+func (c *GRPCClient) Set(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if _, err := c.kv.Set(ctx, &kvdpb.SetRequest{Key: key, Value: value}); err != nil {
+		return fmt.Errorf("failed to set key: %w", err)
+	}
+	return nil
+}
+
+// BulkSet sets multiple key-value pairs
+func (c *GRPCClient) BulkSet(kvPairs map[string]string) error {
+	if len(kvPairs) == 0 {
+		return nil
+	}
+
+	req := &kvdpb.BulkSetRequest{Records: make([]*kvdpb.Record, 0, len(kvPairs))}
+	for key, value := range kvPairs {
+		req.Records = append(req.Records, &kvdpb.Record{Key: key, Value: value})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if _, err := c.kv.BulkSet(ctx, req); err != nil {
+		return fmt.Errorf("failed to set keys: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a key
+func (c *GRPCClient) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if _, err := c.kv.Delete(ctx, &kvdpb.DeleteRequest{Key: key}); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	return nil
+}
+
+// BulkDelete deletes multiple keys
+func (c *GRPCClient) BulkDelete(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if _, err := c.kv.BulkDelete(ctx, &kvdpb.BulkDeleteRequest{Keys: keys}); err != nil {
+		return fmt.Errorf("failed to delete keys: %w", err)
+	}
+	return nil
+}
+
+// GetMetrics retrieves metrics from the server
+func (c *GRPCClient) GetMetrics() (*Metrics, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	resp, err := c.kv.Metrics(ctx, &kvdpb.MetricsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics: %w", err)
+	}
+
+	return &Metrics{
+		KeysStored:       resp.KeysStored,
+		ValueBytesStored: resp.ValueBytesStored,
+		GetOps:           resp.GetOps,
+		SetOps:           resp.SetOps,
+		DelOps:           resp.DelOps,
+	}, nil
+}
+
+// Scan calls fn for every record whose key matches pattern, in key order.
+// Returning an error from fn stops the scan and returns that error.
+func (c *GRPCClient) Scan(ctx context.Context, pattern string, fn func(kvd.Record) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.kv.Scan(ctx, &kvdpb.ScanRequest{Pattern: pattern})
+	if err != nil {
+		return fmt.Errorf("failed to scan: %w", err)
+	}
+
+	for {
+		record, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to scan: %w", err)
+		}
+		if err := fn(kvd.Record{Key: record.Key, Value: record.Value}); err != nil {
+			return err
+		}
+	}
+}
+
+// Watch calls fn for every change to keys matching pattern until ctx is
+// done, fn returns an error, or the server ends the stream. It returns nil
+// when ctx is cancelled.
+func (c *GRPCClient) Watch(ctx context.Context, pattern string, fn func(kvd.WatchEvent) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.kv.Watch(ctx, &kvdpb.WatchRequest{Pattern: pattern})
+	if err != nil {
+		return fmt.Errorf("failed to watch: %w", err)
+	}
+
+	for {
+		event, err := stream.Recv()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("watch ended: %w", err)
+		}
+
+		e := kvd.WatchEvent{Key: event.Key, Value: event.Value, Version: event.Version}
+		switch event.Type {
+		case kvdpb.WatchEvent_TYPE_PUT:
+			e.Type = kvd.WatchPut
+		case kvdpb.WatchEvent_TYPE_DELETE:
+			e.Type = kvd.WatchDelete
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
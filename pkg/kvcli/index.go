@@ -0,0 +1,87 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// CreateIndex declares a secondary index over the JSON values of keys
+// starting with prefix, indexed by the dotted JSON path
+func (c *Client) CreateIndex(name, prefix, path string) error {
+	if name == "" {
+		return fmt.Errorf("index name cannot be empty")
+	}
+
+	spec := kvd.IndexSpec{Prefix: prefix, Path: path}
+	if err := c.doJSON(context.Background(), http.MethodPut, "/v1/_indexes/"+url.PathEscape(name), spec, http.StatusCreated, nil); err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	return nil
+}
+
+// DropIndex removes a secondary index
+func (c *Client) DropIndex(name string) error {
+	if err := c.doJSON(context.Background(), http.MethodDelete, "/v1/_indexes/"+url.PathEscape(name), nil, http.StatusOK, nil); err != nil {
+		return fmt.Errorf("failed to drop index: %w", err)
+	}
+	return nil
+}
+
+// ListIndexes returns the declared secondary indexes
+func (c *Client) ListIndexes() ([]kvd.IndexSpec, error) {
+	var specs []kvd.IndexSpec
+	if err := c.doJSON(context.Background(), http.MethodGet, "/v1/_indexes", nil, http.StatusOK, &specs); err != nil {
+		return nil, fmt.Errorf("failed to list indexes: %w", err)
+	}
+	return specs, nil
+}
+
+// QueryIndex returns the keys whose indexed value matches the query
+func (c *Client) QueryIndex(name string, q kvd.IndexQuery) ([]string, error) {
+	var keys []string
+	if err := c.doJSON(context.Background(), http.MethodGet, indexQueryPath(name, q, false), nil, http.StatusOK, &keys); err != nil {
+		return nil, fmt.Errorf("failed to query index: %w", err)
+	}
+	return keys, nil
+}
+
+// QueryIndexRecords returns the records whose indexed value matches the
+// query, in index order
+func (c *Client) QueryIndexRecords(name string, q kvd.IndexQuery) ([]kvd.Record, error) {
+	var records []kvd.Record
+	if err := c.doJSON(context.Background(), http.MethodGet, indexQueryPath(name, q, true), nil, http.StatusOK, &records); err != nil {
+		return nil, fmt.Errorf("failed to query index: %w", err)
+	}
+	return records, nil
+}
+
+// indexQueryPath builds the query URL path for an index lookup
+func indexQueryPath(name string, q kvd.IndexQuery, records bool) string {
+	params := url.Values{}
+	if q.Value != "" {
+		params.Set("value", q.Value)
+	}
+	if q.Min != "" {
+		params.Set("min", q.Min)
+	}
+	if q.Max != "" {
+		params.Set("max", q.Max)
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if records {
+		params.Set("records", "true")
+	}
+
+	path := "/v1/_indexes/" + url.PathEscape(name) + "/query"
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+	return path
+}
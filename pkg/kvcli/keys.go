@@ -0,0 +1,20 @@
+package kvcli
+
+import (
+	"net/url"
+	"strings"
+)
+
+// escapeKey escapes a key as a /v1/ path segment. A leading '_' is escaped
+// too so keys cannot be taken for routes such as /v1/_mget, and so are the
+// dots of "." and ".." so they are not removed from the path.
+func escapeKey(key string) string {
+	escaped := url.PathEscape(key)
+	if key == "." || key == ".." {
+		return strings.ReplaceAll(escaped, ".", "%2E")
+	}
+	if strings.HasPrefix(escaped, "_") {
+		return "%5F" + escaped[1:]
+	}
+	return escaped
+}
@@ -4,6 +4,7 @@ package kvcli
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,8 +19,24 @@ import (
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+
+	// legacyBulk sends bulk operations as JSON bodies on GET, PUT and
+	// DELETE /v1/ for servers that predate the _mget family of routes
+	legacyBulk bool
+
+	// tlsConfig is set by the TLS options
+	tlsConfig *tls.Config
+
+	// token is sent as a bearer token when set
+	token string
+
+	// rateLimitRetries is how often a request rejected with 429 is retried
+	rateLimitRetries int
 }
 
+// ClientOption configures a Client created by NewClient
+type ClientOption func(*Client)
+
 // Metrics represents the metrics returned by the KVD server
 type Metrics struct {
 	KeysStored       int64 `json:"KeysStored"`
@@ -27,26 +44,92 @@ type Metrics struct {
 	GetOps           int64 `json:"GetOps"`
 	SetOps           int64 `json:"SetOps"`
 	DelOps           int64 `json:"DelOps"`
+	LockAcquisitions int64 `json:"LockAcquisitions"`
+	LockWaitNanos    int64 `json:"LockWaitNanos"`
+
+	Latency   map[string]kvd.HistogramSnapshot `json:"Latency,omitempty"`
+	ValueSize kvd.HistogramSnapshot            `json:"ValueSize"`
+	BatchSize kvd.HistogramSnapshot            `json:"BatchSize"`
+
+	AuditWritten int64 `json:"AuditWritten,omitempty"`
+	AuditDropped int64 `json:"AuditDropped,omitempty"`
+	AuditFailed  int64 `json:"AuditFailed,omitempty"`
+
+	Channels map[string]kvd.ChannelMetrics `json:"Channels,omitempty"`
+
+	RateLimited map[string]int64 `json:"RateLimited,omitempty"`
+}
+
+// ServerError is returned when the server replies with an unexpected status
+type ServerError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server returned error: %s (status: %d)", e.Body, e.StatusCode)
 }
 
 // NewClient creates a new KVD client
-func NewClient(serverURL string) *Client {
-	return &Client{
-		baseURL: serverURL,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+func NewClient(serverURL string, opts ...ClientOption) *Client {
+	c := &Client{baseURL: serverURL, rateLimitRetries: DefaultRateLimitRetries}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var base http.RoundTripper = http.DefaultTransport
+	if c.tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tlsConfig
+		base = transport
+	}
+	if c.token != "" {
+		base = &tokenTransport{token: c.token, base: base}
+	}
+	if c.rateLimitRetries > 0 {
+		base = &retryTransport{retries: c.rateLimitRetries, base: base}
+	}
+	c.httpClient = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &tracingTransport{base: base},
+	}
+	return c
+}
+
+// UseLegacyBulkRoutes switches bulk operations to the JSON body forms of
+// GET, PUT and DELETE on /v1/, for servers older than the _mget, _mset and
+// _mdelete routes or run with legacy bulk routes enabled
+func (c *Client) UseLegacyBulkRoutes(enabled bool) {
+	c.legacyBulk = enabled
+}
+
+// bulkRoute returns the method and path for a bulk operation
+func (c *Client) bulkRoute(legacyMethod, route string) (string, string) {
+	if c.legacyBulk {
+		return legacyMethod, "/v1/"
 	}
+	return http.MethodPost, "/v1/" + route
 }
 
 // Get retrieves a value for a given key
 func (c *Client) Get(key string) (string, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext is Get with a context, whose trace context is sent along
+func (c *Client) GetContext(ctx context.Context, key string) (string, error) {
 	if key == "" {
 		return "", fmt.Errorf("key cannot be empty")
 	}
 
-	url := fmt.Sprintf("%s/v1/%s", c.baseURL, key)
-	resp, err := c.httpClient.Get(url)
+	url := fmt.Sprintf("%s/v1/%s", c.baseURL, escapeKey(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to get key: %w", err)
 	}
@@ -67,17 +150,23 @@ func (c *Client) Get(key string) (string, error) {
 
 // BulkGet retrieves multiple key-value pairs
 func (c *Client) BulkGet(keys []string) (map[string]string, error) {
+	return c.BulkGetContext(context.Background(), keys)
+}
+
+// BulkGetContext is BulkGet with a context, whose trace context is sent along
+func (c *Client) BulkGetContext(ctx context.Context, keys []string) (map[string]string, error) {
 	if len(keys) == 0 {
 		return make(map[string]string), nil
 	}
 
-	url := fmt.Sprintf("%s/v1/", c.baseURL)
+	method, path := c.bulkRoute(http.MethodGet, "_mget")
+	url := c.baseURL + path
 	jsonData, err := json.Marshal(keys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal keys: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -110,12 +199,17 @@ func (c *Client) BulkGet(keys []string) (map[string]string, error) {
 
 // Set sets a value for a given key
 func (c *Client) Set(key, value string) error {
+	return c.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set with a context, whose trace context is sent along
+func (c *Client) SetContext(ctx context.Context, key, value string) error {
 	if key == "" {
 		return fmt.Errorf("key cannot be empty")
 	}
 
-	url := fmt.Sprintf("%s/v1/%s", c.baseURL, key)
-	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(value))
+	url := fmt.Sprintf("%s/v1/%s", c.baseURL, escapeKey(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, strings.NewReader(value))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -136,11 +230,17 @@ func (c *Client) Set(key, value string) error {
 
 // BulkSet sets multiple key-value pairs
 func (c *Client) BulkSet(kvPairs map[string]string) error {
+	return c.BulkSetContext(context.Background(), kvPairs)
+}
+
+// BulkSetContext is BulkSet with a context, whose trace context is sent along
+func (c *Client) BulkSetContext(ctx context.Context, kvPairs map[string]string) error {
 	if len(kvPairs) == 0 {
 		return nil
 	}
 
-	url := fmt.Sprintf("%s/v1/", c.baseURL)
+	method, path := c.bulkRoute(http.MethodPut, "_mset")
+	url := c.baseURL + path
 	
 	// Convert map to records
 	records := make([]kvd.Record, 0, len(kvPairs))
@@ -156,7 +256,7 @@ func (c *Client) BulkSet(kvPairs map[string]string) error {
 		return fmt.Errorf("failed to marshal records: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -178,12 +278,17 @@ func (c *Client) BulkSet(kvPairs map[string]string) error {
 
 // Delete removes a key-value pair
 func (c *Client) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete with a context, whose trace context is sent along
+func (c *Client) DeleteContext(ctx context.Context, key string) error {
 	if key == "" {
 		return fmt.Errorf("key cannot be empty")
 	}
 
-	url := fmt.Sprintf("%s/v1/%s", c.baseURL, key)
-	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	url := fmt.Sprintf("%s/v1/%s", c.baseURL, escapeKey(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -204,17 +309,23 @@ func (c *Client) Delete(key string) error {
 
 // BulkDelete removes multiple key-value pairs
 func (c *Client) BulkDelete(keys []string) error {
+	return c.BulkDeleteContext(context.Background(), keys)
+}
+
+// BulkDeleteContext is BulkDelete with a context, whose trace context is sent along
+func (c *Client) BulkDeleteContext(ctx context.Context, keys []string) error {
 	if len(keys) == 0 {
 		return nil
 	}
 
-	url := fmt.Sprintf("%s/v1/", c.baseURL)
+	method, path := c.bulkRoute(http.MethodDelete, "_mdelete")
+	url := c.baseURL + path
 	jsonData, err := json.Marshal(keys)
 	if err != nil {
 		return fmt.Errorf("failed to marshal keys: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -234,6 +345,54 @@ func (c *Client) BulkDelete(keys []string) error {
 	return nil
 }
 
+// BulkGetPartial retrieves the key-value pairs that exist and returns the
+// keys that were missing, rather than failing if any key is missing
+func (c *Client) BulkGetPartial(keys []string) (map[string]string, []string, error) {
+	return c.BulkGetPartialContext(context.Background(), keys)
+}
+
+// BulkGetPartialContext is BulkGetPartial with a context, whose trace context is sent along
+func (c *Client) BulkGetPartialContext(ctx context.Context, keys []string) (map[string]string, []string, error) {
+	if len(keys) == 0 {
+		return make(map[string]string), []string{}, nil
+	}
+
+	var result kvd.BulkGetResult
+	method, path := c.bulkRoute(http.MethodGet, "_mget")
+	if err := c.doJSON(ctx, method, path+"?partial=true", keys, http.StatusOK, &result); err != nil {
+		return nil, nil, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	values := make(map[string]string, len(result.Records))
+	for _, record := range result.Records {
+		values[record.Key] = record.Value
+	}
+
+	return values, result.Missing, nil
+}
+
+// BulkDeletePartial removes the keys that exist and returns which keys
+// were deleted and which were missing, rather than deleting nothing if any
+// key is missing
+func (c *Client) BulkDeletePartial(keys []string) ([]string, []string, error) {
+	return c.BulkDeletePartialContext(context.Background(), keys)
+}
+
+// BulkDeletePartialContext is BulkDeletePartial with a context, whose trace context is sent along
+func (c *Client) BulkDeletePartialContext(ctx context.Context, keys []string) ([]string, []string, error) {
+	if len(keys) == 0 {
+		return []string{}, []string{}, nil
+	}
+
+	var result kvd.BulkDeleteResult
+	method, path := c.bulkRoute(http.MethodDelete, "_mdelete")
+	if err := c.doJSON(ctx, method, path+"?partial=true", keys, http.StatusOK, &result); err != nil {
+		return nil, nil, fmt.Errorf("failed to delete keys: %w", err)
+	}
+
+	return result.Deleted, result.Missing, nil
+}
+
 // GetMetrics retrieves metrics from the server
 func (c *Client) GetMetrics() (*Metrics, error) {
 	url := fmt.Sprintf("%s/metrics", c.baseURL)
@@ -260,3 +419,43 @@ func (c *Client) GetMetrics() (*Metrics, error) {
 func (c *Client) WithTimeout(d time.Duration) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), d)
 }
+
+// doJSON sends an optional JSON body to path and decodes the JSON response
+// into out when the server replies with the expected status
+func (c *Client) doJSON(ctx context.Context, method, path string, in interface{}, expected int, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		jsonData, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		respBody, _ := io.ReadAll(resp.Body)
+		return &ServerError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
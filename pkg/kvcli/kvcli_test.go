@@ -1,10 +1,21 @@
 package kvcli
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // MockResponse represents a predefined response for the mock server
@@ -165,20 +176,129 @@ func TestClientBulkOperations(t *testing.T) {
 	// Convert to JSON for response
 	recordsJSON, _ := json.Marshal(records)
 
-	// Define mock responses
+	modes := []struct {
+		name   string
+		legacy bool
+		set    string
+		get    string
+		delete string
+	}{
+		{"current", false, "POST /v1/_mset", "POST /v1/_mget", "POST /v1/_mdelete"},
+		{"legacy", true, "PUT /v1/", "GET /v1/", "DELETE /v1/"},
+	}
+
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			// Define mock responses
+			responses := map[string]MockResponse{
+				mode.set: {
+					StatusCode: http.StatusCreated,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+				},
+				mode.get: {
+					StatusCode: http.StatusOK,
+					Body:       recordsJSON,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+				},
+				mode.delete: {
+					StatusCode: http.StatusOK,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+				},
+			}
+
+			server := SetupMockServer(t, responses)
+			defer server.Close()
+
+			client := NewClient(server.URL)
+			client.UseLegacyBulkRoutes(mode.legacy)
+
+			// Create key-value pairs for bulk set
+			kvPairs := make(map[string]string)
+			for _, r := range records {
+				kvPairs[r.Key] = r.Value
+			}
+
+			// Test bulk set
+			err := client.BulkSet(kvPairs)
+			if err != nil {
+				t.Fatalf("Failed to bulk set: %v", err)
+			}
+
+			// Create key list for bulk get
+			keys := make([]string, 0, len(records))
+			for _, r := range records {
+				keys = append(keys, r.Key)
+			}
+
+			// Test bulk get
+			results, err := client.BulkGet(keys)
+			if err != nil {
+				t.Fatalf("Failed to bulk get: %v", err)
+			}
+
+			// Verify results
+			if len(results) != len(records) {
+				t.Errorf("Expected %d results, got %d", len(records), len(results))
+			}
+
+			for i, r := range records {
+				if results[r.Key] != r.Value {
+					t.Errorf("Entry %d: expected value '%s' for key '%s', got '%s'", i, r.Value, r.Key, results[r.Key])
+				}
+			}
+
+			// Test bulk delete
+			err = client.BulkDelete(keys)
+			if err != nil {
+				t.Fatalf("Failed to bulk delete: %v", err)
+			}
+		})
+	}
+}
+
+func TestClientBulkPartial(t *testing.T) {
 	responses := map[string]MockResponse{
-		"PUT /v1/": {
-			StatusCode: http.StatusCreated,
-			Headers:    map[string]string{"Content-Type": "application/json"},
+		"POST /v1/_mget": {
+			StatusCode: http.StatusOK,
+			Body:       `{"Records":[{"Key":"a","Value":"1"}],"Missing":["b"]}`,
 		},
-		"GET /v1/": {
+		"POST /v1/_mdelete": {
 			StatusCode: http.StatusOK,
-			Body:       recordsJSON,
-			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       `{"Deleted":["a"],"Missing":["b"]}`,
 		},
-		"DELETE /v1/": {
+	}
+
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	values, missing, err := client.BulkGetPartial([]string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Failed to bulk get: %v", err)
+	}
+	if values["a"] != "1" || len(missing) != 1 || missing[0] != "b" {
+		t.Errorf("Unexpected partial get result %v, %v", values, missing)
+	}
+
+	deleted, missing, err := client.BulkDeletePartial([]string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Failed to bulk delete: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "a" || len(missing) != 1 {
+		t.Errorf("Unexpected partial delete result %v, %v", deleted, missing)
+	}
+}
+
+func TestClientImportExport(t *testing.T) {
+	responses := map[string]MockResponse{
+		"POST /v1/_import": {
 			StatusCode: http.StatusOK,
-			Headers:    map[string]string{"Content-Type": "application/json"},
+			Body:       "{\"Imported\":1000,\"Batches\":1}\n{\"Imported\":1500,\"Batches\":2,\"Done\":true}\n",
+		},
+		"GET /v1/_export": {
+			StatusCode: http.StatusOK,
+			Body:       "{\"Key\":\"a\",\"Value\":\"1\"}\n{\"Key\":\"b\",\"Value\":\"2\"}\n",
 		},
 	}
 
@@ -187,45 +307,113 @@ func TestClientBulkOperations(t *testing.T) {
 
 	client := NewClient(server.URL)
 
-	// Create key-value pairs for bulk set
-	kvPairs := make(map[string]string)
-	for _, r := range records {
-		kvPairs[r.Key] = r.Value
+	reports := 0
+	result, err := client.Import(context.Background(), strings.NewReader("{}\n"), 1000, func(kvd.ImportProgress) {
+		reports++
+	})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if reports != 2 || result.Imported != 1500 {
+		t.Errorf("Expected 2 reports ending at 1500 records, got %d, %+v", reports, result)
 	}
 
-	// Test bulk set
-	err := client.BulkSet(kvPairs)
+	var out strings.Builder
+	count, err := client.Export(context.Background(), &out, "")
 	if err != nil {
-		t.Fatalf("Failed to bulk set: %v", err)
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if count != 2 || !strings.HasPrefix(out.String(), "{\"Key\":\"a\"") {
+		t.Errorf("Unexpected export of %d records: %q", count, out.String())
 	}
+}
 
-	// Create key list for bulk get
-	keys := make([]string, 0, len(records))
-	for _, r := range records {
-		keys = append(keys, r.Key)
+func TestClientBackupRestore(t *testing.T) {
+	snapshot := "{\"Format\":\"kvd-snapshot\",\"Version\":1}\n"
+	responses := map[string]MockResponse{
+		"GET /admin/backup": {
+			StatusCode: http.StatusOK,
+			Body:       snapshot,
+		},
+		"POST /admin/restore": {
+			StatusCode: http.StatusOK,
+			Body:       `{"Mode":"merge","Restored":3,"Removed":0,"Expired":1}`,
+		},
 	}
 
-	// Test bulk get
-	results, err := client.BulkGet(keys)
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	var out strings.Builder
+	size, err := client.Backup(context.Background(), &out)
 	if err != nil {
-		t.Fatalf("Failed to bulk get: %v", err)
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if size != int64(len(snapshot)) || out.String() != snapshot {
+		t.Errorf("Unexpected backup of %d bytes: %q", size, out.String())
 	}
 
-	// Verify results
-	if len(results) != len(records) {
-		t.Errorf("Expected %d results, got %d", len(records), len(results))
+	result, err := client.Restore(context.Background(), strings.NewReader(snapshot), kvd.RestoreMerge)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
 	}
+	if result.Restored != 3 || result.Expired != 1 {
+		t.Errorf("Unexpected restore result %+v", result)
+	}
+}
 
-	for i, r := range records {
-		if results[r.Key] != r.Value {
-			t.Errorf("Entry %d: expected value '%s' for key '%s', got '%s'", i, r.Value, r.Key, results[r.Key])
-		}
+func TestClientTop(t *testing.T) {
+	responses := map[string]MockResponse{
+		"GET /admin/top": {
+			StatusCode: http.StatusOK,
+			Body:       `{"Tracking":true,"Reads":[{"Key":"a","Count":5,"Error":0}],"Writes":[],"Biggest":[{"Key":"b","Size":42}]}`,
+		},
 	}
 
-	// Test bulk delete
-	err = client.BulkDelete(keys)
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	report, err := client.Top(context.Background(), 10, true)
 	if err != nil {
-		t.Fatalf("Failed to bulk delete: %v", err)
+		t.Fatalf("Failed to get top keys: %v", err)
+	}
+	if !report.Tracking || report.Reads[0].Key != "a" || report.Biggest[0].Size != 42 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func TestClientTraceContext(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("value"))
+	}))
+	defer server.Close()
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	client := NewClient(server.URL)
+	if _, err := client.GetContext(ctx, "a"); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if traceparent != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Unexpected traceparent %q", traceparent)
+	}
+
+	if _, err := client.Get("a"); err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if traceparent != "" {
+		t.Errorf("Expected no traceparent without a trace, got %q", traceparent)
 	}
 }
 
@@ -275,4 +463,333 @@ func TestClientMetrics(t *testing.T) {
 	if result.DelOps != 20 {
 		t.Errorf("Expected DelOps to be 20, got %d", result.DelOps)
 	}
-}
\ No newline at end of file
+}
+func TestClientLock(t *testing.T) {
+	// Define mock responses
+	responses := map[string]MockResponse{
+		"POST /v1/_leases": {
+			StatusCode: http.StatusCreated,
+			Body:       map[string]int64{"ID": 7, "TTL": 10, "Remaining": 10},
+			Headers:    map[string]string{"Content-Type": "application/json"},
+		},
+		"POST /v1/_locks/deploy": {
+			StatusCode: http.StatusOK,
+			Body:       map[string]interface{}{"Name": "deploy", "Token": 42, "LeaseID": 7},
+			Headers:    map[string]string{"Content-Type": "application/json"},
+		},
+		"POST /v1/_locks/busy": {
+			StatusCode: http.StatusConflict,
+			Body:       "lock is held by another lease",
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+		},
+		"DELETE /v1/_locks/deploy": {
+			StatusCode: http.StatusOK,
+		},
+		"DELETE /v1/_leases/7": {
+			StatusCode: http.StatusOK,
+		},
+	}
+
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	// Test acquiring and releasing a free lock
+	lock, err := client.Lock(context.Background(), "deploy", 10*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	if lock.Token != 42 || lock.LeaseID != 7 {
+		t.Errorf("Expected token 42 and lease 7, got %d and %d", lock.Token, lock.LeaseID)
+	}
+	if err := client.Unlock(context.Background(), lock); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+
+	// Test that a held lock reports ErrLockHeld
+	if _, err := client.TryLock(context.Background(), "busy", 7); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("Expected ErrLockHeld, got %v", err)
+	}
+
+	// Test that waiting on a held lock honors the context
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := client.Lock(ctx, "busy", 10*time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestLockKeepAliveRetries(t *testing.T) {
+	// Lease 1 renews after two failures and is then lost; lease 2 never
+	// renews and expires
+	var leases, renewals atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/_leases":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"ID":%d,"TTL":1,"Remaining":1}`, leases.Add(1))
+		case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/_locks/"):
+			var req struct{ LeaseID int64 }
+			json.NewDecoder(r.Body).Decode(&req)
+			fmt.Fprintf(w, `{"Name":"x","Token":1,"LeaseID":%d}`, req.LeaseID)
+		case r.URL.Path == "/v1/_leases/1/keepalive":
+			switch n := renewals.Add(1); {
+			case n <= 2:
+				http.Error(w, "Server is loading data", http.StatusServiceUnavailable)
+			case n == 3:
+				fmt.Fprint(w, `{"ID":1,"TTL":1,"Remaining":1}`)
+			default:
+				http.Error(w, "lease not found", http.StatusNotFound)
+			}
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	start := time.Now()
+	renewedLock, err := client.Lock(context.Background(), "a", time.Second)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	expiringLock, err := client.Lock(context.Background(), "b", time.Second)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	select {
+	case <-expiringLock.Lost():
+		if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+			t.Errorf("Expected the lock to be kept until its TTL ran out, lost after %v", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the lock to be lost once its TTL ran out")
+	}
+	select {
+	case <-renewedLock.Lost():
+		t.Fatal("Expected failed renewals to be retried")
+	default:
+	}
+
+	select {
+	case <-renewedLock.Lost():
+		if renewals.Load() != 4 {
+			t.Errorf("Expected the lock to be lost when the lease was not found, after %d renewals", renewals.Load())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected the lock to be lost when the lease was not found")
+	}
+}
+
+func TestClientPublish(t *testing.T) {
+	// Define mock responses
+	responses := map[string]MockResponse{
+		"POST /v1/_channels/orders.created": {
+			StatusCode: http.StatusOK,
+			Body:       map[string]int{"Receivers": 3},
+			Headers:    map[string]string{"Content-Type": "application/json"},
+		},
+	}
+
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	// Test publishing to a channel
+	receivers, err := client.Publish("orders.created", "payload")
+	if err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	if receivers != 3 {
+		t.Errorf("Expected 3 receivers, got %d", receivers)
+	}
+
+	// Test publishing to an empty channel name
+	if _, err := client.Publish("", "payload"); err == nil {
+		t.Error("Expected error for empty channel, got nil")
+	}
+}
+
+func TestClientScripts(t *testing.T) {
+	// Define mock responses
+	responses := map[string]MockResponse{
+		"POST /v1/_scripts": {
+			StatusCode: http.StatusCreated,
+			Body:       map[string]string{"Hash": "abc123"},
+			Headers:    map[string]string{"Content-Type": "application/json"},
+		},
+		"POST /v1/_scripts/abc123": {
+			StatusCode: http.StatusOK,
+			Body:       map[string]int64{"Result": 70},
+			Headers:    map[string]string{"Content-Type": "application/json"},
+		},
+		"POST /v1/_scripts/missing": {
+			StatusCode: http.StatusNotFound,
+			Body:       "script not found",
+			Headers:    map[string]string{"Content-Type": "text/plain"},
+		},
+	}
+
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+
+	// Test loading a script
+	hash, err := client.LoadScript("return 70")
+	if err != nil {
+		t.Fatalf("Failed to load script: %v", err)
+	}
+	if hash != "abc123" {
+		t.Errorf("Expected hash 'abc123', got '%s'", hash)
+	}
+
+	// Test running a script returns integers as int64
+	result, err := client.RunScript(hash, []string{"a"}, []string{"1"})
+	if err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	if result != int64(70) {
+		t.Errorf("Expected result 70, got %v (%T)", result, result)
+	}
+
+	// Test running an unknown script
+	if _, err := client.RunScript("missing", nil, nil); err == nil {
+		t.Error("Expected error for unknown script, got nil")
+	}
+}
+
+func TestClientLogLevel(t *testing.T) {
+	responses := map[string]MockResponse{
+		"PUT /admin/loglevel": {
+			StatusCode: http.StatusOK,
+			Body:       `{"Level":"DEBUG"}`,
+		},
+	}
+
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	level, err := client.SetLogLevel(context.Background(), "debug")
+	if err != nil {
+		t.Fatalf("Failed to set log level: %v", err)
+	}
+	if level != "DEBUG" {
+		t.Errorf("Expected DEBUG, got %q", level)
+	}
+}
+
+func TestClientReload(t *testing.T) {
+	responses := map[string]MockResponse{
+		"POST /admin/reload": {
+			StatusCode: http.StatusOK,
+			Body:       `{"Applied":["log_level"],"RestartRequired":["port"],"TokensReloaded":false}`,
+		},
+	}
+
+	server := SetupMockServer(t, responses)
+	defer server.Close()
+
+	result, err := NewClient(server.URL).Reload(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	if len(result.Applied) != 1 || result.Applied[0] != "log_level" || len(result.RestartRequired) != 1 || result.RestartRequired[0] != "port" {
+		t.Errorf("Unexpected reload result %+v", result)
+	}
+}
+
+func TestClientTLSOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("value"))
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL).Get("a"); err == nil {
+		t.Error("Expected an untrusted certificate to be rejected")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	if value, err := NewClient(server.URL, WithRootCAs(pool)).Get("a"); err != nil || value != "value" {
+		t.Errorf("Expected the CA option to trust the server, got %q %v", value, err)
+	}
+
+	if _, err := NewClient(server.URL, WithInsecureSkipVerify()).Get("a"); err != nil {
+		t.Errorf("Expected verification to be skipped, got %v", err)
+	}
+}
+
+func TestClientToken(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(kvd.NewToken{TokenInfo: kvd.TokenInfo{Name: "ci", Role: kvd.RoleRead}, Token: "kvd_secret"})
+	}))
+	defer server.Close()
+
+	token, err := NewClient(server.URL, WithToken("kvd_admin")).CreateToken(context.Background(), kvd.TokenInfo{Name: "ci", Role: kvd.RoleRead})
+	if err != nil || token.Token != "kvd_secret" || token.Name != "ci" {
+		t.Fatalf("Unexpected token %+v %v", token, err)
+	}
+	if auth != "Bearer kvd_admin" {
+		t.Errorf("Expected the token in the Authorization header, got %q", auth)
+	}
+}
+
+func TestClientRateLimitRetry(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		if len(requests)%3 != 0 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	// The body is sent again with each retry
+	if err := NewClient(server.URL).Set("a", "value"); err != nil {
+		t.Fatalf("Expected the request to succeed after retries, got %v", err)
+	}
+	if len(requests) != 3 || requests[2] != "value" {
+		t.Errorf("Expected 3 attempts with the body, got %q", requests)
+	}
+
+	requests = nil
+	err := NewClient(server.URL, WithRateLimitRetries(0)).Set("a", "value")
+	if err == nil || !strings.Contains(err.Error(), "429") || len(requests) != 1 {
+		t.Errorf("Expected the 429 without retries, got %v after %d requests", err, len(requests))
+	}
+}
+
+func TestClientEscapesKeys(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	for _, key := range []string{"a/b", "_mget", "..", "a b?#"} {
+		if err := client.Set(key, "v"); err != nil {
+			t.Fatalf("Set %q failed: %v", key, err)
+		}
+	}
+	want := []string{"/v1/a%2Fb", "/v1/%5Fmget", "/v1/%2E%2E", "/v1/a%20b%3F%23"}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Expected path %s, got %s", want[i], paths[i])
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package kvcli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// ErrLockHeld is returned by TryLock when another lease holds the lock
+var ErrLockHeld = errors.New("lock is held by another client")
+
+// lockRetryInterval is how long Lock waits between acquisition attempts
+const lockRetryInterval = 250 * time.Millisecond
+
+// Lock is a held distributed lock. The lock's lease is kept alive in the
+// background until Unlock is called or the lease is lost.
+type Lock struct {
+	Name    string
+	Token   int64
+	LeaseID int64
+
+	stop     chan struct{}
+	lost     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Lost returns a channel that is closed once the lock's lease is gone: the
+// server no longer knows it, or it could not be renewed before its TTL ran
+// out. Work guarded by the lock should stop when it fires.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// GrantLease creates a lease on the server with the given TTL, rounded up
+// to whole seconds
+func (c *Client) GrantLease(ctx context.Context, ttl time.Duration) (*kvd.LeaseInfo, error) {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+
+	var info kvd.LeaseInfo
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/_leases", map[string]int64{"TTL": seconds}, http.StatusCreated, &info); err != nil {
+		return nil, fmt.Errorf("failed to grant lease: %w", err)
+	}
+	return &info, nil
+}
+
+// KeepAlive renews a lease for another full TTL
+func (c *Client) KeepAlive(ctx context.Context, leaseID int64) (*kvd.LeaseInfo, error) {
+	var info kvd.LeaseInfo
+	path := fmt.Sprintf("/v1/_leases/%d/keepalive", leaseID)
+	if err := c.doJSON(ctx, http.MethodPut, path, nil, http.StatusOK, &info); err != nil {
+		return nil, fmt.Errorf("failed to keep lease alive: %w", err)
+	}
+	return &info, nil
+}
+
+// RevokeLease ends a lease, deleting its keys and releasing its locks
+func (c *Client) RevokeLease(ctx context.Context, leaseID int64) error {
+	path := fmt.Sprintf("/v1/_leases/%d", leaseID)
+	if err := c.doJSON(ctx, http.MethodDelete, path, nil, http.StatusOK, nil); err != nil {
+		return fmt.Errorf("failed to revoke lease: %w", err)
+	}
+	return nil
+}
+
+// SetWithLease sets a value for a key and attaches it to a lease
+func (c *Client) SetWithLease(ctx context.Context, key, value string, leaseID int64) error {
+	if key == "" {
+		return fmt.Errorf("key cannot be empty")
+	}
+
+	u := fmt.Sprintf("%s/v1/%s?lease=%d", c.baseURL, escapeKey(key), leaseID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewBufferString(value))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// TryLock makes a single attempt to acquire a lock for an existing lease.
+// It returns ErrLockHeld if another lease holds the lock.
+func (c *Client) TryLock(ctx context.Context, name string, leaseID int64) (*kvd.LockInfo, error) {
+	if name == "" {
+		return nil, fmt.Errorf("lock name cannot be empty")
+	}
+
+	var info kvd.LockInfo
+	path := "/v1/_locks/" + url.PathEscape(name)
+	err := c.doJSON(ctx, http.MethodPost, path, map[string]int64{"LeaseID": leaseID}, http.StatusOK, &info)
+	var se *ServerError
+	if errors.As(err, &se) && se.StatusCode == http.StatusConflict {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Lock blocks until the named lock is acquired or ctx is done. The lock is
+// held by a fresh lease with the given TTL that is kept alive until Unlock.
+func (c *Client) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	granted := time.Now()
+	lease, err := c.GrantLease(ctx, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		info, err := c.TryLock(ctx, name, lease.ID)
+		if err == nil {
+			l := &Lock{
+				Name:    info.Name,
+				Token:   info.Token,
+				LeaseID: info.LeaseID,
+				stop:    make(chan struct{}),
+				lost:    make(chan struct{}),
+			}
+			l.wg.Add(1)
+			go c.keepLockAlive(l, time.Duration(lease.TTL)*time.Second, granted)
+			return l, nil
+		}
+
+		if !errors.Is(err, ErrLockHeld) {
+			c.RevokeLease(context.Background(), lease.ID)
+			return nil, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			c.RevokeLease(context.Background(), lease.ID)
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// Unlock releases a lock and revokes its lease
+func (c *Client) Unlock(ctx context.Context, l *Lock) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	l.wg.Wait()
+
+	path := fmt.Sprintf("/v1/_locks/%s?token=%d", url.PathEscape(l.Name), l.Token)
+	if err := c.doJSON(ctx, http.MethodDelete, path, nil, http.StatusOK, nil); err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", l.Name, err)
+	}
+
+	return c.RevokeLease(ctx, l.LeaseID)
+}
+
+// keepLockAlive renews the lock's lease at a third of its TTL until the
+// lock is released. A failed renewal, such as a network error or a server
+// restarting, is retried with backoff; l.lost is closed only when the
+// server reports the lease not found or the lease has expired since it was
+// last renewed at renewed.
+func (c *Client) keepLockAlive(l *Lock, ttl time.Duration, renewed time.Time) {
+	defer l.wg.Done()
+
+	interval := ttl / 3
+	backoff := lockRetryInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+		}
+
+		// The server starts the new TTL after the request is sent, so
+		// timing it from here errs on the side of an earlier expiry
+		sent := time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), renewed.Add(ttl))
+		_, err := c.KeepAlive(ctx, l.LeaseID)
+		cancel()
+
+		var se *ServerError
+		switch {
+		case err == nil:
+			renewed = sent
+			backoff = lockRetryInterval
+			timer.Reset(interval)
+			continue
+		case errors.As(err, &se) && se.StatusCode == http.StatusNotFound:
+			close(l.lost)
+			return
+		}
+
+		left := time.Until(renewed.Add(ttl))
+		if left <= 0 {
+			close(l.lost)
+			return
+		}
+		timer.Reset(min(backoff, left))
+		backoff = min(2*backoff, interval)
+	}
+}
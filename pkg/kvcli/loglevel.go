@@ -0,0 +1,26 @@
+package kvcli
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// LogLevel returns the server's current log level
+func (c *Client) LogLevel(ctx context.Context) (string, error) {
+	var level kvd.LogLevel
+	if err := c.doJSON(ctx, http.MethodGet, "/admin/loglevel", nil, http.StatusOK, &level); err != nil {
+		return "", err
+	}
+	return level.Level, nil
+}
+
+// SetLogLevel changes the server's log level and returns the new level
+func (c *Client) SetLogLevel(ctx context.Context, level string) (string, error) {
+	var result kvd.LogLevel
+	if err := c.doJSON(ctx, http.MethodPut, "/admin/loglevel", kvd.LogLevel{Level: level}, http.StatusOK, &result); err != nil {
+		return "", err
+	}
+	return result.Level, nil
+}
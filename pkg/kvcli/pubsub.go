@@ -0,0 +1,112 @@
+package kvcli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// maxEventSize bounds a single server-sent event read by Subscribe
+const maxEventSize = 2 * 1048576
+
+// Publish sends a payload to a channel and returns how many subscribers
+// received it
+func (c *Client) Publish(channel, payload string) (int, error) {
+	if channel == "" {
+		return 0, fmt.Errorf("channel cannot be empty")
+	}
+
+	u := fmt.Sprintf("%s/v1/_channels/%s", c.baseURL, url.PathEscape(channel))
+	resp, err := c.httpClient.Post(u, "text/plain", strings.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to publish: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	var result struct {
+		Receivers int `json:"Receivers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Receivers, nil
+}
+
+// Subscribe streams messages for channels matching pattern, such as
+// "orders.*". The returned channel is closed when ctx is done or the
+// server ends the stream.
+func (c *Client) Subscribe(ctx context.Context, pattern string) (<-chan kvd.Message, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern cannot be empty")
+	}
+
+	u := fmt.Sprintf("%s/v1/_channels/%s", c.baseURL, url.PathEscape(pattern))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The regular client timeout would cut the stream off
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	messages := make(chan kvd.Message)
+	go func() {
+		defer close(messages)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+
+		var data strings.Builder
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data:") {
+				data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+				continue
+			}
+			if line != "" || data.Len() == 0 {
+				continue
+			}
+
+			// A blank line ends the event
+			var msg kvd.Message
+			err := json.Unmarshal([]byte(data.String()), &msg)
+			data.Reset()
+			if err != nil {
+				continue
+			}
+
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages, nil
+}
@@ -0,0 +1,89 @@
+package kvcli
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRateLimitRetries is how often a rate limited request is retried
+// unless WithRateLimitRetries says otherwise
+const DefaultRateLimitRetries = 3
+
+const (
+	// rateLimitBackoff is the first wait when the server sends no
+	// Retry-After; it doubles with each retry
+	rateLimitBackoff = 100 * time.Millisecond
+	// maxRateLimitWait caps the wait before a retry; a longer Retry-After
+	// is returned to the caller as the 429 response
+	maxRateLimitWait = 5 * time.Second
+)
+
+// WithRateLimitRetries sets how often a request rejected with 429 Too Many
+// Requests is retried after the server's Retry-After; 0 turns retries off
+func WithRateLimitRetries(retries int) ClientOption {
+	return func(c *Client) {
+		c.rateLimitRetries = retries
+	}
+}
+
+// retryTransport resends requests rejected with 429, waiting as long as
+// the server asks
+type retryTransport struct {
+	retries int
+	base    http.RoundTripper
+}
+
+// RoundTrip sends the request, retrying while it is rate limited
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := rateLimitBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == t.retries {
+			return resp, err
+		}
+		// A body that cannot be read again cannot be resent
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		wait := retryAfter(resp, backoff)
+		if wait > maxRateLimitWait {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+
+		// A RoundTripper must not modify the caller's request
+		next := req.Clone(req.Context())
+		if req.GetBody != nil {
+			if next.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		req = next
+	}
+}
+
+// retryAfter reads the wait from a Retry-After header in seconds or as a
+// date, or returns fallback when there is none
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return fallback
+}
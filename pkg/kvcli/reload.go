@@ -0,0 +1,16 @@
+package kvcli
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// Reload asks the server to read its configuration again and reports
+// which changes were applied and which need a restart
+func (c *Client) Reload(ctx context.Context) (kvd.ReloadResult, error) {
+	var result kvd.ReloadResult
+	err := c.doJSON(ctx, http.MethodPost, "/admin/reload", nil, http.StatusOK, &result)
+	return result, err
+}
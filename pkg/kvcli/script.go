@@ -0,0 +1,83 @@
+package kvcli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// LoadScript registers a server-side script and returns its hash
+func (c *Client) LoadScript(src string) (string, error) {
+	url := fmt.Sprintf("%s/v1/_scripts", c.baseURL)
+	resp, err := c.httpClient.Post(url, "text/plain", strings.NewReader(src))
+	if err != nil {
+		return "", fmt.Errorf("failed to load script: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	var result struct {
+		Hash string `json:"Hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return result.Hash, nil
+}
+
+// RunScript invokes a registered script with keys and arguments. The
+// result is nil, a bool, an int64 or a string.
+func (c *Client) RunScript(hash string, keys []string, args []string) (interface{}, error) {
+	if hash == "" {
+		return nil, fmt.Errorf("script hash cannot be empty")
+	}
+
+	jsonData, err := json.Marshal(map[string][]string{"Keys": keys, "Args": args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/_scripts/%s", c.baseURL, hash)
+	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to run script: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	var result struct {
+		Result interface{} `json:"Result"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	// Scripts only produce integers, so numbers convert losslessly
+	if n, ok := result.Result.(json.Number); ok {
+		return n.Int64()
+	}
+	return result.Result, nil
+}
+
+// UnloadScript removes a registered script
+func (c *Client) UnloadScript(hash string) error {
+	if err := c.doJSON(context.Background(), http.MethodDelete, "/v1/_scripts/"+hash, nil, http.StatusOK, nil); err != nil {
+		return fmt.Errorf("failed to unload script: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package kvcli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// Backup streams a snapshot of the server's database to w and returns the
+// number of bytes written
+func (c *Client) Backup(ctx context.Context, w io.Writer) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/admin/backup", c.baseURL), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// The regular client timeout would cut a large backup off
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to back up: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, fmt.Errorf("failed to write backup: %w", err)
+	}
+	return n, nil
+}
+
+// Restore sends a snapshot read from r to the server, which verifies it and
+// applies it in the given mode
+func (c *Client) Restore(ctx context.Context, r io.Reader, mode kvd.RestoreMode) (*kvd.RestoreResult, error) {
+	u := fmt.Sprintf("%s/admin/restore?mode=%s", c.baseURL, url.QueryEscape(string(mode)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	var result kvd.RestoreResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,53 @@
+package kvcli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// clientTLS returns the client's TLS settings, creating them on first use
+func (c *Client) clientTLS() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return c.tlsConfig
+}
+
+// WithRootCAs verifies the server's certificate against pool instead of
+// the system roots
+func WithRootCAs(pool *x509.CertPool) ClientOption {
+	return func(c *Client) {
+		c.clientTLS().RootCAs = pool
+	}
+}
+
+// WithClientCertificate presents cert to servers that ask for a client
+// certificate
+func WithClientCertificate(cert tls.Certificate) ClientOption {
+	return func(c *Client) {
+		c.clientTLS().Certificates = []tls.Certificate{cert}
+	}
+}
+
+// WithInsecureSkipVerify accepts any server certificate. It is meant for
+// testing against self-signed servers only.
+func WithInsecureSkipVerify() ClientOption {
+	return func(c *Client) {
+		c.clientTLS().InsecureSkipVerify = true
+	}
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates for WithRootCAs
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
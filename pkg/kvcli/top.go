@@ -0,0 +1,20 @@
+package kvcli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// Top returns up to limit of the most read and written keys and, when
+// biggest is set, the keys with the largest values
+func (c *Client) Top(ctx context.Context, limit int, biggest bool) (*kvd.TopReport, error) {
+	var report kvd.TopReport
+	path := fmt.Sprintf("/admin/top?limit=%d&biggest=%t", limit, biggest)
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, http.StatusOK, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -0,0 +1,30 @@
+package kvcli
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// traceContext writes W3C traceparent, tracestate and baggage headers
+var traceContext = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// tracingTransport adds the trace context of each request's context to its
+// headers, so server spans join the caller's trace
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip injects the trace context and sends the request
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	carrier := propagation.HeaderCarrier{}
+	traceContext.Inject(req.Context(), carrier)
+	if len(carrier) > 0 {
+		// A RoundTripper must not modify the caller's request
+		req = req.Clone(req.Context())
+		for name, values := range carrier {
+			req.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,117 @@
+package kvcli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/drewnix/kvd/pkg/kvd"
+)
+
+// Import streams NDJSON records from r to the server, which stores them in
+// batches of batchSize (zero uses the server default). progress, if not
+// nil, is called with each progress report. Batches stored before an
+// error are kept.
+func (c *Client) Import(ctx context.Context, r io.Reader, batchSize int, progress func(kvd.ImportProgress)) (*kvd.ImportProgress, error) {
+	u := fmt.Sprintf("%s/v1/_import", c.baseURL)
+	if batchSize > 0 {
+		u = fmt.Sprintf("%s?batch=%d", u, batchSize)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	// The regular client timeout would cut a large import off
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	var last kvd.ImportProgress
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var p kvd.ImportProgress
+		if err := decoder.Decode(&p); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return &last, fmt.Errorf("failed to read import progress: %w", err)
+		}
+		last = p
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	if last.Error != "" {
+		return &last, fmt.Errorf("import stopped after %d records: %s", last.Imported, last.Error)
+	}
+	if !last.Done {
+		return &last, fmt.Errorf("import ended after %d records without completing", last.Imported)
+	}
+
+	return &last, nil
+}
+
+// Export writes the records whose keys match pattern to w as NDJSON in
+// key order and returns how many were written. An empty pattern exports
+// every key.
+func (c *Client) Export(ctx context.Context, w io.Writer, pattern string) (int64, error) {
+	u := fmt.Sprintf("%s/v1/_export", c.baseURL)
+	if pattern != "" {
+		u = fmt.Sprintf("%s?pattern=%s", u, url.QueryEscape(pattern))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to export: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("server returned error: %s (status: %d)", body, resp.StatusCode)
+	}
+
+	// Count records as whole lines pass through
+	var count int64
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadSlice('\n')
+		if len(line) > 0 {
+			if _, werr := w.Write(line); werr != nil {
+				return count, fmt.Errorf("failed to write export: %w", werr)
+			}
+			if bytes.HasSuffix(line, []byte("\n")) {
+				count++
+			}
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return count, fmt.Errorf("failed to read export: %w", err)
+		}
+	}
+}
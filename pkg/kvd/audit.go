@@ -0,0 +1,439 @@
+package kvd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Audited operations
+const (
+	AuditSet        = "set"
+	AuditDelete     = "delete"
+	AuditBulkSet    = "bulk_set"
+	AuditBulkDelete = "bulk_delete"
+	AuditIncr       = "incr"
+	// AuditRevoke deletes a key because a client revoked its lease
+	AuditRevoke = "revoke"
+	// AuditExpire deletes a key because its TTL or its lease ran out
+	AuditExpire = "expire"
+	// AuditRestore sets a key from a snapshot, or deletes it when a
+	// replacing restore removes it
+	AuditRestore = "restore"
+)
+
+// AuditSystemIdentity is the identity of changes kvd makes on its own,
+// such as deleting expired keys
+const AuditSystemIdentity = "kvd"
+
+// systemContext carries the caller of changes kvd makes on its own
+var systemContext = WithCaller(context.Background(), Caller{Identity: AuditSystemIdentity})
+
+// Audit log defaults
+const (
+	DefaultAuditQueueSize  = 10000
+	DefaultAuditMaxSize    = 100 << 20
+	DefaultAuditMaxBackups = 5
+
+	// maxAuditBatch bounds how many entries are handed to a sink at once
+	maxAuditBatch = 1000
+
+	// auditWebhookTimeout bounds each webhook delivery
+	auditWebhookTimeout = 10 * time.Second
+)
+
+// ClientIDHeader names the client in audit entries of HTTP requests made
+// without a verified client certificate. It is taken on trust.
+const ClientIDHeader = "X-Client-ID"
+
+// ErrAuditConfig is returned for an unusable audit configuration
+var ErrAuditConfig = errors.New("invalid audit configuration")
+
+// AuditEntry records one change to one key
+type AuditEntry struct {
+	Time time.Time `json:"Time"`
+	// Identity is the authenticated client, or the name the client gave
+	Identity  string `json:"Identity,omitempty"`
+	Remote    string `json:"Remote,omitempty"`
+	RequestID string `json:"RequestID,omitempty"`
+	Op        string `json:"Op"`
+	Key       string `json:"Key"`
+	// ValueHash is the SHA-256 of the value written, when value hashing
+	// is enabled
+	ValueHash string `json:"ValueHash,omitempty"`
+}
+
+// AuditSink receives batches of audit entries from a single goroutine
+type AuditSink interface {
+	WriteEntries(entries []AuditEntry) error
+	Close() error
+}
+
+// Caller identifies the client behind a request
+type Caller struct {
+	Identity  string
+	Remote    string
+	RequestID string
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context carrying the caller, which audited
+// operations record
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller stored in ctx
+func CallerFromContext(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
+
+// clientIdentity names the client of an HTTP request: the subject of a
+// verified client certificate, or else the client's own X-Client-ID
+func clientIdentity(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return r.Header.Get(ClientIDHeader)
+}
+
+// auditRecord is a queued entry. The value is hashed by the writer
+// goroutine rather than under the database lock.
+type auditRecord struct {
+	entry AuditEntry
+	value *string
+}
+
+// auditLog queues audit entries and writes them to a sink in the
+// background. The queue is bounded: when the sink falls behind, entries
+// are dropped and counted rather than slowing down writes.
+type auditLog struct {
+	sink       AuditSink
+	queue      chan auditRecord
+	hashValues bool
+	logger     *slog.Logger
+
+	stop chan struct{}
+	done chan struct{}
+
+	written int64
+	dropped int64
+	failed  int64
+}
+
+// AuditOptions configures the audit queue
+type AuditOptions struct {
+	// QueueSize bounds the entries waiting to be written; zero uses
+	// DefaultAuditQueueSize
+	QueueSize int
+	// HashValues adds the SHA-256 of written values to entries
+	HashValues bool
+}
+
+// newAuditLog starts writing queued entries to sink
+func newAuditLog(sink AuditSink, opts AuditOptions, logger *slog.Logger) *auditLog {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultAuditQueueSize
+	}
+
+	a := &auditLog{
+		sink:       sink,
+		queue:      make(chan auditRecord, opts.QueueSize),
+		hashValues: opts.HashValues,
+		logger:     logger,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// record queues an entry without blocking. value is nil for deletes.
+func (a *auditLog) record(ctx context.Context, op string, key string, value *string) {
+	caller := CallerFromContext(ctx)
+	rec := auditRecord{
+		entry: AuditEntry{
+			Time:      time.Now().UTC(),
+			Identity:  caller.Identity,
+			Remote:    caller.Remote,
+			RequestID: caller.RequestID,
+			Op:        op,
+			Key:       key,
+		},
+	}
+	if a.hashValues {
+		rec.value = value
+	}
+
+	select {
+	case a.queue <- rec:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+// run writes queued entries in batches until the log is closed, then
+// writes whatever is still queued
+func (a *auditLog) run() {
+	defer close(a.done)
+
+	batch := make([]AuditEntry, 0, maxAuditBatch)
+	reported := int64(0)
+	for {
+		select {
+		case rec := <-a.queue:
+			batch = append(batch, a.finish(rec))
+		case <-a.stop:
+			for {
+				select {
+				case rec := <-a.queue:
+					batch = append(batch, a.finish(rec))
+					if len(batch) == maxAuditBatch {
+						a.write(batch)
+						batch = batch[:0]
+					}
+				default:
+					a.write(batch)
+					return
+				}
+			}
+		}
+
+		// Collect whatever else is already queued into the same batch
+	fill:
+		for len(batch) < maxAuditBatch {
+			select {
+			case rec := <-a.queue:
+				batch = append(batch, a.finish(rec))
+			default:
+				break fill
+			}
+		}
+		a.write(batch)
+		batch = batch[:0]
+
+		if dropped := atomic.LoadInt64(&a.dropped); dropped > reported {
+			a.logger.Warn("Audit queue full, entries dropped", "dropped", dropped-reported)
+			reported = dropped
+		}
+	}
+}
+
+// finish fills in the value hash of a queued entry
+func (a *auditLog) finish(rec auditRecord) AuditEntry {
+	if rec.value != nil {
+		sum := sha256.Sum256([]byte(*rec.value))
+		rec.entry.ValueHash = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return rec.entry
+}
+
+// write hands a batch to the sink
+func (a *auditLog) write(batch []AuditEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := a.sink.WriteEntries(batch); err != nil {
+		atomic.AddInt64(&a.failed, int64(len(batch)))
+		a.logger.Error("Error writing audit entries", "entries", len(batch), "err", err)
+		return
+	}
+	atomic.AddInt64(&a.written, int64(len(batch)))
+}
+
+// Close writes the entries still queued, waiting until ctx is done at
+// most, and closes the sink. Entries recorded afterwards are dropped.
+func (a *auditLog) Close(ctx context.Context) error {
+	close(a.stop)
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return fmt.Errorf("audit log not flushed: %w", ctx.Err())
+	}
+	return a.sink.Close()
+}
+
+// EnableAudit records every set, delete and bulk operation to sink,
+// including script writes and keys deleted by expiry or lease revocation.
+// Each entry carries the caller from the operation's context.
+func (db *DB) EnableAudit(sink AuditSink, opts AuditOptions, logger *slog.Logger) {
+	db.audit = newAuditLog(sink, opts, logger)
+}
+
+// CloseAudit flushes and closes the audit log, if enabled
+func (db *DB) CloseAudit(ctx context.Context) error {
+	if db.audit == nil {
+		return nil
+	}
+	return db.audit.Close(ctx)
+}
+
+// auditLocked queues an audit entry for a change to key, if auditing is
+// enabled. value is nil for deletes. The caller must hold the write lock,
+// so entries are queued in the order changes are applied.
+func (db *DB) auditLocked(ctx context.Context, op string, key string, value *string) {
+	if db.audit != nil {
+		db.audit.record(ctx, op, key, value)
+	}
+}
+
+// fileAuditSink appends entries as JSON lines to a file, rotating it once
+// it would grow past maxSize. Rotated files are kept as path.1 (newest)
+// through path.N.
+type fileAuditSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewFileAuditSink opens path for appending audit entries. A non-positive
+// maxSize disables rotation.
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (AuditSink, error) {
+	s := &fileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the current file for appending
+func (s *fileAuditSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// WriteEntries appends entries to the file
+func (s *fileAuditSink) WriteEntries(entries []AuditEntry) error {
+	body, err := encodeAuditEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(body)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(body)
+	s.size += int64(n)
+	return err
+}
+
+// rotate shifts the backups up by one and starts a new file
+func (s *fileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+
+	if s.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+		for i := s.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("could not rotate audit log: %w", err)
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return fmt.Errorf("could not rotate audit log: %w", err)
+	}
+
+	return s.open()
+}
+
+// Close closes the file
+func (s *fileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// webhookAuditSink posts batches of entries as NDJSON to a URL
+type webhookAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookAuditSink posts audit entries to url. Any 2xx response counts
+// as delivered.
+func NewWebhookAuditSink(url string) AuditSink {
+	return &webhookAuditSink{url: url, client: &http.Client{Timeout: auditWebhookTimeout}}
+}
+
+// WriteEntries posts one batch of entries
+func (s *webhookAuditSink) WriteEntries(entries []AuditEntry) error {
+	body, err := encodeAuditEntries(entries)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not deliver audit entries: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close does nothing; the webhook holds no resources
+func (s *webhookAuditSink) Close() error {
+	return nil
+}
+
+// encodeAuditEntries encodes entries as JSON lines
+func encodeAuditEntries(entries []AuditEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return nil, fmt.Errorf("could not encode audit entry: %w", err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// newAuditSink creates the audit sink described by config, or nil when
+// auditing is off
+func newAuditSink(config *Config) (AuditSink, error) {
+	switch {
+	case config.AuditFile != "" && config.AuditWebhook != "":
+		return nil, fmt.Errorf("%w: use either an audit file or an audit webhook", ErrAuditConfig)
+	case config.AuditFile != "":
+		maxSize := config.AuditMaxSize
+		if maxSize == 0 {
+			maxSize = DefaultAuditMaxSize
+		}
+		return NewFileAuditSink(config.AuditFile, maxSize, config.AuditMaxBackups)
+	case config.AuditWebhook != "":
+		return NewWebhookAuditSink(config.AuditWebhook), nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,259 @@
+package kvd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryAuditSink collects entries for tests, optionally blocking writes
+// until release is closed
+type memoryAuditSink struct {
+	mutex   sync.Mutex
+	entries []AuditEntry
+	release chan struct{}
+}
+
+func (s *memoryAuditSink) WriteEntries(entries []AuditEntry) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *memoryAuditSink) Close() error { return nil }
+
+func TestAuditLog(t *testing.T) {
+	db := newTestDB(t)
+	sink := &memoryAuditSink{}
+	db.EnableAudit(sink, AuditOptions{HashValues: true}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx := WithCaller(context.Background(), Caller{Identity: "alice", Remote: "10.0.0.1:5000", RequestID: "r1"})
+	db.SetContext(ctx, "a", "1")
+	db.BulkSetContext(ctx, []Record{{Key: "b", Value: "2"}, {Key: "c", Value: "3"}})
+	db.IncrContext(ctx, "a", 1)
+	db.DeleteContext(ctx, "missing")
+	db.BulkDeletePartialContext(ctx, []string{"b", "missing"})
+	db.Get("a")
+
+	if err := db.CloseAudit(context.Background()); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+
+	want := []struct{ op, key string }{
+		{AuditSet, "a"}, {AuditBulkSet, "b"}, {AuditBulkSet, "c"}, {AuditIncr, "a"}, {AuditBulkDelete, "b"},
+	}
+	if len(sink.entries) != len(want) {
+		t.Fatalf("Expected %d entries, got %+v", len(want), sink.entries)
+	}
+	for i, w := range want {
+		entry := sink.entries[i]
+		if entry.Op != w.op || entry.Key != w.key || entry.Identity != "alice" || entry.Remote != "10.0.0.1:5000" || entry.RequestID != "r1" {
+			t.Errorf("Entry %d: expected %s of %s, got %+v", i, w.op, w.key, entry)
+		}
+	}
+	// sha256("1")
+	if sink.entries[0].ValueHash != "sha256:6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b" {
+		t.Errorf("Unexpected value hash %q", sink.entries[0].ValueHash)
+	}
+	if sink.entries[4].ValueHash != "" {
+		t.Errorf("Expected no value hash for a delete, got %q", sink.entries[4].ValueHash)
+	}
+
+	metrics := db.Metrics()
+	if metrics.AuditWritten != 5 || metrics.AuditDropped != 0 {
+		t.Errorf("Unexpected audit metrics %+v", metrics)
+	}
+}
+
+func TestAuditScriptsAndExpiry(t *testing.T) {
+	db := newTestDB(t)
+	sink := &memoryAuditSink{}
+	db.EnableAudit(sink, AuditOptions{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := WithCaller(context.Background(), Caller{Identity: "alice"})
+
+	hash, err := db.LoadScript(`set(KEYS[0], ARGS[0]); del(KEYS[1])`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set("gone", "1")
+	if _, err := db.RunScriptContext(ctx, hash, []string{"s", "gone"}, []string{"v"}, ScriptLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, _ := db.GrantLease(time.Minute)
+	db.SetWithLease("r", "1", revoked.ID)
+	db.RevokeLeaseContext(ctx, revoked.ID)
+
+	expiring, _ := db.GrantLease(time.Second)
+	db.SetWithLease("l", "1", expiring.ID)
+	db.Set("t", "1")
+	db.Expire("t", time.Millisecond)
+	db.Set("now", "1")
+	db.ExpireContext(ctx, "now", 0)
+	time.Sleep(5 * time.Millisecond)
+	db.expireKeys(time.Now())
+	db.expireLeases(time.Now().Add(time.Second))
+
+	if err := db.CloseAudit(context.Background()); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+	var got []string
+	for _, entry := range sink.entries {
+		if entry.Identity == "alice" || entry.Identity == AuditSystemIdentity {
+			got = append(got, entry.Identity+" "+entry.Op+" "+entry.Key)
+		}
+	}
+	want := []string{
+		"alice set s", "alice delete gone", "alice revoke r", "alice delete now",
+		"kvd expire t", "kvd expire l",
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestAuditRestore(t *testing.T) {
+	source := newTestDB(t)
+	source.Set("a", "1")
+	var buf bytes.Buffer
+	source.WriteSnapshot(&buf)
+
+	db := newTestDB(t)
+	sink := &memoryAuditSink{}
+	db.EnableAudit(sink, AuditOptions{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	db.Set("old", "1")
+	ctx := WithCaller(context.Background(), Caller{Identity: "alice"})
+	if _, err := db.RestoreSnapshotContext(ctx, &buf, RestoreReplace); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CloseAudit(context.Background()); err != nil {
+		t.Fatalf("Failed to close audit log: %v", err)
+	}
+	var got []string
+	for _, entry := range sink.entries {
+		if entry.Identity == "alice" {
+			got = append(got, entry.Op+" "+entry.Key)
+		}
+	}
+	want := []string{"restore old", "restore a"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestAuditQueueFull(t *testing.T) {
+	db := newTestDB(t)
+	sink := &memoryAuditSink{release: make(chan struct{})}
+	db.EnableAudit(sink, AuditOptions{QueueSize: 2}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// Writes must not wait for a stalled sink
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			db.Set("k", "v")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writes blocked on the audit sink")
+	}
+
+	close(sink.release)
+	db.CloseAudit(context.Background())
+
+	metrics := db.Metrics()
+	if metrics.AuditDropped == 0 || metrics.AuditWritten+metrics.AuditDropped != 100 {
+		t.Errorf("Expected dropped entries to be counted, got %+v", metrics)
+	}
+}
+
+func TestFileAuditSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileAuditSink(path, 200, 2)
+	if err != nil {
+		t.Fatalf("Failed to open audit file: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		entry := AuditEntry{Time: time.Unix(int64(i), 0).UTC(), Op: AuditSet, Key: strings.Repeat("k", 50)}
+		if err := sink.WriteEntries([]AuditEntry{entry}); err != nil {
+			t.Fatalf("Failed to write entry: %v", err)
+		}
+	}
+	sink.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil || info.Size() > 200 {
+			t.Errorf("Expected %s within the size limit, got %v %v", name, info, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups, got %v", err)
+	}
+
+	file, _ := os.Open(path)
+	defer file.Close()
+	var entry AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+	}
+	if entry.Time.Unix() != 9 {
+		t.Errorf("Expected the newest entry last, got %+v", entry)
+	}
+}
+
+func TestWebhookAuditSink(t *testing.T) {
+	var received []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r.Header.Get("Content-Type"), string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookAuditSink(server.URL)
+	entries := []AuditEntry{{Op: AuditSet, Key: "a"}, {Op: AuditDelete, Key: "b"}}
+	if err := sink.WriteEntries(entries); err != nil {
+		t.Fatalf("Failed to post entries: %v", err)
+	}
+	if received[0] != "application/x-ndjson" || strings.Count(received[1], "\n") != 2 {
+		t.Errorf("Unexpected delivery %q", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := sink.WriteEntries(entries); err == nil {
+		t.Error("Expected an error for a failed delivery")
+	}
+}
+
+func TestAuditConfig(t *testing.T) {
+	if _, err := newAuditSink(&Config{AuditFile: "a", AuditWebhook: "http://b"}); err == nil {
+		t.Error("Expected an error for both a file and a webhook")
+	}
+	if sink, err := newAuditSink(&Config{}); sink != nil || err != nil {
+		t.Errorf("Expected auditing off by default, got %v %v", sink, err)
+	}
+}
@@ -0,0 +1,493 @@
+package kvd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Roles a token can have. Each role includes the permissions of the ones
+// before it.
+const (
+	RoleRead      = "read"
+	RoleReadWrite = "readwrite"
+	RoleAdmin     = "admin"
+)
+
+// APIKeyHeader carries a token for clients that cannot send an
+// Authorization: Bearer header
+const APIKeyHeader = "X-API-Key"
+
+// tokenPrefix marks generated tokens, which makes leaked ones easy to
+// search for
+const tokenPrefix = "kvd_"
+
+// Authentication and authorization errors
+var (
+	ErrUnauthorized  = errors.New("missing or unknown token")
+	ErrForbidden     = errors.New("permission denied")
+	ErrTokenName     = errors.New("token name is required")
+	ErrTokenExists   = errors.New("token name already in use")
+	ErrTokenNotFound = errors.New("token not found")
+	ErrInvalidRole   = errors.New("role must be read, readwrite or admin")
+	ErrLastAdmin     = errors.New("cannot remove the last admin token")
+)
+
+// permission is the level of access an operation needs
+type permission int
+
+const (
+	permRead permission = iota + 1
+	permWrite
+	permAdmin
+)
+
+// rolePermissions maps each role to the highest permission it grants
+var rolePermissions = map[string]permission{
+	RoleRead:      permRead,
+	RoleReadWrite: permWrite,
+	RoleAdmin:     permAdmin,
+}
+
+// TokenInfo describes a token without its secret
+type TokenInfo struct {
+	Name string `json:"Name"`
+	Role string `json:"Role"`
+	// Prefixes limits the token to keys starting with one of them; empty
+	// means every key. Admin tokens are never limited.
+	Prefixes []string  `json:"Prefixes,omitempty"`
+	Created  time.Time `json:"Created"`
+}
+
+// NewToken is the reply to creating a token. The secret is only shown
+// once.
+type NewToken struct {
+	TokenInfo
+	Token string `json:"Token"`
+}
+
+// tokenRecord is a token as kept in the auth file. Hand-written files may
+// give the secret as Token; the server only ever writes its Hash.
+type tokenRecord struct {
+	TokenInfo
+	Hash  string `json:"Hash,omitempty"`
+	Token string `json:"Token,omitempty"`
+}
+
+// authFile is the layout of the auth file
+type authFile struct {
+	Tokens []tokenRecord `json:"Tokens"`
+}
+
+// Principal is the authenticated client of a request
+type Principal struct {
+	TokenInfo
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the authenticated client of a request, or
+// nil when authentication is off
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// scoped reports whether the principal is limited to key prefixes
+func (p *Principal) scoped() bool {
+	return p.Role != RoleAdmin && len(p.Prefixes) > 0
+}
+
+// allows reports whether the principal may perform an operation needing
+// perm on key
+func (p *Principal) allows(perm permission, key string) bool {
+	if rolePermissions[p.Role] < perm {
+		return false
+	}
+	if !p.scoped() {
+		return true
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenStore holds the tokens and saves changes to the auth file
+type tokenStore struct {
+	path string
+
+	mutex  sync.RWMutex
+	byHash map[string]*tokenRecord
+	byName map[string]*tokenRecord
+}
+
+// hashToken returns the stored form of a token secret
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// generateToken returns a new random token secret
+func generateToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return tokenPrefix + hex.EncodeToString(b)
+}
+
+// loadTokenStore reads the auth file at path. A missing file is treated as
+// having no tokens.
+func loadTokenStore(path string) (*tokenStore, error) {
+	s := &tokenStore{
+		path:   path,
+		byHash: make(map[string]*tokenRecord),
+		byName: make(map[string]*tokenRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read auth file: %w", err)
+	}
+
+	var file authFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse auth file %s: %w", path, err)
+	}
+	for i := range file.Tokens {
+		record := file.Tokens[i]
+		if record.Token != "" {
+			record.Hash = hashToken(record.Token)
+			record.Token = ""
+		}
+		switch {
+		case record.Name == "" || record.Hash == "":
+			return nil, fmt.Errorf("auth file %s: token %d needs a name and a token or hash", path, i+1)
+		case rolePermissions[record.Role] == 0:
+			return nil, fmt.Errorf("auth file %s: token %q: %w", path, record.Name, ErrInvalidRole)
+		case s.byName[record.Name] != nil:
+			return nil, fmt.Errorf("auth file %s: %w: %q", path, ErrTokenExists, record.Name)
+		}
+		s.byHash[record.Hash] = &record
+		s.byName[record.Name] = &record
+	}
+
+	return s, nil
+}
+
+// replace swaps in the tokens of other, as read again from the auth file
+func (s *tokenStore) replace(other *tokenStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.byHash = other.byHash
+	s.byName = other.byName
+}
+
+// saveLocked writes the tokens to the auth file, replacing it atomically.
+// The caller must hold the write lock.
+func (s *tokenStore) saveLocked() error {
+	file := authFile{Tokens: make([]tokenRecord, 0, len(s.byName))}
+	for _, record := range s.byName {
+		file.Tokens = append(file.Tokens, *record)
+	}
+	sort.Slice(file.Tokens, func(i, j int) bool { return file.Tokens[i].Name < file.Tokens[j].Name })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode auth file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("could not save auth file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not save auth file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not save auth file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("could not save auth file: %w", err)
+	}
+	return nil
+}
+
+// authenticate returns the principal for a token secret
+func (s *tokenStore) authenticate(token string) (*Principal, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, ok := s.byHash[hashToken(token)]
+	if !ok {
+		return nil, false
+	}
+	return &Principal{TokenInfo: record.TokenInfo}, true
+}
+
+// list returns the tokens sorted by name
+func (s *tokenStore) list() []TokenInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tokens := make([]TokenInfo, 0, len(s.byName))
+	for _, record := range s.byName {
+		tokens = append(tokens, record.TokenInfo)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens
+}
+
+// create adds a token and returns its secret
+func (s *tokenStore) create(info TokenInfo) (NewToken, error) {
+	if info.Name == "" {
+		return NewToken{}, ErrTokenName
+	}
+	if rolePermissions[info.Role] == 0 {
+		return NewToken{}, ErrInvalidRole
+	}
+	info.Created = time.Now().UTC()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.byName[info.Name] != nil {
+		return NewToken{}, ErrTokenExists
+	}
+
+	token := generateToken()
+	record := &tokenRecord{TokenInfo: info, Hash: hashToken(token)}
+	s.byName[info.Name] = record
+	s.byHash[record.Hash] = record
+	if err := s.saveLocked(); err != nil {
+		delete(s.byName, info.Name)
+		delete(s.byHash, record.Hash)
+		return NewToken{}, err
+	}
+
+	return NewToken{TokenInfo: info, Token: token}, nil
+}
+
+// remove deletes a token. The last admin token cannot be removed, so the
+// tokens can always be managed.
+func (s *tokenStore) remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.byName[name]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	if record.Role == RoleAdmin && s.adminsLocked() == 1 {
+		return ErrLastAdmin
+	}
+
+	delete(s.byName, name)
+	delete(s.byHash, record.Hash)
+	if err := s.saveLocked(); err != nil {
+		s.byName[name] = record
+		s.byHash[record.Hash] = record
+		return err
+	}
+	return nil
+}
+
+// adminsLocked counts the admin tokens. The caller must hold the lock.
+func (s *tokenStore) adminsLocked() int {
+	admins := 0
+	for _, record := range s.byName {
+		if record.Role == RoleAdmin {
+			admins++
+		}
+	}
+	return admins
+}
+
+// bootstrap creates an admin token if there is none, returning its secret
+func (s *tokenStore) bootstrap() (string, error) {
+	s.mutex.RLock()
+	admins := s.adminsLocked()
+	s.mutex.RUnlock()
+	if admins > 0 {
+		return "", nil
+	}
+
+	token, err := s.create(TokenInfo{Name: "admin", Role: RoleAdmin})
+	if errors.Is(err, ErrTokenExists) {
+		token, err = s.create(TokenInfo{Name: "admin-" + time.Now().UTC().Format("20060102150405"), Role: RoleAdmin})
+	}
+	return token.Token, err
+}
+
+// routeRule is the access an HTTP route needs
+type routeRule struct {
+	perm permission
+	// keyVar names the route variable checked against the token's
+	// prefixes
+	keyVar string
+	// unscoped routes reach keys the request does not name, so tokens
+	// limited to prefixes may not use them
+	unscoped bool
+}
+
+// routeRules gives the access each route needs, by method and route
+// template. Bulk, import and export handlers check the keys in their
+// bodies themselves, and tokens limited to key prefixes may only use the
+// leases they granted. Routes missing here need an admin token.
+var routeRules = map[string]routeRule{
+	"GET /v1/{key}":    {perm: permRead, keyVar: "key"},
+	"PUT /v1/{key}":    {perm: permWrite, keyVar: "key"},
+	"DELETE /v1/{key}": {perm: permWrite, keyVar: "key"},
+
+	"GET /v1/":                       {perm: permRead},
+	"PUT /v1/":                       {perm: permWrite},
+	"DELETE /v1/":                    {perm: permWrite},
+	"POST /v1/_mget":                 {perm: permRead},
+	"POST /v1/_mset":                 {perm: permWrite},
+	"POST /v1/_mdelete":              {perm: permWrite},
+	"POST /v1/_import":               {perm: permWrite},
+	"GET /v1/_export":                {perm: permRead},
+	"GET /v1/_indexes":               {perm: permRead},
+	"POST /v1/_leases":               {perm: permWrite},
+	"GET /v1/_leases/{id}":           {perm: permRead},
+	"DELETE /v1/_leases/{id}":        {perm: permWrite},
+	"PUT /v1/_leases/{id}/keepalive": {perm: permWrite},
+	"POST /v1/_locks/{name}":         {perm: permWrite, keyVar: "name"},
+	"DELETE /v1/_locks/{name}":       {perm: permWrite, keyVar: "name"},
+	"POST /v1/_channels/{channel}":   {perm: permWrite, keyVar: "channel"},
+	"GET /v1/_channels/{pattern}":    {perm: permRead, keyVar: "pattern"},
+	"POST /v1/_scripts/{hash}":       {perm: permWrite, unscoped: true},
+	"GET /v1/_indexes/{name}/query":  {perm: permRead, unscoped: true},
+	"GET /status":                    {perm: permRead},
+	"GET /metrics":                   {perm: permRead},
+}
+
+// bearerToken returns the token sent with a request
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return r.Header.Get(APIKeyHeader)
+}
+
+// authorize is router middleware that authenticates each request by its
+// token and checks the token's role and key prefixes against the route.
+// The token's name becomes the caller's identity.
+func (kvd *Kvd) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, ok := kvd.tokens.authenticate(bearerToken(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kvd"`)
+			http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		rule, ok := routeRules[r.Method+" "+routeTemplate(r)]
+		if !ok {
+			rule = routeRule{perm: permAdmin}
+		}
+		allowed := rolePermissions[p.Role] >= rule.perm && !(rule.unscoped && p.scoped())
+		if allowed && rule.keyVar != "" {
+			allowed = p.allows(rule.perm, mux.Vars(r)[rule.keyVar])
+		}
+		if !allowed {
+			kvd.log(r).Warn("Request denied", "token", p.Name, "route", routeTemplate(r))
+			http.Error(w, ErrForbidden.Error(), http.StatusForbidden)
+			return
+		}
+
+		caller := CallerFromContext(r.Context())
+		caller.Identity = p.Name
+		ctx := context.WithValue(WithCaller(r.Context(), caller), principalKey{}, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authorizeKeys replies with 403 and returns false unless the request's
+// principal may access every key with perm
+func (kvd *Kvd) authorizeKeys(w http.ResponseWriter, r *http.Request, perm permission, keys ...string) bool {
+	p := PrincipalFromContext(r.Context())
+	if p == nil {
+		return true
+	}
+	for _, key := range keys {
+		if !p.allows(perm, key) {
+			http.Error(w, fmt.Sprintf("%v for key %q", ErrForbidden, key), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// authErrorStatus maps token management errors to HTTP status codes
+func authErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTokenNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTokenExists), errors.Is(err, ErrLastAdmin):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidRole), errors.Is(err, ErrTokenName):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// tokenListHandler lists the tokens without their secrets
+func (kvd *Kvd) tokenListHandler(w http.ResponseWriter, r *http.Request) {
+	kvd.writeJSON(w, http.StatusOK, kvd.tokens.list())
+}
+
+// tokenCreateHandler creates a token from a TokenInfo body and replies
+// with its secret
+func (kvd *Kvd) tokenCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var info TokenInfo
+	if !kvd.decodeJSONBody(w, r, &info) {
+		return
+	}
+
+	token, err := kvd.tokens.create(info)
+	if err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+
+	kvd.log(r).Info("Token created", "name", token.Name, "role", token.Role)
+	kvd.writeJSON(w, http.StatusCreated, token)
+}
+
+// tokenDeleteHandler revokes a token
+func (kvd *Kvd) tokenDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := kvd.tokens.remove(name); err != nil {
+		http.Error(w, err.Error(), authErrorStatus(err))
+		return
+	}
+
+	kvd.log(r).Info("Token deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,201 @@
+package kvd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	os.WriteFile(path, []byte(`{"Tokens":[{"Name":"ci","Role":"readwrite","Prefixes":["ci/"],"Token":"secret"}]}`), 0600)
+
+	store, err := loadTokenStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	p, ok := store.authenticate("secret")
+	if !ok || p.Name != "ci" || !p.allows(permWrite, "ci/a") || p.allows(permRead, "prod/a") {
+		t.Fatalf("Unexpected principal %+v", p)
+	}
+
+	admin, err := store.bootstrap()
+	if err != nil || !strings.HasPrefix(admin, tokenPrefix) {
+		t.Fatalf("Expected an admin token to be created, got %q %v", admin, err)
+	}
+	if again, _ := store.bootstrap(); again != "" {
+		t.Error("Expected no second admin token")
+	}
+	if err := store.remove("admin"); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("Expected ErrLastAdmin, got %v", err)
+	}
+	if _, err := store.create(TokenInfo{Name: "ci", Role: RoleRead}); !errors.Is(err, ErrTokenExists) {
+		t.Errorf("Expected ErrTokenExists, got %v", err)
+	}
+	if _, err := store.create(TokenInfo{Name: "x", Role: "owner"}); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("Expected ErrInvalidRole, got %v", err)
+	}
+
+	// Changes are saved with hashes only and survive a reload
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), admin) {
+		t.Errorf("Expected no secrets in the saved file, got %s", data)
+	}
+	reloaded, err := loadTokenStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reload tokens: %v", err)
+	}
+	if _, ok := reloaded.authenticate(admin); !ok {
+		t.Error("Expected the admin token to survive a reload")
+	}
+	if _, ok := reloaded.authenticate("secret"); !ok {
+		t.Error("Expected the hand-written token to survive a reload")
+	}
+	if len(reloaded.list()) != 2 {
+		t.Errorf("Expected 2 tokens, got %+v", reloaded.list())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	kvd := newTestKvd(t)
+	store, err := loadTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	kvd.tokens = store
+	admin, _ := store.bootstrap()
+	reader, _ := store.create(TokenInfo{Name: "reader", Role: RoleRead})
+	writer, _ := store.create(TokenInfo{Name: "app", Role: RoleReadWrite, Prefixes: []string{"app."}})
+	kvd.db.Set("app.a", "1")
+	kvd.db.Set("other", "2")
+
+	router := mux.NewRouter()
+	router.Use(kvd.logRequests, kvd.authorize)
+	router.HandleFunc("/v1/_mset", kvd.msetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_export", kvd.exportHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/_indexes/{name}/query", kvd.indexQueryHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/{key}", kvd.keyPutHandler).Methods(http.MethodPut)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+	router.HandleFunc("/admin/tokens", kvd.tokenListHandler).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", kvd.healthzHandler).Methods(http.MethodGet)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{http.MethodGet, "/v1/other", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/other", "wrong", "", http.StatusUnauthorized},
+		{http.MethodGet, "/v1/other", reader.Token, "", http.StatusOK},
+		{http.MethodPut, "/v1/other", reader.Token, "x", http.StatusForbidden},
+		{http.MethodGet, "/admin/tokens", reader.Token, "", http.StatusForbidden},
+		{http.MethodPut, "/v1/app.b", writer.Token, "x", http.StatusCreated},
+		{http.MethodPut, "/v1/other", writer.Token, "x", http.StatusForbidden},
+		{http.MethodGet, "/v1/other", writer.Token, "", http.StatusForbidden},
+		{http.MethodPost, "/v1/_mset", writer.Token, `[{"Key":"app.b","Value":"1"},{"Key":"other","Value":"1"}]`, http.StatusForbidden},
+		{http.MethodPost, "/v1/_mset", writer.Token, `[{"Key":"app.b","Value":"1"}]`, http.StatusCreated},
+		{http.MethodGet, "/v1/_indexes/i/query", writer.Token, "", http.StatusForbidden},
+		{http.MethodGet, "/admin/tokens", admin, "", http.StatusOK},
+		{http.MethodGet, "/healthz", "", "", http.StatusOK},
+	} {
+		if rec := do(tc.method, tc.path, tc.token, tc.body); rec.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, rec.Code, rec.Body)
+		}
+	}
+
+	// Exports leave out keys the token may not read
+	rec := do(http.MethodGet, "/v1/_export", writer.Token, "")
+	if strings.Contains(rec.Body.String(), "other") || !strings.Contains(rec.Body.String(), "app.a") {
+		t.Errorf("Expected only app. keys, got %q", rec.Body)
+	}
+
+	// The API key header works as well as a bearer token
+	req := httptest.NewRequest(http.MethodGet, "/admin/tokens", nil)
+	req.Header.Set(APIKeyHeader, admin)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var tokens []TokenInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil || len(tokens) != 3 {
+		t.Errorf("Expected 3 tokens, got %q", rec.Body)
+	}
+}
+
+func TestLeaseOwnership(t *testing.T) {
+	kvd := newTestKvd(t)
+	store, err := loadTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	kvd.tokens = store
+	admin, _ := store.bootstrap()
+	tenantA, _ := store.create(TokenInfo{Name: "a", Role: RoleReadWrite, Prefixes: []string{"a."}})
+	tenantB, _ := store.create(TokenInfo{Name: "b", Role: RoleReadWrite, Prefixes: []string{"b."}})
+
+	router := mux.NewRouter()
+	router.Use(kvd.authorize)
+	router.HandleFunc("/v1/_leases", kvd.leaseGrantHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_leases/{id}", kvd.leaseGetHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/_leases/{id}", kvd.leaseRevokeHandler).Methods(http.MethodDelete)
+	router.HandleFunc("/v1/_leases/{id}/keepalive", kvd.leaseKeepAliveHandler).Methods(http.MethodPut)
+	router.HandleFunc("/v1/_locks/{name}", kvd.lockAcquireHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/{key}", kvd.keyPutHandler).Methods(http.MethodPut)
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/v1/_leases", tenantB.Token, `{"TTL":60}`)
+	var info LeaseInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to grant lease: %d %s", rec.Code, rec.Body)
+	}
+	lease := fmt.Sprintf("/v1/_leases/%d", info.ID)
+	if rec := do(http.MethodPut, "/v1/b.a?lease="+fmt.Sprint(info.ID), tenantB.Token, "1"); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the owner to attach a key, got %d %s", rec.Code, rec.Body)
+	}
+
+	// Another tenant cannot see, renew, revoke or attach to the lease
+	for _, tc := range []struct{ method, path, body string }{
+		{http.MethodGet, lease, ""},
+		{http.MethodPut, lease + "/keepalive", ""},
+		{http.MethodDelete, lease, ""},
+		{http.MethodPut, "/v1/a.a?lease=" + fmt.Sprint(info.ID), "1"},
+		{http.MethodPost, "/v1/_locks/a.lock", fmt.Sprintf(`{"LeaseID":%d}`, info.ID)},
+	} {
+		if rec := do(tc.method, tc.path, tenantA.Token, tc.body); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected 404, got %d %s", tc.method, tc.path, rec.Code, rec.Body)
+		}
+	}
+	if _, err := kvd.db.Get("b.a"); err != nil {
+		t.Errorf("Expected the lease's key to survive, got %v", err)
+	}
+
+	// The owner and admins can use it
+	if rec := do(http.MethodGet, lease, tenantB.Token, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected the owner to read the lease, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, lease, admin, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected an admin to revoke the lease, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package kvd
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// BulkGetResult is the reply to a partial bulk get
+type BulkGetResult struct {
+	Records []Record `json:"Records"`
+	Missing []string `json:"Missing"`
+}
+
+// BulkDeleteResult is the reply to a partial bulk delete
+type BulkDeleteResult struct {
+	Deleted []string `json:"Deleted"`
+	Missing []string `json:"Missing"`
+}
+
+// decodeJSONBody reads a JSON request body into v, replying with 400 and
+// returning false if it is missing or malformed
+func (kvd *Kvd) decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		http.Error(w, "Request body is required", http.StatusBadRequest)
+		return false
+	}
+
+	body, ok := kvd.readBody(w, r, kvd.settings().limits.maxBodySize, ErrBodyTooLarge)
+	if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		kvd.log(r).Error("Error unmarshaling request", "err", err)
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// bulkErrorStatus maps a bulk operation error to an HTTP status code
+func bulkErrorStatus(err error) int {
+	return keyErrorStatus(err)
+}
+
+// writeBulkGet replies with the records for keys. With partial=true the
+// reply is a BulkGetResult listing missing keys instead of a 404.
+func (kvd *Kvd) writeBulkGet(w http.ResponseWriter, r *http.Request, keys []string) {
+	if err := kvd.settings().limits.checkCount(len(keys)); err != nil {
+		http.Error(w, err.Error(), limitErrorStatus(err))
+		return
+	}
+	if !kvd.authorizeKeys(w, r, permRead, keys...) || !kvd.rateLimitKeys(w, r, keys...) {
+		return
+	}
+	if r.URL.Query().Get("partial") == "true" {
+		records, missing, err := kvd.db.BulkGetPartialContext(r.Context(), keys)
+		if err != nil {
+			http.Error(w, err.Error(), bulkErrorStatus(err))
+			return
+		}
+		kvd.writeJSON(w, http.StatusOK, BulkGetResult{Records: records, Missing: missing})
+		return
+	}
+
+	if len(keys) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+		return
+	}
+
+	records, err := kvd.db.BulkGetContext(r.Context(), keys)
+	if err != nil {
+		kvd.log(r).Error("Error in bulk get operation", "err", err)
+		http.Error(w, err.Error(), bulkErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, records)
+}
+
+// writeBulkSet stores records and replies with 201
+func (kvd *Kvd) writeBulkSet(w http.ResponseWriter, r *http.Request, records []Record) {
+	if len(records) == 0 {
+		http.Error(w, "No records provided", http.StatusBadRequest)
+		return
+	}
+	if err := kvd.settings().limits.checkCount(len(records)); err != nil {
+		http.Error(w, err.Error(), limitErrorStatus(err))
+		return
+	}
+	keys := make([]string, len(records))
+	for i, record := range records {
+		if err := kvd.settings().limits.checkRecord(record); err != nil {
+			http.Error(w, err.Error(), limitErrorStatus(err))
+			return
+		}
+		if !kvd.authorizeKeys(w, r, permWrite, record.Key) {
+			return
+		}
+		keys[i] = record.Key
+	}
+	if !kvd.rateLimitKeys(w, r, keys...) {
+		return
+	}
+
+	if err := kvd.db.BulkSetContext(r.Context(), records); err != nil {
+		kvd.log(r).Error("Error in bulk set operation", "err", err)
+		http.Error(w, err.Error(), bulkErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// writeBulkDelete removes keys and replies with 200. With partial=true the
+// keys that exist are removed and the reply is a BulkDeleteResult.
+func (kvd *Kvd) writeBulkDelete(w http.ResponseWriter, r *http.Request, keys []string) {
+	if err := kvd.settings().limits.checkCount(len(keys)); err != nil {
+		http.Error(w, err.Error(), limitErrorStatus(err))
+		return
+	}
+	if !kvd.authorizeKeys(w, r, permWrite, keys...) || !kvd.rateLimitKeys(w, r, keys...) {
+		return
+	}
+	if r.URL.Query().Get("partial") == "true" {
+		deleted, missing, err := kvd.db.BulkDeletePartialContext(r.Context(), keys)
+		if err != nil {
+			http.Error(w, err.Error(), bulkErrorStatus(err))
+			return
+		}
+		kvd.writeJSON(w, http.StatusOK, BulkDeleteResult{Deleted: deleted, Missing: missing})
+		return
+	}
+
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := kvd.db.BulkDeleteContext(r.Context(), keys); err != nil {
+		kvd.log(r).Error("Error in bulk delete operation", "err", err)
+		http.Error(w, err.Error(), bulkErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// mgetHandler returns the records for a JSON array of keys
+func (kvd *Kvd) mgetHandler(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if !kvd.decodeJSONBody(w, r, &keys) {
+		return
+	}
+	kvd.writeBulkGet(w, r, keys)
+}
+
+// msetHandler stores a JSON array of records atomically
+func (kvd *Kvd) msetHandler(w http.ResponseWriter, r *http.Request) {
+	var records []Record
+	if !kvd.decodeJSONBody(w, r, &records) {
+		return
+	}
+	kvd.writeBulkSet(w, r, records)
+}
+
+// mdeleteHandler removes a JSON array of keys, or none if any is missing
+// and partial=true is not given
+func (kvd *Kvd) mdeleteHandler(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if !kvd.decodeJSONBody(w, r, &keys) {
+		return
+	}
+	kvd.writeBulkDelete(w, r, keys)
+}
@@ -0,0 +1,88 @@
+package kvd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkHandlers(t *testing.T) {
+	kvd := newTestKvd(t)
+
+	rec := httptest.NewRecorder()
+	kvd.msetHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_mset",
+		strings.NewReader(`[{"Key":"a","Value":"1"},{"Key":"b","Value":"2"}]`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 from _mset, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.mgetHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_mget", strings.NewReader(`["a","b"]`)))
+	var records []Record
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil || len(records) != 2 {
+		t.Errorf("Expected 2 records from _mget, got %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.keyManyGetHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/?key=b&key=a", nil))
+	records = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil || len(records) != 2 || records[0].Key != "b" {
+		t.Errorf("Expected records for b and a from ?key=, got %s", rec.Body)
+	}
+
+	// A GET body is only read when legacy routes are enabled
+	rec = httptest.NewRecorder()
+	kvd.keyManyGetHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/", strings.NewReader(`["a"]`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a GET body without legacy routes, got %d", rec.Code)
+	}
+	kvd.config.LegacyBulkRoutes = true
+	rec = httptest.NewRecorder()
+	kvd.keyManyGetHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/", strings.NewReader(`["a"]`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a GET body with legacy routes, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.mdeleteHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_mdelete", strings.NewReader(`["a","missing"]`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 when deleting a missing key, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.mdeleteHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_mdelete", strings.NewReader(`["a","b"]`)))
+	if rec.Code != http.StatusOK || kvd.db.Metrics().KeysStored != 0 {
+		t.Errorf("Expected all keys deleted, got %d with %d keys left", rec.Code, kvd.db.Metrics().KeysStored)
+	}
+}
+
+func TestBulkHandlersPartial(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.db.Set("a", "1")
+	kvd.db.Set("c", "3")
+
+	rec := httptest.NewRecorder()
+	kvd.mgetHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_mget?partial=true", strings.NewReader(`["a","b","c"]`)))
+	var got BulkGetResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode partial get %q: %v", rec.Body, err)
+	}
+	if len(got.Records) != 2 || len(got.Missing) != 1 || got.Missing[0] != "b" {
+		t.Errorf("Expected a and c found with b missing, got %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.mdeleteHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_mdelete?partial=true", strings.NewReader(`["a","b"]`)))
+	var deleted BulkDeleteResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &deleted); err != nil {
+		t.Fatalf("Failed to decode partial delete %q: %v", rec.Body, err)
+	}
+	if len(deleted.Deleted) != 1 || deleted.Deleted[0] != "a" || len(deleted.Missing) != 1 {
+		t.Errorf("Expected a deleted with b missing, got %+v", deleted)
+	}
+	if metrics := kvd.db.Metrics(); metrics.KeysStored != 1 || metrics.DelOps != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
@@ -0,0 +1,243 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix starts the names of the environment variables read by
+// ApplyConfigEnv: KVD_ followed by the setting's name in upper case, e.g.
+// KVD_PORT or KVD_MAX_VALUE_SIZE
+const ConfigEnvPrefix = "KVD_"
+
+// ErrConfig is returned for a configuration that cannot be loaded or used
+var ErrConfig = errors.New("invalid configuration")
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadConfigFile reads settings from a YAML (.yaml, .yml) or TOML (.toml)
+// file into config. Settings the file does not mention are left as they
+// are; settings kvd does not know are an error. Names are those of the
+// Config field tags, e.g. max_value_size, and durations are written as
+// strings such as "5s".
+func LoadConfigFile(path string, config *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: %s: %v", ErrConfig, path, err)
+		}
+	case ".toml":
+		meta, err := toml.NewDecoder(f).Decode(config)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfig, path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%w: %s: unknown setting %q", ErrConfig, path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("%w: %s: the file must end in .yaml, .yml or .toml", ErrConfig, path)
+	}
+	return nil
+}
+
+// ApplyConfigEnv overrides settings in config with the KVD_* environment
+// variables found by lookup, usually os.LookupEnv. Lists are
+// comma-separated and durations are written as "5s".
+func ApplyConfigEnv(config *Config, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := ConfigEnvPrefix + strings.ToUpper(configSettingName(v.Type().Field(i)))
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setConfigField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrConfig, name, err)
+		}
+	}
+	return nil
+}
+
+// ConfigSetting returns the field of config holding the setting with the
+// given name, as used in configuration files
+func ConfigSetting(config *Config, name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if configSettingName(v.Type().Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// configSettingName returns the name of the setting a Config field holds
+func configSettingName(field reflect.StructField) string {
+	if name := field.Tag.Get("yaml"); name != "" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// setConfigField parses value into a Config field
+func setConfigField(field reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate checks the configuration without opening any files or ports,
+// reporting every problem found rather than just the first
+func (c *Config) Validate() error {
+	var errs []error
+
+	ports := map[int]string{}
+	for _, p := range []struct {
+		name string
+		port int
+	}{
+		{"port", c.Port},
+		{"redis_port", c.RedisPort},
+		{"memcached_port", c.MemcachedPort},
+		{"grpc_port", c.GRPCPort},
+	} {
+		if p.port < 0 || p.port > 65535 {
+			errs = append(errs, fmt.Errorf("%s %d is not a valid port", p.name, p.port))
+			continue
+		}
+		if p.port == 0 {
+			continue
+		}
+		if other, ok := ports[p.port]; ok {
+			errs = append(errs, fmt.Errorf("%s and %s are both %d", other, p.name, p.port))
+		}
+		ports[p.port] = p.name
+	}
+
+	if c.MaxRecords < 0 {
+		errs = append(errs, errors.New("max_records cannot be negative"))
+	}
+	if c.ScriptMaxSteps < 0 || c.ScriptTimeout < 0 {
+		errs = append(errs, errors.New("script limits cannot be negative"))
+	}
+	if c.HotKeys < 0 {
+		errs = append(errs, errors.New("hot_keys cannot be negative"))
+	}
+	if c.AuditMaxSize < 0 || c.AuditMaxBackups < 0 || c.AuditQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("%w: sizes cannot be negative", ErrAuditConfig))
+	}
+	if c.AuditFile != "" && c.AuditWebhook != "" {
+		errs = append(errs, fmt.Errorf("%w: use either an audit file or an audit webhook", ErrAuditConfig))
+	}
+	if c.SnapshotInterval < 0 || c.DrainDelay < 0 || c.MaxMemory < 0 {
+		errs = append(errs, errors.New("snapshot_interval, drain_delay and max_memory cannot be negative"))
+	}
+	if c.SnapshotInterval > 0 && c.DataDir == "" {
+		errs = append(errs, errors.New("snapshot_interval requires data_dir"))
+	}
+	if c.AuthFile != "" && !c.AllowUnauthenticatedListeners {
+		for _, p := range []struct {
+			name string
+			port int
+		}{
+			{"redis_port", c.RedisPort},
+			{"memcached_port", c.MemcachedPort},
+			{"grpc_port", c.GRPCPort},
+		} {
+			if p.port != 0 {
+				errs = append(errs, fmt.Errorf("%s does not check auth_file tokens; set allow_unauthenticated_listeners to run it anyway", p.name))
+			}
+		}
+	}
+	for _, prefix := range c.ReservedPrefixes {
+		if prefix == "" {
+			errs = append(errs, errors.New("reserved_prefixes cannot contain an empty prefix"))
+		}
+	}
+
+	if _, _, err := newLogger(io.Discard, c); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" || c.TLSClientCAFile != "" {
+		switch {
+		case c.TLSCertFile == "" && c.TLSKeyFile == "":
+			errs = append(errs, fmt.Errorf("%w: client certificates require a server certificate", ErrTLSConfig))
+		case c.TLSCertFile == "" || c.TLSKeyFile == "":
+			errs = append(errs, fmt.Errorf("%w: both a certificate and a key file are needed", ErrTLSConfig))
+		}
+		if _, err := tlsVersion(c.TLSMinVersion); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := cipherSuites(c.TLSCipherSuites); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := newRateLimiter(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newRequestLimits(c); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrConfig, errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package kvd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	for _, path := range []string{
+		write("kvd.yaml", "port: 9000\nscript_timeout: 2s\nreserved_prefixes: [sys/]\n"),
+		write("kvd.toml", "port = 9000\nscript_timeout = \"2s\"\nreserved_prefixes = [\"sys/\"]\n"),
+	} {
+		config := DefaultConfig()
+		if err := LoadConfigFile(path, config); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if config.Port != 9000 || config.ScriptTimeout != 2*time.Second || len(config.ReservedPrefixes) != 1 || config.ReservedPrefixes[0] != "sys/" {
+			t.Errorf("%s: unexpected config %+v", path, config)
+		}
+		if config.Host != "0.0.0.0" || config.MaxKeyLength != DefaultMaxKeyLength {
+			t.Errorf("%s: expected settings the file omits to be kept, got %+v", path, config)
+		}
+	}
+
+	for _, path := range []string{
+		write("unknown.yaml", "prot: 9000\n"),
+		write("unknown.toml", "prot = 9000\n"),
+		write("kvd.json", "{}"),
+		filepath.Join(dir, "missing.yaml"),
+	} {
+		if err := LoadConfigFile(path, DefaultConfig()); !errors.Is(err, ErrConfig) {
+			t.Errorf("%s: expected ErrConfig, got %v", path, err)
+		}
+	}
+}
+
+func TestApplyConfigEnv(t *testing.T) {
+	env := map[string]string{
+		"KVD_PORT":               "9001",
+		"KVD_LOG_LEVEL":          "debug",
+		"KVD_RATE_LIMIT":         "2.5",
+		"KVD_LEGACY_BULK_ROUTES": "true",
+		"KVD_SCRIPT_TIMEOUT":     "1s",
+		"KVD_RESERVED_PREFIXES":  "_kvd/, sys/",
+		"KVD_TOKEN":              "not a server setting",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	config := DefaultConfig()
+	if err := ApplyConfigEnv(config, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 9001 || config.LogLevel != "debug" || config.RateLimit != 2.5 || !config.LegacyBulkRoutes || config.ScriptTimeout != time.Second {
+		t.Errorf("Unexpected config %+v", config)
+	}
+	if strings.Join(config.ReservedPrefixes, "|") != "_kvd/|sys/" {
+		t.Errorf("Expected a comma-separated list, got %q", config.ReservedPrefixes)
+	}
+
+	env = map[string]string{"KVD_MAX_VALUE_SIZE": "1MB"}
+	if err := ApplyConfigEnv(DefaultConfig(), lookup); !errors.Is(err, ErrConfig) || !strings.Contains(err.Error(), "KVD_MAX_VALUE_SIZE") {
+		t.Errorf("Expected ErrConfig naming the variable, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
+	}
+	if err := (&Config{}).Validate(); err != nil {
+		t.Errorf("Expected a zero config to be valid, got %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Port = 70000
+	config.RedisPort = 6379
+	config.GRPCPort = 6379
+	config.LogFormat = "xml"
+	config.TLSKeyFile = "key.pem"
+	config.MaxValueSize = -1
+	err := config.Validate()
+	if !errors.Is(err, ErrConfig) || !errors.Is(err, ErrTLSConfig) || !errors.Is(err, ErrLimitConfig) {
+		t.Fatalf("Expected every problem to be reported, got %v", err)
+	}
+	for _, want := range []string{"port 70000", "redis_port and grpc_port", "log format"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %q", want, err)
+		}
+	}
+
+	if err := (&Kvd{}).Init(config); !errors.Is(err, ErrConfig) {
+		t.Errorf("Expected Init to validate, got %v", err)
+	}
+
+	// Listeners that do not check tokens need an explicit opt-in
+	config = DefaultConfig()
+	config.AuthFile = "tokens.json"
+	config.RedisPort = 6379
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "redis_port does not check") {
+		t.Errorf("Expected the Redis listener to be refused with auth, got %v", err)
+	}
+	config.AllowUnauthenticatedListeners = true
+	if err := config.Validate(); err != nil {
+		t.Errorf("Expected the opt-in to allow the listener, got %v", err)
+	}
+}
+
+func TestDataDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	config := &Config{DataDir: dir, LogLevel: "warn"}
+
+	kvd := &Kvd{}
+	if err := kvd.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvd.loadDataDir(); err != nil {
+		t.Fatal(err)
+	}
+	kvd.db.Set("a", "1")
+	kvd.db.Set("b", "2")
+	if err := kvd.saveDataDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &Kvd{}
+	if err := restarted.Init(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.loadDataDir(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := restarted.db.Get("b"); err != nil || value != "2" {
+		t.Errorf("Expected the saved data to be loaded, got %q %v", value, err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != dataSnapshotFile {
+		t.Errorf("Expected only the snapshot in the data directory, got %v", entries)
+	}
+}
@@ -0,0 +1,129 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// dataSnapshotFile is the snapshot kept in the data directory
+const dataSnapshotFile = "kvd.snapshot"
+
+// saveStatus records the outcome of the last save to the data directory
+type saveStatus struct {
+	mutex sync.Mutex
+	at    time.Time
+	err   error
+}
+
+// lastSave returns when the data was last saved, or loaded, and the error
+// of the last save if it failed
+func (kvd *Kvd) lastSave() (time.Time, error) {
+	kvd.saves.mutex.Lock()
+	defer kvd.saves.mutex.Unlock()
+	return kvd.saves.at, kvd.saves.err
+}
+
+// recordSave records the outcome of a save
+func (kvd *Kvd) recordSave(err error) {
+	kvd.saves.mutex.Lock()
+	defer kvd.saves.mutex.Unlock()
+	if err == nil {
+		kvd.saves.at = time.Now()
+	}
+	kvd.saves.err = err
+}
+
+// loadDataDir creates the data directory if needed and restores the
+// snapshot saved there by the last shutdown, if any
+func (kvd *Kvd) loadDataDir() error {
+	dir := kvd.config.DataDir
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("could not create data directory: %w", err)
+	}
+
+	path := filepath.Join(dir, dataSnapshotFile)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		kvd.recordSave(nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	result, err := kvd.db.RestoreSnapshot(f, RestoreReplace)
+	if err != nil {
+		return fmt.Errorf("could not load %s: %w", path, err)
+	}
+	kvd.logger.Info("Loaded data", "file", path, "keys", result.Restored, "expired", result.Expired)
+	kvd.recordSave(nil)
+	return nil
+}
+
+// saveDataDir writes a snapshot of the database to the data directory. The
+// snapshot is written to a temporary file and renamed into place, so a
+// failed save leaves the previous one intact.
+func (kvd *Kvd) saveDataDir() error {
+	if kvd.config.DataDir == "" {
+		return nil
+	}
+	err := kvd.writeDataDir()
+	kvd.recordSave(err)
+	return err
+}
+
+// writeDataDir writes the snapshot for saveDataDir
+func (kvd *Kvd) writeDataDir() error {
+	dir := kvd.config.DataDir
+
+	f, err := os.CreateTemp(dir, dataSnapshotFile+".*")
+	if err != nil {
+		return fmt.Errorf("could not save data: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	keys, err := kvd.db.WriteSnapshot(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not save data: %w", err)
+	}
+
+	path := filepath.Join(dir, dataSnapshotFile)
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("could not save data: %w", err)
+	}
+	kvd.logger.Info("Saved data", "file", path, "keys", keys)
+	return nil
+}
+
+// runSnapshots saves the data every interval until ctx is done
+func (kvd *Kvd) runSnapshots(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := kvd.saveDataDir(); err != nil {
+				kvd.logger.Error("Data save error", "err", err)
+			}
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package kvd
 
 import (
+	"context"
 	"errors"
-	"sync"
 	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Common errors
@@ -16,9 +20,51 @@ var (
 
 // DB represents the key-value database
 type DB struct {
-	mutex   *sync.RWMutex
+	mutex   *timedRWMutex
 	store   map[string]string
 	metrics *Metrics
+
+	// The keys of store in sorted order, guarded by mutex
+	order keyOrder
+
+	// Lease and lock state, guarded by mutex
+	leases      map[int64]*lease
+	keyLeases   map[string]int64
+	locks       map[string]*lockEntry
+	nextLeaseID int64
+	fenceToken  int64
+
+	// Registered server-side scripts
+	scripts scriptStore
+
+	// Secondary indexes by name, guarded by mutex
+	indexes map[string]*secondaryIndex
+
+	// Per-key expiry times, guarded by mutex
+	expires map[string]time.Time
+
+	// Key prefixes clients may not write, set before use
+	reserved []string
+
+	// Most keys the store may hold, zero for no limit; guarded by mutex
+	maxRecords int
+
+	// Per-key flags and versions, guarded by mutex
+	meta        map[string]KeyMeta
+	nextVersion uint64
+
+	// Key change watchers, guarded by mutex
+	watchers      map[int64]*Watcher
+	nextWatcherID int64
+
+	// Latency, value size and batch size histograms
+	histograms *dbHistograms
+
+	// Optional hot-key tracker, guarded by mutex
+	hotKeys *hotKeys
+
+	// Optional audit log of changes
+	audit *auditLog
 }
 
 // Metrics tracks usage statistics for the database
@@ -28,12 +74,37 @@ type Metrics struct {
 	GetOps           int64 `json:"GetOps"`
 	SetOps           int64 `json:"SetOps"`
 	DelOps           int64 `json:"DelOps"`
+
+	// LockAcquisitions and LockWaitNanos count acquisitions of the
+	// database lock and the total time spent waiting for it
+	LockAcquisitions int64 `json:"LockAcquisitions"`
+	LockWaitNanos    int64 `json:"LockWaitNanos"`
+
+	// Latency holds per-operation latency histograms in nanoseconds
+	Latency map[string]HistogramSnapshot `json:"Latency,omitempty"`
+	// ValueSize is the distribution of written value sizes in bytes
+	ValueSize HistogramSnapshot `json:"ValueSize"`
+	// BatchSize is the distribution of keys per bulk operation
+	BatchSize HistogramSnapshot `json:"BatchSize"`
+
+	// AuditWritten, AuditDropped and AuditFailed count audit entries
+	// written, dropped because the queue was full, and lost to sink errors
+	AuditWritten int64 `json:"AuditWritten,omitempty"`
+	AuditDropped int64 `json:"AuditDropped,omitempty"`
+	AuditFailed  int64 `json:"AuditFailed,omitempty"`
+
+	// Channels holds per-channel pub/sub counters
+	Channels map[string]ChannelMetrics `json:"Channels,omitempty"`
+
+	// RateLimited counts HTTP requests rejected by rate limits, by scope
+	RateLimited map[string]int64 `json:"RateLimited,omitempty"`
 }
 
 // Init initializes the database
 func (db *DB) Init() error {
-	db.mutex = &sync.RWMutex{}
+	db.mutex = &timedRWMutex{}
 	db.store = make(map[string]string)
+	db.order = keyOrder{}
 	db.metrics = &Metrics{
 		KeysStored:       0,
 		ValueBytesStored: 0,
@@ -41,12 +112,56 @@ func (db *DB) Init() error {
 		SetOps:           0,
 		DelOps:           0,
 	}
+	db.leases = make(map[int64]*lease)
+	db.keyLeases = make(map[string]int64)
+	db.locks = make(map[string]*lockEntry)
+	db.indexes = make(map[string]*secondaryIndex)
+	db.expires = make(map[string]time.Time)
+	db.meta = make(map[string]KeyMeta)
+	db.watchers = make(map[int64]*Watcher)
+	db.histograms = newDBHistograms()
 
 	return nil
 }
 
+// Metrics returns a point-in-time copy of the database metrics
+func (db *DB) Metrics() Metrics {
+	acquisitions, wait := db.mutex.stats()
+	latency := make(map[string]HistogramSnapshot, len(db.histograms.latency))
+	for op, h := range db.histograms.latency {
+		latency[op] = h.Snapshot()
+	}
+
+	metrics := Metrics{
+		KeysStored:       atomic.LoadInt64(&db.metrics.KeysStored),
+		ValueBytesStored: atomic.LoadInt64(&db.metrics.ValueBytesStored),
+		GetOps:           atomic.LoadInt64(&db.metrics.GetOps),
+		SetOps:           atomic.LoadInt64(&db.metrics.SetOps),
+		DelOps:           atomic.LoadInt64(&db.metrics.DelOps),
+		LockAcquisitions: acquisitions,
+		LockWaitNanos:    int64(wait),
+		Latency:          latency,
+		ValueSize:        db.histograms.valueSize.Snapshot(),
+		BatchSize:        db.histograms.batchSize.Snapshot(),
+	}
+	if db.audit != nil {
+		metrics.AuditWritten = atomic.LoadInt64(&db.audit.written)
+		metrics.AuditDropped = atomic.LoadInt64(&db.audit.dropped)
+		metrics.AuditFailed = atomic.LoadInt64(&db.audit.failed)
+	}
+	return metrics
+}
+
 // Get retrieves a value for a given key
 func (db *DB) Get(key string) (string, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext is Get with a context for tracing
+func (db *DB) GetContext(ctx context.Context, key string) (string, error) {
+	defer db.histograms.latency[OpGet].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.Get")
+	defer span.End()
 	// Increment operations counter regardless of result
 	atomic.AddInt64(&db.metrics.GetOps, 1)
 	
@@ -54,8 +169,9 @@ func (db *DB) Get(key string) (string, error) {
 		return "", ErrEmptyKey
 	}
 
-	db.mutex.RLock()
-	value, ok := db.store[key]
+	db.mutex.RLockContext(ctx)
+	value, ok := db.lookupLocked(key)
+	db.recordReadLocked(key)
 	db.mutex.RUnlock()
 
 	if !ok {
@@ -67,16 +183,73 @@ func (db *DB) Get(key string) (string, error) {
 
 // Set stores a key-value pair
 func (db *DB) Set(key string, value string) error {
-	if key == "" {
-		return ErrEmptyKey
+	return db.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set with a context for tracing
+func (db *DB) SetContext(ctx context.Context, key string, value string) error {
+	defer db.histograms.latency[OpSet].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.Set")
+	defer span.End()
+	if err := db.checkWriteKey(key); err != nil {
+		return err
 	}
 
-	db.mutex.Lock()
+	db.mutex.LockContext(ctx)
 	defer db.mutex.Unlock()
 	
+	if err := db.roomLocked(key); err != nil {
+		return err
+	}
+	db.overwriteLocked(key, value)
+	db.auditLocked(ctx, AuditSet, key, &value)
+	atomic.AddInt64(&db.metrics.SetOps, 1)
+
+	return nil
+}
+
+// lookupLocked returns a key's value unless it is missing or expired. The
+// caller must hold at least the read lock.
+func (db *DB) lookupLocked(key string) (string, bool) {
+	value, ok := db.store[key]
+	if !ok {
+		return "", false
+	}
+	if expiry, ok := db.expires[key]; ok && !time.Now().Before(expiry) {
+		return "", false
+	}
+	return value, true
+}
+
+// overwriteLocked stores a value as a plain set does, dropping any lease,
+// expiry or flags the key had. The caller must hold the write lock.
+func (db *DB) overwriteLocked(key string, value string) {
+	delete(db.meta, key)
+	db.putLocked(key, value)
+	db.detachKeyLocked(key)
+	delete(db.expires, key)
+}
+
+// putLocked stores a value, bumps the key's version and updates the size
+// metrics. The caller must hold the write lock.
+func (db *DB) putLocked(key string, value string) {
 	oldValue, existing := db.store[key]
 	db.store[key] = value
-	atomic.AddInt64(&db.metrics.SetOps, 1)
+	if !existing {
+		db.order.insert(key)
+	}
+	db.indexPutLocked(key, value)
+	db.histograms.valueSize.Observe(int64(len(value)))
+	db.recordWriteLocked(key)
+
+	db.nextVersion++
+	meta := db.meta[key]
+	meta.Version = db.nextVersion
+	db.meta[key] = meta
+
+	if len(db.watchers) > 0 {
+		db.notifyLocked(WatchEvent{Type: WatchPut, Key: key, Value: value, Version: meta.Version})
+	}
 	
 	if existing {
 		// Update bytes stored (subtract old value size, add new value size)
@@ -88,41 +261,69 @@ func (db *DB) Set(key string, value string) error {
 		atomic.AddInt64(&db.metrics.KeysStored, 1)
 		atomic.AddInt64(&db.metrics.ValueBytesStored, int64(len(value)))
 	}
+}
 
-	return nil
+// removeLocked deletes a key and updates the size metrics, returning the
+// removed value. The caller must hold the write lock.
+func (db *DB) removeLocked(key string) (string, bool) {
+	value, exists := db.store[key]
+	if !exists {
+		return "", false
+	}
+	
+	delete(db.store, key)
+	db.order.remove(key)
+	delete(db.expires, key)
+	delete(db.meta, key)
+	db.detachKeyLocked(key)
+	db.indexRemoveLocked(key)
+	atomic.AddInt64(&db.metrics.KeysStored, -1)
+	atomic.AddInt64(&db.metrics.ValueBytesStored, -int64(len(value)))
+
+	if len(db.watchers) > 0 {
+		db.notifyLocked(WatchEvent{Type: WatchDelete, Key: key})
+	}
+
+	return value, true
 }
 
 // BulkSet sets multiple key-value pairs atomically
 func (db *DB) BulkSet(records []Record) error {
+	return db.BulkSetContext(context.Background(), records)
+}
+
+// BulkSetContext is BulkSet with a context for tracing
+func (db *DB) BulkSetContext(ctx context.Context, records []Record) error {
+	defer db.histograms.latency[OpBulkSet].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.BulkSet", trace.WithAttributes(attribute.Int("kvd.keys", len(records))))
+	defer span.End()
+	db.histograms.batchSize.Observe(int64(len(records)))
 	if len(records) == 0 {
 		return nil
 	}
 
-	db.mutex.Lock()
+	db.mutex.LockContext(ctx)
 	defer db.mutex.Unlock()
 	
 	// Validate all keys and values first
 	for _, r := range records {
-		if r.Key == "" {
-			return ErrEmptyKey
+		if err := db.checkWriteKey(r.Key); err != nil {
+			return err
 		}
 	}
+	keys := make([]string, len(records))
+	for i, r := range records {
+		keys[i] = r.Key
+	}
+	if err := db.roomLocked(keys...); err != nil {
+		return err
+	}
 	
 	// Process all records
 	for _, r := range records {
-		oldValue, existing := db.store[r.Key]
-		db.store[r.Key] = r.Value
-		
-		if existing {
-			// Update metrics for existing key
-			oldBytes := len(oldValue)
-			newBytes := len(r.Value)
-			db.metrics.ValueBytesStored += int64(newBytes - oldBytes)
-		} else {
-			// Update metrics for new key
-			db.metrics.KeysStored++
-			db.metrics.ValueBytesStored += int64(len(r.Value))
-		}
+		value := r.Value
+		db.overwriteLocked(r.Key, value)
+		db.auditLocked(ctx, AuditBulkSet, r.Key, &value)
 	}
 	
 	// Update operation count once for the entire batch
@@ -133,6 +334,15 @@ func (db *DB) BulkSet(records []Record) error {
 
 // BulkGet retrieves multiple values by their keys
 func (db *DB) BulkGet(keys []string) ([]Record, error) {
+	return db.BulkGetContext(context.Background(), keys)
+}
+
+// BulkGetContext is BulkGet with a context for tracing
+func (db *DB) BulkGetContext(ctx context.Context, keys []string) ([]Record, error) {
+	defer db.histograms.latency[OpBulkGet].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.BulkGet", trace.WithAttributes(attribute.Int("kvd.keys", len(keys))))
+	defer span.End()
+	db.histograms.batchSize.Observe(int64(len(keys)))
 	if len(keys) == 0 {
 		return []Record{}, nil
 	}
@@ -140,7 +350,7 @@ func (db *DB) BulkGet(keys []string) ([]Record, error) {
 	// Pre-allocate the slice for efficiency
 	records := make([]Record, 0, len(keys))
 	
-	db.mutex.RLock()
+	db.mutex.RLockContext(ctx)
 	defer db.mutex.RUnlock()
 	
 	for _, key := range keys {
@@ -148,7 +358,8 @@ func (db *DB) BulkGet(keys []string) ([]Record, error) {
 			return nil, ErrEmptyKey
 		}
 		
-		value, ok := db.store[key]
+		value, ok := db.lookupLocked(key)
+		db.recordReadLocked(key)
 		if !ok {
 			return nil, ErrKeyNotFound
 		}
@@ -165,45 +376,97 @@ func (db *DB) BulkGet(keys []string) ([]Record, error) {
 	return records, nil
 }
 
+// BulkGetPartial retrieves the values for those keys that exist and
+// reports the keys that do not, instead of failing on the first missing key
+func (db *DB) BulkGetPartial(keys []string) ([]Record, []string, error) {
+	return db.BulkGetPartialContext(context.Background(), keys)
+}
+
+// BulkGetPartialContext is BulkGetPartial with a context for tracing
+func (db *DB) BulkGetPartialContext(ctx context.Context, keys []string) ([]Record, []string, error) {
+	defer db.histograms.latency[OpBulkGet].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.BulkGetPartial", trace.WithAttributes(attribute.Int("kvd.keys", len(keys))))
+	defer span.End()
+	db.histograms.batchSize.Observe(int64(len(keys)))
+	records := make([]Record, 0, len(keys))
+	missing := []string{}
+
+	db.mutex.RLockContext(ctx)
+	defer db.mutex.RUnlock()
+
+	for _, key := range keys {
+		if key == "" {
+			return nil, nil, ErrEmptyKey
+		}
+
+		value, ok := db.lookupLocked(key)
+		db.recordReadLocked(key)
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+		records = append(records, Record{Key: key, Value: value})
+	}
+
+	atomic.AddInt64(&db.metrics.GetOps, int64(len(keys)))
+
+	return records, missing, nil
+}
+
 // Delete removes a key-value pair
 func (db *DB) Delete(key string) error {
-	if key == "" {
-		return ErrEmptyKey
+	return db.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete with a context for tracing
+func (db *DB) DeleteContext(ctx context.Context, key string) error {
+	defer db.histograms.latency[OpDelete].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.Delete")
+	defer span.End()
+	if err := db.checkWriteKey(key); err != nil {
+		return err
 	}
 
-	db.mutex.Lock()
+	db.mutex.LockContext(ctx)
 	defer db.mutex.Unlock()
 	
-	value, exists := db.store[key]
-	if !exists {
+	db.expireDueLocked(key)
+	if _, exists := db.removeLocked(key); !exists {
 		return ErrKeyNotFound
 	}
-	
-	delete(db.store, key)
+	db.auditLocked(ctx, AuditDelete, key, nil)
 	
 	// Update metrics
 	atomic.AddInt64(&db.metrics.DelOps, 1)
-	atomic.AddInt64(&db.metrics.KeysStored, -1)
-	atomic.AddInt64(&db.metrics.ValueBytesStored, -int64(len(value)))
 
 	return nil
 }
 
 // BulkDelete removes multiple key-value pairs
 func (db *DB) BulkDelete(keys []string) error {
+	return db.BulkDeleteContext(context.Background(), keys)
+}
+
+// BulkDeleteContext is BulkDelete with a context for tracing
+func (db *DB) BulkDeleteContext(ctx context.Context, keys []string) error {
+	defer db.histograms.latency[OpBulkDelete].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.BulkDelete", trace.WithAttributes(attribute.Int("kvd.keys", len(keys))))
+	defer span.End()
+	db.histograms.batchSize.Observe(int64(len(keys)))
 	if len(keys) == 0 {
 		return nil
 	}
 
-	db.mutex.Lock()
+	db.mutex.LockContext(ctx)
 	defer db.mutex.Unlock()
 	
 	// First, check if all keys exist
 	for _, key := range keys {
-		if key == "" {
-			return ErrEmptyKey
+		if err := db.checkWriteKey(key); err != nil {
+			return err
 		}
 		
+		db.expireDueLocked(key)
 		_, exists := db.store[key]
 		if !exists {
 			return ErrKeyNotFound
@@ -211,17 +474,53 @@ func (db *DB) BulkDelete(keys []string) error {
 	}
 	
 	// Then delete all keys
-	totalBytesRemoved := int64(0)
 	for _, key := range keys {
-		value := db.store[key]
-		totalBytesRemoved += int64(len(value))
-		delete(db.store, key)
+		db.removeLocked(key)
+		db.auditLocked(ctx, AuditBulkDelete, key, nil)
 	}
 	
 	// Update metrics
 	atomic.AddInt64(&db.metrics.DelOps, int64(len(keys)))
-	atomic.AddInt64(&db.metrics.KeysStored, -int64(len(keys)))
-	atomic.AddInt64(&db.metrics.ValueBytesStored, -totalBytesRemoved)
 
 	return nil
 }
+
+// BulkDeletePartial removes those keys that exist and reports which keys
+// were removed and which were missing, instead of deleting nothing when a
+// key is missing
+func (db *DB) BulkDeletePartial(keys []string) ([]string, []string, error) {
+	return db.BulkDeletePartialContext(context.Background(), keys)
+}
+
+// BulkDeletePartialContext is BulkDeletePartial with a context for tracing
+func (db *DB) BulkDeletePartialContext(ctx context.Context, keys []string) ([]string, []string, error) {
+	defer db.histograms.latency[OpBulkDelete].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.BulkDeletePartial", trace.WithAttributes(attribute.Int("kvd.keys", len(keys))))
+	defer span.End()
+	db.histograms.batchSize.Observe(int64(len(keys)))
+	deleted := []string{}
+	missing := []string{}
+
+	db.mutex.LockContext(ctx)
+	defer db.mutex.Unlock()
+
+	for _, key := range keys {
+		if err := db.checkWriteKey(key); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, key := range keys {
+		db.expireDueLocked(key)
+		if _, ok := db.removeLocked(key); ok {
+			db.auditLocked(ctx, AuditBulkDelete, key, nil)
+			deleted = append(deleted, key)
+		} else {
+			missing = append(missing, key)
+		}
+	}
+
+	atomic.AddInt64(&db.metrics.DelOps, int64(len(deleted)))
+
+	return deleted, missing, nil
+}
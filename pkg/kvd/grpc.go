@@ -0,0 +1,272 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/drewnix/kvd/pkg/kvdpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults for gRPC streams
+const (
+	defaultScanBatch = 100
+	maxScanBatch     = 10000
+
+	// grpcStopTimeout bounds how long shutdown waits for in-flight calls
+	grpcStopTimeout = 10 * time.Second
+)
+
+// grpcServer implements the KV gRPC service on top of the database
+type grpcServer struct {
+	kvdpb.UnimplementedKVServer
+	kvd  *Kvd
+	done <-chan struct{}
+}
+
+// startGRPCServer serves the KV gRPC service on host:port until ctx is done
+func (kvd *Kvd) startGRPCServer(ctx context.Context, host string, port int) error {
+	address := fmt.Sprintf("%s:%d", host, port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("could not start gRPC listener: %w", err)
+	}
+
+	// The message size limit is fixed when the listener starts; a reload
+	// of max_body_size does not change it
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(callerInterceptor),
+		grpc.StreamInterceptor(callerStreamInterceptor),
+		grpc.MaxRecvMsgSize(int(kvd.settings().limits.maxBodySize)),
+	)
+	kvdpb.RegisterKVServer(srv, &grpcServer{kvd: kvd, done: ctx.Done()})
+
+	kvd.logger.Info("Starting gRPC listener", "addr", listener.Addr().String())
+	go func() {
+		if err := srv.Serve(listener); err != nil {
+			kvd.logger.Error("gRPC server error", "err", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(grpcStopTimeout):
+			srv.Stop()
+		}
+	}()
+
+	return nil
+}
+
+// callerInterceptor records the peer address and the x-client-id metadata
+// of each call as its caller, for auditing
+func callerInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(grpcCallerContext(ctx), req)
+}
+
+// callerStreamInterceptor is callerInterceptor for streaming calls
+func callerStreamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &callerStream{ServerStream: ss, ctx: grpcCallerContext(ss.Context())})
+}
+
+// callerStream is a server stream whose context carries the caller
+type callerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream's context with its caller
+func (s *callerStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcCallerContext returns ctx with the caller of the gRPC call it belongs to
+func grpcCallerContext(ctx context.Context) context.Context {
+	var caller Caller
+	if p, ok := peer.FromContext(ctx); ok {
+		caller.Remote = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(strings.ToLower(ClientIDHeader)); len(ids) > 0 {
+			caller.Identity = ids[0]
+		}
+	}
+	return WithCaller(ctx, caller)
+}
+
+// grpcError converts a DB error into a gRPC status error
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrEmptyKey), errors.Is(err, ErrInvalidKey), errors.Is(err, ErrNilValue), errors.Is(err, ErrKeyTooLong):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrReservedKey):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrWatchOverflow), errors.Is(err, ErrValueTooLarge), errors.Is(err, ErrTooManyRecords), errors.Is(err, ErrStoreFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func (s *grpcServer) Get(ctx context.Context, req *kvdpb.GetRequest) (*kvdpb.GetResponse, error) {
+	value, err := s.kvd.db.GetContext(ctx, req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvdpb.GetResponse{Record: &kvdpb.Record{Key: req.Key, Value: value}}, nil
+}
+
+func (s *grpcServer) Set(ctx context.Context, req *kvdpb.SetRequest) (*kvdpb.SetResponse, error) {
+	if err := s.kvd.settings().limits.checkRecord(Record{Key: req.Key, Value: req.Value}); err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.kvd.db.SetContext(ctx, req.Key, req.Value); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvdpb.SetResponse{}, nil
+}
+
+func (s *grpcServer) Delete(ctx context.Context, req *kvdpb.DeleteRequest) (*kvdpb.DeleteResponse, error) {
+	if err := s.kvd.db.DeleteContext(ctx, req.Key); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvdpb.DeleteResponse{}, nil
+}
+
+func (s *grpcServer) BulkGet(ctx context.Context, req *kvdpb.BulkGetRequest) (*kvdpb.BulkGetResponse, error) {
+	if err := s.kvd.settings().limits.checkCount(len(req.Keys)); err != nil {
+		return nil, grpcError(err)
+	}
+	records, err := s.kvd.db.BulkGetContext(ctx, req.Keys)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &kvdpb.BulkGetResponse{Records: make([]*kvdpb.Record, 0, len(records))}
+	for _, r := range records {
+		resp.Records = append(resp.Records, &kvdpb.Record{Key: r.Key, Value: r.Value})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) BulkSet(ctx context.Context, req *kvdpb.BulkSetRequest) (*kvdpb.BulkSetResponse, error) {
+	if err := s.kvd.settings().limits.checkCount(len(req.Records)); err != nil {
+		return nil, grpcError(err)
+	}
+	records := make([]Record, 0, len(req.Records))
+	for _, r := range req.Records {
+		record := Record{Key: r.GetKey(), Value: r.GetValue()}
+		if err := s.kvd.settings().limits.checkRecord(record); err != nil {
+			return nil, grpcError(err)
+		}
+		records = append(records, record)
+	}
+
+	if err := s.kvd.db.BulkSetContext(ctx, records); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvdpb.BulkSetResponse{}, nil
+}
+
+func (s *grpcServer) BulkDelete(ctx context.Context, req *kvdpb.BulkDeleteRequest) (*kvdpb.BulkDeleteResponse, error) {
+	if err := s.kvd.settings().limits.checkCount(len(req.Keys)); err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.kvd.db.BulkDeleteContext(ctx, req.Keys); err != nil {
+		return nil, grpcError(err)
+	}
+	return &kvdpb.BulkDeleteResponse{}, nil
+}
+
+func (s *grpcServer) Metrics(ctx context.Context, req *kvdpb.MetricsRequest) (*kvdpb.MetricsResponse, error) {
+	metrics := s.kvd.db.Metrics()
+	return &kvdpb.MetricsResponse{
+		KeysStored:       metrics.KeysStored,
+		ValueBytesStored: metrics.ValueBytesStored,
+		GetOps:           metrics.GetOps,
+		SetOps:           metrics.SetOps,
+		DelOps:           metrics.DelOps,
+	}, nil
+}
+
+// Scan lists the matching keys once, then streams their values in batches
+// so the database lock is never held while sending. Keys deleted after the
+// listing are skipped.
+func (s *grpcServer) Scan(req *kvdpb.ScanRequest, stream kvdpb.KV_ScanServer) error {
+	batch := int(req.BatchSize)
+	if batch <= 0 {
+		batch = defaultScanBatch
+	}
+	if batch > maxScanBatch {
+		batch = maxScanBatch
+	}
+
+	keys := s.kvd.db.Keys(req.Pattern)
+	for start := 0; start < len(keys); start += batch {
+		end := start + batch
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		for _, r := range s.kvd.db.lookupRecords(keys[start:end]) {
+			if err := stream.Send(&kvdpb.Record{Key: r.Key, Value: r.Value}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Watch streams key changes until the client cancels or the server stops
+func (s *grpcServer) Watch(req *kvdpb.WatchRequest, stream kvdpb.KV_WatchServer) error {
+	w := s.kvd.db.Watch(req.Pattern)
+	defer s.kvd.db.Unwatch(w)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case event, ok := <-w.Events():
+			if !ok {
+				return grpcError(w.Err())
+			}
+			if err := stream.Send(watchEventProto(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchEventProto converts a watch event to its wire form
+func watchEventProto(event WatchEvent) *kvdpb.WatchEvent {
+	pb := &kvdpb.WatchEvent{
+		Key:     event.Key,
+		Value:   event.Value,
+		Version: event.Version,
+	}
+	switch event.Type {
+	case WatchPut:
+		pb.Type = kvdpb.WatchEvent_TYPE_PUT
+	case WatchDelete:
+		pb.Type = kvdpb.WatchEvent_TYPE_DELETE
+	}
+	return pb
+}
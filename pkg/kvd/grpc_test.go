@@ -0,0 +1,168 @@
+package kvd
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/drewnix/kvd/pkg/kvdpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestGRPCClient(t *testing.T, kvd *Kvd) kvdpb.KVClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1048576)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(callerInterceptor), grpc.StreamInterceptor(callerStreamInterceptor))
+	kvdpb.RegisterKVServer(srv, &grpcServer{kvd: kvd})
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return kvdpb.NewKVClient(conn)
+}
+
+func TestGRPCKeyOperations(t *testing.T) {
+	kvd := newTestKvd(t)
+	client := newTestGRPCClient(t, kvd)
+	ctx := context.Background()
+
+	if _, err := client.Set(ctx, &kvdpb.SetRequest{Key: "a", Value: "1"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	resp, err := client.Get(ctx, &kvdpb.GetRequest{Key: "a"})
+	if err != nil || resp.Record.Value != "1" {
+		t.Errorf("Expected value 1, got %v, %v", resp, err)
+	}
+
+	_, err = client.Get(ctx, &kvdpb.GetRequest{Key: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+	_, err = client.Set(ctx, &kvdpb.SetRequest{Key: ""})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument, got %v", err)
+	}
+
+	_, err = client.BulkSet(ctx, &kvdpb.BulkSetRequest{Records: []*kvdpb.Record{
+		{Key: "b", Value: "2"}, {Key: "c", Value: "3"},
+	}})
+	if err != nil {
+		t.Fatalf("BulkSet failed: %v", err)
+	}
+
+	stream, err := client.Scan(ctx, &kvdpb.ScanRequest{Pattern: "[ab]", BatchSize: 1})
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	var keys []string
+	for {
+		record, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Scan stream failed: %v", err)
+		}
+		keys = append(keys, record.Key)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("Expected keys [a b], got %v", keys)
+	}
+
+	metrics, err := client.Metrics(ctx, &kvdpb.MetricsRequest{})
+	if err != nil || metrics.KeysStored != 3 || metrics.SetOps != 3 {
+		t.Errorf("Unexpected metrics %v, %v", metrics, err)
+	}
+}
+
+// contextStream is a server stream that only has a context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestGRPCStreamCaller(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "alice"))
+	var caller Caller
+	err := callerStreamInterceptor(nil, contextStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(_ interface{}, ss grpc.ServerStream) error {
+		caller = CallerFromContext(ss.Context())
+		return nil
+	})
+	if err != nil || caller.Identity != "alice" {
+		t.Errorf("Expected caller alice on the stream, got %+v: %v", caller, err)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	kvd := newTestKvd(t)
+	client := newTestGRPCClient(t, kvd)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &kvdpb.WatchRequest{Pattern: "user:*"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// Wait for the server to register the watcher before writing
+	for {
+		kvd.db.mutex.RLock()
+		n := len(kvd.db.watchers)
+		kvd.db.mutex.RUnlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	kvd.db.Set("order:1", "ignored")
+	kvd.db.Set("user:1", "alice")
+	kvd.db.Delete("user:1")
+
+	event, err := stream.Recv()
+	if err != nil || event.Type != kvdpb.WatchEvent_TYPE_PUT || event.Key != "user:1" || event.Value != "alice" {
+		t.Errorf("Expected put of user:1, got %v, %v", event, err)
+	}
+	event, err = stream.Recv()
+	if err != nil || event.Type != kvdpb.WatchEvent_TYPE_DELETE || event.Key != "user:1" {
+		t.Errorf("Expected delete of user:1, got %v, %v", event, err)
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	db := newTestDB(t)
+	w := db.Watch("")
+
+	for i := 0; i <= watcherBuffer; i++ {
+		db.Set("key", "value")
+	}
+
+	for range w.Events() {
+	}
+	if w.Err() != ErrWatchOverflow {
+		t.Errorf("Expected ErrWatchOverflow, got %v", w.Err())
+	}
+	db.Unwatch(w)
+}
@@ -0,0 +1,219 @@
+package kvd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Version is the kvd version, set at build time with
+// -ldflags "-X github.com/drewnix/kvd/pkg/kvd.Version=v1.2.3"
+var Version = "dev"
+
+// healthCheckTimeout bounds each health check
+const healthCheckTimeout = 5 * time.Second
+
+// Server states reported by /readyz
+const (
+	serverReady int32 = iota
+	serverLoading
+	serverDraining
+)
+
+// serverStates names the server states
+var serverStates = map[int32]string{
+	serverReady:    "ready",
+	serverLoading:  "loading",
+	serverDraining: "draining",
+}
+
+// healthRoutes are served before the data is loaded and without a token
+// or rate limit, so probes always reach them
+var healthRoutes = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// HealthCheck reports a problem with the server as an error
+type HealthCheck func(ctx context.Context) error
+
+// namedCheck is a registered health check
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// healthChecks holds the checks run by /healthz and /readyz
+type healthChecks struct {
+	mutex     sync.RWMutex
+	health    []namedCheck
+	readiness []namedCheck
+}
+
+// HealthReport is the reply of /healthz and /readyz
+type HealthReport struct {
+	Status    string            `json:"status"`
+	Checks    map[string]string `json:"checks"`
+	Version   string            `json:"version"`
+	GoVersion string            `json:"go_version"`
+	Uptime    string            `json:"uptime"`
+}
+
+// AddHealthCheck registers a check run by both /healthz and /readyz, for
+// problems such as storage that cannot be written
+func (kvd *Kvd) AddHealthCheck(name string, check HealthCheck) {
+	kvd.checks.mutex.Lock()
+	defer kvd.checks.mutex.Unlock()
+	kvd.checks.health = append(kvd.checks.health, namedCheck{name, check})
+}
+
+// AddReadinessCheck registers a check run only by /readyz, for conditions
+// under which the server should get no traffic but need not be restarted,
+// such as a replica that has not caught up
+func (kvd *Kvd) AddReadinessCheck(name string, check HealthCheck) {
+	kvd.checks.mutex.Lock()
+	defer kvd.checks.mutex.Unlock()
+	kvd.checks.readiness = append(kvd.checks.readiness, namedCheck{name, check})
+}
+
+// addBuiltinChecks registers the checks for the configured features
+func (kvd *Kvd) addBuiltinChecks() {
+	if kvd.config.DataDir != "" {
+		kvd.AddHealthCheck("persistence", kvd.checkDataDir)
+	}
+	if kvd.config.SnapshotInterval > 0 {
+		kvd.AddHealthCheck("snapshot", kvd.checkSnapshotLag)
+	}
+	if kvd.config.MaxMemory > 0 {
+		kvd.AddReadinessCheck("memory", kvd.checkMemory)
+	}
+}
+
+// checkDataDir fails when a file cannot be written to the data directory
+func (kvd *Kvd) checkDataDir(ctx context.Context) error {
+	f, err := os.CreateTemp(kvd.config.DataDir, ".healthz-*")
+	if err != nil {
+		return fmt.Errorf("data directory is not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkSnapshotLag fails when the last snapshot failed or is more than two
+// intervals old
+func (kvd *Kvd) checkSnapshotLag(ctx context.Context) error {
+	saved, err := kvd.lastSave()
+	if err != nil {
+		return fmt.Errorf("last snapshot failed: %w", err)
+	}
+	if saved.IsZero() {
+		return nil
+	}
+	if lag := time.Since(saved); lag > 2*kvd.config.SnapshotInterval {
+		return fmt.Errorf("last snapshot was %s ago", lag.Round(time.Second))
+	}
+	return nil
+}
+
+// checkMemory fails when the heap is over the configured limit
+func (kvd *Kvd) checkMemory(ctx context.Context) error {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	if int64(stats.HeapAlloc) > kvd.config.MaxMemory {
+		return fmt.Errorf("heap is %d bytes, the limit is %d", stats.HeapAlloc, kvd.config.MaxMemory)
+	}
+	return nil
+}
+
+// runChecks runs checks into report, returning false if any failed
+func runChecks(ctx context.Context, checks []namedCheck, report *HealthReport) bool {
+	ok := true
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := c.check(checkCtx)
+		cancel()
+		if err != nil {
+			report.Checks[c.name] = err.Error()
+			ok = false
+			continue
+		}
+		report.Checks[c.name] = "ok"
+	}
+	return ok
+}
+
+// newHealthReport returns a report with the version and uptime filled in
+func (kvd *Kvd) newHealthReport() HealthReport {
+	return HealthReport{
+		Status:    "ok",
+		Checks:    map[string]string{},
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Uptime:    kvd.uptime(),
+	}
+}
+
+// uptime returns how long the server has run, to the second
+func (kvd *Kvd) uptime() string {
+	return time.Since(kvd.started).Round(time.Second).String()
+}
+
+// writeHealth replies with report, as 503 unless healthy
+func (kvd *Kvd) writeHealth(w http.ResponseWriter, report HealthReport, healthy bool) {
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	kvd.writeJSON(w, status, report)
+}
+
+// healthzHandler handles liveness probes, running the health checks
+func (kvd *Kvd) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	kvd.checks.mutex.RLock()
+	checks := kvd.checks.health
+	kvd.checks.mutex.RUnlock()
+
+	report := kvd.newHealthReport()
+	healthy := runChecks(r.Context(), checks, &report)
+	if !healthy {
+		report.Status = "fail"
+	}
+	kvd.writeHealth(w, report, healthy)
+}
+
+// readyzHandler handles readiness probes. The server is not ready while it
+// loads its data or drains at shutdown, or when any check fails.
+func (kvd *Kvd) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	kvd.checks.mutex.RLock()
+	checks := append(append([]namedCheck{}, kvd.checks.health...), kvd.checks.readiness...)
+	kvd.checks.mutex.RUnlock()
+
+	report := kvd.newHealthReport()
+	ready := runChecks(r.Context(), checks, &report)
+	if !ready {
+		report.Status = "fail"
+	}
+	if state := kvd.state.Load(); state != serverReady {
+		report.Status = serverStates[state]
+		ready = false
+	}
+	kvd.writeHealth(w, report, ready)
+}
+
+// rejectWhileLoading is router middleware replying 503 to requests other
+// than health checks until the saved data is loaded
+func (kvd *Kvd) rejectWhileLoading(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if kvd.state.Load() == serverLoading && !healthRoutes[routeTemplate(r)] {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Server is loading data", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
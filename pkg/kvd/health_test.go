@@ -0,0 +1,134 @@
+package kvd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHealthChecks(t *testing.T) {
+	kvd := newTestKvd(t)
+	router := mux.NewRouter()
+	router.Use(kvd.rejectWhileLoading)
+	router.HandleFunc("/healthz", kvd.healthzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", kvd.readyzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+
+	get := func(path string) (int, HealthReport) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var report HealthReport
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		return rec.Code, report
+	}
+
+	code, report := get("/healthz")
+	if code != http.StatusOK || report.Status != "ok" || report.Version != Version || report.GoVersion == "" {
+		t.Errorf("Expected a healthy report, got %d %+v", code, report)
+	}
+
+	// A failing readiness check leaves the server live but not ready
+	var lagging error = errors.New("replica is 30s behind")
+	kvd.AddReadinessCheck("replication", func(ctx context.Context) error { return lagging })
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to ignore readiness checks, got %d", code)
+	}
+	code, report = get("/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != "fail" || report.Checks["replication"] != lagging.Error() {
+		t.Errorf("Expected the replication check to fail, got %d %+v", code, report)
+	}
+	lagging = nil
+	if code, report = get("/readyz"); code != http.StatusOK || report.Checks["replication"] != "ok" {
+		t.Errorf("Expected ready, got %d %+v", code, report)
+	}
+
+	// A failing health check fails both
+	kvd.AddHealthCheck("disk", func(ctx context.Context) error { return errors.New("disk full") })
+	if code, _ := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /healthz to fail, got %d", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to fail, got %d", code)
+	}
+}
+
+func TestReadyzState(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.db.Set("a", "1")
+	router := mux.NewRouter()
+	router.Use(kvd.rejectWhileLoading)
+	router.HandleFunc("/healthz", kvd.healthzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", kvd.readyzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	for _, tc := range []struct {
+		state                   int32
+		name                    string
+		readyz, healthz, keyGet int
+	}{
+		{serverLoading, "loading", http.StatusServiceUnavailable, http.StatusOK, http.StatusServiceUnavailable},
+		{serverDraining, "draining", http.StatusServiceUnavailable, http.StatusOK, http.StatusOK},
+		{serverReady, "ok", http.StatusOK, http.StatusOK, http.StatusOK},
+	} {
+		kvd.state.Store(tc.state)
+		rec := get("/readyz")
+		var report HealthReport
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		if rec.Code != tc.readyz || report.Status != tc.name {
+			t.Errorf("%s: expected /readyz %d %q, got %d %q", tc.name, tc.readyz, tc.name, rec.Code, report.Status)
+		}
+		if rec := get("/healthz"); rec.Code != tc.healthz {
+			t.Errorf("%s: expected /healthz %d, got %d", tc.name, tc.healthz, rec.Code)
+		}
+		if rec := get("/v1/a"); rec.Code != tc.keyGet {
+			t.Errorf("%s: expected GET /v1/a %d, got %d", tc.name, tc.keyGet, rec.Code)
+		}
+	}
+}
+
+func TestPersistenceCheck(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	kvd := &Kvd{}
+	if err := kvd.Init(&Config{DataDir: dir, SnapshotInterval: time.Second, LogLevel: "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvd.loadDataDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	kvd.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var report HealthReport
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusOK || report.Checks["persistence"] != "ok" || report.Checks["snapshot"] != "ok" {
+		t.Errorf("Expected the persistence checks to pass, got %d %+v", rec.Code, report)
+	}
+
+	// A data directory that is gone fails the checks
+	os.RemoveAll(dir)
+	if err := kvd.saveDataDir(); err == nil {
+		t.Fatal("Expected the save to fail")
+	}
+	rec = httptest.NewRecorder()
+	kvd.healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	report = HealthReport{}
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	if rec.Code != http.StatusServiceUnavailable || report.Checks["persistence"] == "ok" || report.Checks["snapshot"] == "ok" {
+		t.Errorf("Expected the persistence checks to fail, got %d %+v", rec.Code, report)
+	}
+}
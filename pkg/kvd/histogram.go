@@ -0,0 +1,153 @@
+package kvd
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// histogramSubBuckets is the number of buckets per doubling, which bounds
+// the relative error of a quantile to about 19%
+const histogramSubBuckets = 4
+
+// Histogram counts observations in log-linear buckets. It is safe for
+// concurrent use and never allocates when observing.
+type Histogram struct {
+	bounds []int64
+	counts []int64
+	count  int64
+	sum    int64
+	max    int64
+}
+
+// HistogramBucket is the number of observations at or below Le and above
+// the previous bucket's Le
+type HistogramBucket struct {
+	Le    int64 `json:"Le"`
+	Count int64 `json:"Count"`
+}
+
+// HistogramSnapshot is a point-in-time copy of a histogram. Only non-empty
+// buckets are included.
+type HistogramSnapshot struct {
+	Count   int64             `json:"Count"`
+	Sum     int64             `json:"Sum"`
+	Max     int64             `json:"Max"`
+	Buckets []HistogramBucket `json:"Buckets,omitempty"`
+}
+
+// newHistogram creates a histogram with bucket bounds from min up to at
+// least max. Larger observations land in a final unbounded bucket.
+func newHistogram(min, max int64) *Histogram {
+	var bounds []int64
+	for base := min; base < max; base *= 2 {
+		for i := int64(0); i < histogramSubBuckets; i++ {
+			bound := base + base*i/histogramSubBuckets
+			if len(bounds) == 0 || bound > bounds[len(bounds)-1] {
+				bounds = append(bounds, bound)
+			}
+		}
+	}
+	bounds = append(bounds, max, math.MaxInt64)
+
+	return &Histogram{bounds: bounds, counts: make([]int64, len(bounds))}
+}
+
+// Observe records a value
+func (h *Histogram) Observe(v int64) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return h.bounds[i] >= v })
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, v)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			return
+		}
+	}
+}
+
+// ObserveSince records the time elapsed since start in nanoseconds
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(int64(time.Since(start)))
+}
+
+// Snapshot copies the histogram's current state
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count: atomic.LoadInt64(&h.count),
+		Sum:   atomic.LoadInt64(&h.sum),
+		Max:   atomic.LoadInt64(&h.max),
+	}
+	for i, bound := range h.bounds {
+		if count := atomic.LoadInt64(&h.counts[i]); count > 0 {
+			s.Buckets = append(s.Buckets, HistogramBucket{Le: bound, Count: count})
+		}
+	}
+	return s
+}
+
+// Mean returns the average observation
+func (s HistogramSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Sum) / float64(s.Count)
+}
+
+// Quantile returns an upper estimate of the q-th quantile, 0 <= q <= 1
+func (s HistogramSnapshot) Quantile(q float64) int64 {
+	var total int64
+	for _, b := range s.Buckets {
+		total += b.Count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(total)))
+	var seen int64
+	for _, b := range s.Buckets {
+		seen += b.Count
+		if seen >= rank {
+			if b.Le > s.Max {
+				return s.Max
+			}
+			return b.Le
+		}
+	}
+	return s.Max
+}
+
+// Operations with latency histograms
+const (
+	OpGet        = "get"
+	OpSet        = "set"
+	OpDelete     = "delete"
+	OpBulkGet    = "bulk_get"
+	OpBulkSet    = "bulk_set"
+	OpBulkDelete = "bulk_delete"
+)
+
+// dbHistograms holds the database's latency and size distributions. The
+// latency map is filled once and only read afterwards.
+type dbHistograms struct {
+	latency   map[string]*Histogram
+	valueSize *Histogram
+	batchSize *Histogram
+}
+
+// newDBHistograms creates latency histograms from 1µs to 16s, value sizes
+// from 1 byte to 1GiB and batch sizes from 1 to 1M
+func newDBHistograms() *dbHistograms {
+	h := &dbHistograms{
+		latency:   make(map[string]*Histogram),
+		valueSize: newHistogram(1, 1<<30),
+		batchSize: newHistogram(1, 1<<20),
+	}
+	for _, op := range []string{OpGet, OpSet, OpDelete, OpBulkGet, OpBulkSet, OpBulkDelete} {
+		h.latency[op] = newHistogram(int64(time.Microsecond), int64(16*time.Second))
+	}
+	return h
+}
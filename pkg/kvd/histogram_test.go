@@ -0,0 +1,54 @@
+package kvd
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(1, 1<<20)
+	for v := int64(1); v <= 1000; v++ {
+		h.Observe(v)
+	}
+	h.Observe(1 << 40)
+
+	s := h.Snapshot()
+	if s.Count != 1001 || s.Max != 1<<40 {
+		t.Fatalf("Unexpected count %d and max %d", s.Count, s.Max)
+	}
+	if last := s.Buckets[len(s.Buckets)-1]; last.Le != math.MaxInt64 || last.Count != 1 {
+		t.Errorf("Expected the outlier in the unbounded bucket, got %+v", last)
+	}
+
+	// Quantiles are upper estimates within one sub-bucket
+	for _, tc := range []struct {
+		q    float64
+		want int64
+	}{{0.5, 500}, {0.9, 900}, {0.99, 990}} {
+		got := s.Quantile(tc.q)
+		if got < tc.want || float64(got) > float64(tc.want)*1.25 {
+			t.Errorf("Quantile(%v) = %d, expected about %d", tc.q, got, tc.want)
+		}
+	}
+	if got := s.Quantile(1); got != 1<<40 {
+		t.Errorf("Expected the max as the 100th percentile, got %d", got)
+	}
+}
+
+func TestDBHistograms(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("a", "12345")
+	db.Get("a")
+	db.BulkSet([]Record{{Key: "b", Value: "1"}, {Key: "c", Value: "2"}, {Key: "d", Value: "3"}})
+
+	metrics := db.Metrics()
+	if metrics.Latency[OpGet].Count != 1 || metrics.Latency[OpSet].Count != 1 || metrics.Latency[OpBulkSet].Count != 1 {
+		t.Errorf("Unexpected latency counts %+v", metrics.Latency)
+	}
+	if metrics.ValueSize.Count != 4 || metrics.ValueSize.Max != 5 {
+		t.Errorf("Unexpected value sizes %+v", metrics.ValueSize)
+	}
+	if metrics.BatchSize.Count != 1 || metrics.BatchSize.Max != 3 {
+		t.Errorf("Unexpected batch sizes %+v", metrics.BatchSize)
+	}
+}
@@ -0,0 +1,227 @@
+package kvd
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HotKey is an estimate of how often a key was accessed. The true count is
+// between Count-Error and Count.
+type HotKey struct {
+	Key   string `json:"Key"`
+	Count int64  `json:"Count"`
+	Error int64  `json:"Error"`
+}
+
+// KeySize is the size of a key's value in bytes
+type KeySize struct {
+	Key  string `json:"Key"`
+	Size int64  `json:"Size"`
+}
+
+// TopReport lists the most accessed keys and the largest values
+type TopReport struct {
+	Tracking bool      `json:"Tracking"`
+	Reads    []HotKey  `json:"Reads"`
+	Writes   []HotKey  `json:"Writes"`
+	Biggest  []KeySize `json:"Biggest,omitempty"`
+}
+
+// hotKeys tracks the most read and written keys
+type hotKeys struct {
+	reads  *topK
+	writes *topK
+}
+
+// topK finds the most frequent keys in a stream using the space-saving
+// algorithm: it keeps capacity counters and, when a new key arrives while
+// full, hands it the smallest counter. A nil topK records nothing.
+type topK struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*topKEntry
+	heap     topKHeap
+}
+
+type topKEntry struct {
+	key   string
+	count int64
+	err   int64
+	index int
+}
+
+// topKHeap is a min-heap of entries by count
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *topKHeap) Push(x any) {
+	e := x.(*topKEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *topKHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newTopK(capacity int) *topK {
+	return &topK{capacity: capacity, entries: make(map[string]*topKEntry, capacity)}
+}
+
+// add counts one access to key
+func (t *topK) add(key string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e, ok := t.entries[key]; ok {
+		e.count++
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+
+	if len(t.heap) < t.capacity {
+		e := &topKEntry{key: key, count: 1}
+		t.entries[key] = e
+		heap.Push(&t.heap, e)
+		return
+	}
+
+	// Replace the least counted key, which may have been undercounted by
+	// as much as its count
+	e := t.heap[0]
+	delete(t.entries, e.key)
+	e.key = key
+	e.err = e.count
+	e.count++
+	t.entries[key] = e
+	heap.Fix(&t.heap, 0)
+}
+
+// top returns up to n keys with the highest counts
+func (t *topK) top(n int) []HotKey {
+	if t == nil {
+		return []HotKey{}
+	}
+
+	t.mu.Lock()
+	keys := make([]HotKey, 0, len(t.heap))
+	for _, e := range t.heap {
+		keys = append(keys, HotKey{Key: e.key, Count: e.count, Error: e.err})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// EnableHotKeys starts tracking the most read and written keys, keeping
+// counters for capacity candidates each. Larger capacities give more
+// accurate counts. Zero stops tracking.
+func (db *DB) EnableHotKeys(capacity int) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if capacity <= 0 {
+		db.hotKeys = nil
+		return
+	}
+	db.hotKeys = &hotKeys{reads: newTopK(capacity), writes: newTopK(capacity)}
+}
+
+// recordReadLocked counts a read of key. The caller must hold at least the
+// read lock.
+func (db *DB) recordReadLocked(key string) {
+	if db.hotKeys != nil {
+		db.hotKeys.reads.add(key)
+	}
+}
+
+// recordWriteLocked counts a write of key. The caller must hold at least the
+// read lock.
+func (db *DB) recordWriteLocked(key string) {
+	if db.hotKeys != nil {
+		db.hotKeys.writes.add(key)
+	}
+}
+
+// HotKeys returns up to n of the most read and most written keys
+func (db *DB) HotKeys(n int) TopReport {
+	db.mutex.RLock()
+	tracker := db.hotKeys
+	db.mutex.RUnlock()
+
+	if tracker == nil {
+		return TopReport{Reads: []HotKey{}, Writes: []HotKey{}}
+	}
+	return TopReport{Tracking: true, Reads: tracker.reads.top(n), Writes: tracker.writes.top(n)}
+}
+
+// BiggestValues returns up to n keys with the largest values, largest
+// first. It walks every key under the read lock.
+func (db *DB) BiggestValues(n int) []KeySize {
+	if n <= 0 {
+		return []KeySize{}
+	}
+
+	db.mutex.RLock()
+	now := time.Now()
+	sizes := keySizeHeap{}
+	for key, value := range db.store {
+		if expiry, ok := db.expires[key]; ok && !now.Before(expiry) {
+			continue
+		}
+		size := int64(len(value))
+		if len(sizes) < n {
+			heap.Push(&sizes, KeySize{Key: key, Size: size})
+		} else if size > sizes[0].Size {
+			sizes[0] = KeySize{Key: key, Size: size}
+			heap.Fix(&sizes, 0)
+		}
+	}
+	db.mutex.RUnlock()
+
+	biggest := []KeySize(sizes)
+	sort.Slice(biggest, func(i, j int) bool {
+		if biggest[i].Size != biggest[j].Size {
+			return biggest[i].Size > biggest[j].Size
+		}
+		return biggest[i].Key < biggest[j].Key
+	})
+	return biggest
+}
+
+// keySizeHeap is a min-heap of key sizes
+type keySizeHeap []KeySize
+
+func (h keySizeHeap) Len() int           { return len(h) }
+func (h keySizeHeap) Less(i, j int) bool { return h[i].Size < h[j].Size }
+func (h keySizeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *keySizeHeap) Push(x any)        { *h = append(*h, x.(KeySize)) }
+func (h *keySizeHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,46 @@
+package kvd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Limits for the top report
+const (
+	DefaultTopLimit = 10
+	maxTopLimit     = 1000
+)
+
+// topHandler reports the most read and written keys and the largest
+// values. The largest values are found by walking every key, which
+// biggest=false skips.
+func (kvd *Kvd) topHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := DefaultTopLimit
+	if param := query.Get("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 || n > maxTopLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTopLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	biggest := true
+	if param := query.Get("biggest"); param != "" {
+		b, err := strconv.ParseBool(param)
+		if err != nil {
+			http.Error(w, "biggest must be true or false", http.StatusBadRequest)
+			return
+		}
+		biggest = b
+	}
+
+	report := kvd.db.HotKeys(limit)
+	if biggest {
+		report.Biggest = kvd.db.BiggestValues(limit)
+	}
+	kvd.writeJSON(w, http.StatusOK, report)
+}
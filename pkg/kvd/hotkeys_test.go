@@ -0,0 +1,89 @@
+package kvd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	tracker := newTopK(10)
+
+	// Three hot keys among many keys seen once each
+	for i := 0; i < 1000; i++ {
+		tracker.add("hot1")
+		if i%2 == 0 {
+			tracker.add("hot2")
+		}
+		if i%4 == 0 {
+			tracker.add("hot3")
+		}
+		tracker.add(fmt.Sprintf("cold%d", i))
+	}
+
+	top := tracker.top(3)
+	if len(top) != 3 {
+		t.Fatalf("Expected 3 keys, got %+v", top)
+	}
+	for i, want := range []struct {
+		key   string
+		count int64
+	}{{"hot1", 1000}, {"hot2", 500}, {"hot3", 250}} {
+		got := top[i]
+		if got.Key != want.key || got.Count-got.Error > want.count || got.Count < want.count {
+			t.Errorf("Expected %s with count %d, got %+v", want.key, want.count, got)
+		}
+	}
+}
+
+func TestHotKeysAndBiggest(t *testing.T) {
+	kvd := newTestKvd(t)
+
+	report := kvd.db.HotKeys(10)
+	if report.Tracking || len(report.Reads) != 0 {
+		t.Errorf("Expected tracking off by default, got %+v", report)
+	}
+
+	kvd.db.EnableHotKeys(100)
+	kvd.db.Set("small", "1")
+	kvd.db.Set("big", strings.Repeat("x", 100))
+	kvd.db.Set("big", strings.Repeat("x", 200))
+	kvd.db.Get("small")
+	kvd.db.Get("small")
+	kvd.db.BulkGet([]string{"big", "small"})
+
+	report = kvd.db.HotKeys(10)
+	if len(report.Reads) != 2 || report.Reads[0].Key != "small" || report.Reads[0].Count != 3 {
+		t.Errorf("Expected small read 3 times, got %+v", report.Reads)
+	}
+	if len(report.Writes) != 2 || report.Writes[0].Key != "big" || report.Writes[0].Count != 2 {
+		t.Errorf("Expected big written twice, got %+v", report.Writes)
+	}
+
+	rec := httptest.NewRecorder()
+	kvd.topHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/top?limit=1", nil))
+	var got TopReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode %q: %v", rec.Body, err)
+	}
+	if len(got.Reads) != 1 || len(got.Biggest) != 1 || got.Biggest[0] != (KeySize{Key: "big", Size: 200}) {
+		t.Errorf("Unexpected report %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.topHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/top?biggest=false", nil))
+	got = TopReport{}
+	json.Unmarshal(rec.Body.Bytes(), &got)
+	if got.Biggest != nil {
+		t.Errorf("Expected no biggest values, got %+v", got.Biggest)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.topHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/top?limit=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for limit=0, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,340 @@
+package kvd
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Index errors
+var (
+	ErrIndexNotFound    = errors.New("index not found")
+	ErrIndexExists      = errors.New("index already exists")
+	ErrInvalidIndexName = errors.New("invalid index name")
+	ErrInvalidIndexPath = errors.New("invalid index path")
+)
+
+// IndexSpec declares a secondary index over the JSON values of every key
+// starting with Prefix. Path is a dotted path into the value such as
+// "email" or "address.city"; numeric segments index into arrays.
+type IndexSpec struct {
+	Name   string `json:"Name"`
+	Prefix string `json:"Prefix"`
+	Path   string `json:"Path"`
+}
+
+// IndexQuery selects index entries either by exact Value or by the
+// inclusive range Min to Max, where an empty bound is unbounded. Numeric
+// terms sort numerically and before all other terms.
+type IndexQuery struct {
+	Value string
+	Min   string
+	Max   string
+	Limit int
+}
+
+// indexTerm is an indexed value with its sort key
+type indexTerm struct {
+	text    string
+	numeric bool
+	number  float64
+}
+
+// secondaryIndex maps indexed terms to the keys holding them
+type secondaryIndex struct {
+	spec  IndexSpec
+	path  []string
+	terms []indexTerm
+	keys  map[string]map[string]struct{}
+	byKey map[string]string
+}
+
+// newTerm builds a term, treating anything that parses as a number as one
+func newTerm(text string) indexTerm {
+	n, err := strconv.ParseFloat(text, 64)
+	numeric := err == nil && !math.IsNaN(n) && !math.IsInf(n, 0)
+	return indexTerm{text: text, numeric: numeric, number: n}
+}
+
+// less orders numeric terms before text terms, numbers by value and text
+// lexically
+func (t indexTerm) less(o indexTerm) bool {
+	if t.numeric != o.numeric {
+		return t.numeric
+	}
+	if t.numeric && t.number != o.number {
+		return t.number < o.number
+	}
+	return t.text < o.text
+}
+
+// parseIndexPath splits a dotted JSON path, accepting an optional "$." prefix
+func parseIndexPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, ErrInvalidIndexPath
+	}
+
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, ErrInvalidIndexPath
+		}
+	}
+	return segments, nil
+}
+
+// extract returns the term for a value, or false if the value is not JSON
+// or has no scalar at the index path
+func (idx *secondaryIndex) extract(value string) (string, bool) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(value), &doc); err != nil {
+		return "", false
+	}
+
+	for _, segment := range idx.path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			doc = node[segment]
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			doc = node[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// search returns the position of the first term not less than t
+func (idx *secondaryIndex) search(t indexTerm) int {
+	return sort.Search(len(idx.terms), func(i int) bool {
+		return !idx.terms[i].less(t)
+	})
+}
+
+// add records that key holds term
+func (idx *secondaryIndex) add(key, text string) {
+	keys, ok := idx.keys[text]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.keys[text] = keys
+
+		t := newTerm(text)
+		i := idx.search(t)
+		idx.terms = append(idx.terms, indexTerm{})
+		copy(idx.terms[i+1:], idx.terms[i:])
+		idx.terms[i] = t
+	}
+	keys[key] = struct{}{}
+	idx.byKey[key] = text
+}
+
+// remove drops whatever term key is indexed under
+func (idx *secondaryIndex) remove(key string) {
+	text, ok := idx.byKey[key]
+	if !ok {
+		return
+	}
+	delete(idx.byKey, key)
+
+	keys := idx.keys[text]
+	delete(keys, key)
+	if len(keys) > 0 {
+		return
+	}
+
+	delete(idx.keys, text)
+	t := newTerm(text)
+	i := idx.search(t)
+	if i < len(idx.terms) && idx.terms[i].text == text {
+		idx.terms = append(idx.terms[:i], idx.terms[i+1:]...)
+	}
+}
+
+// update re-indexes a key after its value changed
+func (idx *secondaryIndex) update(key, value string) {
+	if !strings.HasPrefix(key, idx.spec.Prefix) {
+		return
+	}
+	idx.remove(key)
+	if text, ok := idx.extract(value); ok {
+		idx.add(key, text)
+	}
+}
+
+// query returns the matching keys for which live is true, ordered by term
+// and then key. Keys that are not live do not count towards the limit.
+func (idx *secondaryIndex) query(q IndexQuery, live func(key string) bool) []string {
+	var terms []indexTerm
+	if q.Value != "" {
+		if _, ok := idx.keys[q.Value]; ok {
+			terms = []indexTerm{newTerm(q.Value)}
+		}
+	} else {
+		start, end := 0, len(idx.terms)
+		if q.Min != "" {
+			start = idx.search(newTerm(q.Min))
+		}
+		if q.Max != "" {
+			max := newTerm(q.Max)
+			end = sort.Search(len(idx.terms), func(i int) bool {
+				return max.less(idx.terms[i])
+			})
+		}
+		if start < end {
+			terms = idx.terms[start:end]
+		}
+	}
+
+	result := []string{}
+	for _, t := range terms {
+		keys := make([]string, 0, len(idx.keys[t.text]))
+		for key := range idx.keys[t.text] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if q.Limit > 0 && len(result) == q.Limit {
+				return result
+			}
+			if live(key) {
+				result = append(result, key)
+			}
+		}
+	}
+	return result
+}
+
+// CreateIndex declares a secondary index and builds it from existing data
+func (db *DB) CreateIndex(spec IndexSpec) error {
+	if spec.Name == "" || strings.ContainsRune(spec.Name, '/') {
+		return ErrInvalidIndexName
+	}
+	path, err := parseIndexPath(spec.Path)
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.indexes[spec.Name]; ok {
+		return ErrIndexExists
+	}
+
+	idx := &secondaryIndex{
+		spec:  spec,
+		path:  path,
+		keys:  make(map[string]map[string]struct{}),
+		byKey: make(map[string]string),
+	}
+	for key, value := range db.store {
+		idx.update(key, value)
+	}
+	db.indexes[spec.Name] = idx
+
+	return nil
+}
+
+// DropIndex removes a secondary index
+func (db *DB) DropIndex(name string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.indexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(db.indexes, name)
+
+	return nil
+}
+
+// Indexes lists the declared secondary indexes by name
+func (db *DB) Indexes() []IndexSpec {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	specs := make([]IndexSpec, 0, len(db.indexes))
+	for _, idx := range db.indexes {
+		specs = append(specs, idx.spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+
+	return specs
+}
+
+// QueryIndex returns the keys matching an index query
+func (db *DB) QueryIndex(name string, q IndexQuery) ([]string, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	idx, ok := db.indexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	return idx.query(q, db.liveLocked), nil
+}
+
+// QueryIndexRecords returns the records matching an index query
+func (db *DB) QueryIndexRecords(name string, q IndexQuery) ([]Record, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	idx, ok := db.indexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	keys := idx.query(q, db.liveLocked)
+	records := make([]Record, 0, len(keys))
+	for _, key := range keys {
+		if value, ok := db.lookupLocked(key); ok {
+			records = append(records, Record{Key: key, Value: value})
+		}
+	}
+	atomic.AddInt64(&db.metrics.GetOps, int64(len(records)))
+
+	return records, nil
+}
+
+// liveLocked reports whether key exists and has not expired. The caller
+// must hold at least the read lock.
+func (db *DB) liveLocked(key string) bool {
+	_, ok := db.lookupLocked(key)
+	return ok
+}
+
+// indexPutLocked updates every index after a key is stored. The caller
+// must hold the write lock.
+func (db *DB) indexPutLocked(key, value string) {
+	for _, idx := range db.indexes {
+		idx.update(key, value)
+	}
+}
+
+// indexRemoveLocked drops a deleted key from every index. The caller must
+// hold the write lock.
+func (db *DB) indexRemoveLocked(key string) {
+	for _, idx := range db.indexes {
+		idx.remove(key)
+	}
+}
@@ -0,0 +1,102 @@
+package kvd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// indexErrorStatus maps index errors to HTTP status codes
+func indexErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrIndexNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrIndexExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidIndexName), errors.Is(err, ErrInvalidIndexPath):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// indexListHandler handles requests to list the declared indexes
+func (kvd *Kvd) indexListHandler(w http.ResponseWriter, r *http.Request) {
+	kvd.writeJSON(w, http.StatusOK, kvd.db.Indexes())
+}
+
+// indexCreateHandler handles requests to declare an index. The body holds
+// the key prefix and JSON path to index.
+func (kvd *Kvd) indexCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var spec IndexSpec
+
+	body, ok := kvd.readBody(w, r, kvd.settings().limits.maxBodySize, ErrBodyTooLarge)
+	if !ok {
+		return
+	}
+
+	if err := json.Unmarshal(body, &spec); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	spec.Name = mux.Vars(r)["name"]
+
+	if err := kvd.db.CreateIndex(spec); err != nil {
+		http.Error(w, err.Error(), indexErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusCreated, spec)
+}
+
+// indexDropHandler handles requests to remove an index
+func (kvd *Kvd) indexDropHandler(w http.ResponseWriter, r *http.Request) {
+	if err := kvd.db.DropIndex(mux.Vars(r)["name"]); err != nil {
+		http.Error(w, err.Error(), indexErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// indexQueryHandler handles index lookups. The query string selects either
+// an exact value or a min/max range; with records=true the matching
+// records are returned instead of just their keys.
+func (kvd *Kvd) indexQueryHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	params := r.URL.Query()
+
+	q := IndexQuery{
+		Value: params.Get("value"),
+		Min:   params.Get("min"),
+		Max:   params.Get("max"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	if params.Get("records") == "true" {
+		records, err := kvd.db.QueryIndexRecords(name, q)
+		if err != nil {
+			http.Error(w, err.Error(), indexErrorStatus(err))
+			return
+		}
+		kvd.writeJSON(w, http.StatusOK, records)
+		return
+	}
+
+	keys, err := kvd.db.QueryIndex(name, q)
+	if err != nil {
+		http.Error(w, err.Error(), indexErrorStatus(err))
+		return
+	}
+	kvd.writeJSON(w, http.StatusOK, keys)
+}
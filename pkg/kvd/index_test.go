@@ -0,0 +1,78 @@
+package kvd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndexMaintainedOnWrites(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("user:1", `{"email":"a@example.com","age":30}`)
+	db.Set("order:1", `{"email":"a@example.com"}`)
+
+	if err := db.CreateIndex(IndexSpec{Name: "email", Prefix: "user:", Path: "email"}); err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	db.BulkSet([]Record{
+		{Key: "user:2", Value: `{"email":"b@example.com"}`},
+		{Key: "user:3", Value: `not json`},
+	})
+
+	keys, _ := db.QueryIndex("email", IndexQuery{Value: "a@example.com"})
+	if !reflect.DeepEqual(keys, []string{"user:1"}) {
+		t.Errorf("Expected [user:1], got %v", keys)
+	}
+
+	// Overwriting moves the key to its new term
+	db.Set("user:1", `{"email":"c@example.com"}`)
+	keys, _ = db.QueryIndex("email", IndexQuery{Value: "a@example.com"})
+	if len(keys) != 0 {
+		t.Errorf("Expected no keys for old email, got %v", keys)
+	}
+
+	db.Delete("user:2")
+	records, _ := db.QueryIndexRecords("email", IndexQuery{})
+	if len(records) != 1 || records[0].Key != "user:1" {
+		t.Errorf("Expected only user:1 to remain indexed, got %v", records)
+	}
+}
+
+func TestIndexRangeQuery(t *testing.T) {
+	db := newTestDB(t)
+	db.CreateIndex(IndexSpec{Name: "age", Prefix: "user:", Path: "$.profile.age"})
+
+	for key, age := range map[string]string{"user:a": "9", "user:b": "10", "user:c": "25", "user:d": "40"} {
+		db.Set(key, `{"profile":{"age":`+age+`}}`)
+	}
+
+	keys, _ := db.QueryIndex("age", IndexQuery{Min: "10", Max: "30"})
+	if !reflect.DeepEqual(keys, []string{"user:b", "user:c"}) {
+		t.Errorf("Expected numeric range to return [user:b user:c], got %v", keys)
+	}
+
+	keys, _ = db.QueryIndex("age", IndexQuery{Min: "10", Limit: 1})
+	if !reflect.DeepEqual(keys, []string{"user:b"}) {
+		t.Errorf("Expected limited query to return [user:b], got %v", keys)
+	}
+
+	// Expired keys do not use up the limit
+	db.Expire("user:b", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	keys, _ = db.QueryIndex("age", IndexQuery{Min: "10", Limit: 2})
+	if !reflect.DeepEqual(keys, []string{"user:c", "user:d"}) {
+		t.Errorf("Expected a full page of live keys [user:c user:d], got %v", keys)
+	}
+	records, _ := db.QueryIndexRecords("age", IndexQuery{Min: "10", Limit: 1})
+	if len(records) != 1 || records[0].Key != "user:c" {
+		t.Errorf("Expected the record of user:c, got %v", records)
+	}
+
+	if _, err := db.QueryIndex("missing", IndexQuery{}); !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("Expected ErrIndexNotFound, got %v", err)
+	}
+	if err := db.CreateIndex(IndexSpec{Name: "age", Path: "x"}); !errors.Is(err, ErrIndexExists) {
+		t.Errorf("Expected ErrIndexExists, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package kvd
+
+import "sort"
+
+// keyOrderChunk is the number of keys a keyOrder chunk is split back to
+// once it grows to twice this size
+const keyOrderChunk = 512
+
+// keyOrder keeps the keys of the store in sorted order. Keys are held in
+// sorted chunks, so adding or removing one only moves the keys of its
+// chunk, and a scan can start at any position without sorting the
+// keyspace.
+type keyOrder struct {
+	chunks [][]string
+	len    int
+}
+
+// chunkFor returns the index of the chunk that holds key, or would
+func (o *keyOrder) chunkFor(key string) int {
+	i := sort.Search(len(o.chunks), func(i int) bool {
+		c := o.chunks[i]
+		return c[len(c)-1] >= key
+	})
+	if i == len(o.chunks) {
+		i--
+	}
+	return i
+}
+
+// insert adds key if it is not already present
+func (o *keyOrder) insert(key string) {
+	if len(o.chunks) == 0 {
+		o.chunks = [][]string{{key}}
+		o.len = 1
+		return
+	}
+
+	i := o.chunkFor(key)
+	c := o.chunks[i]
+	j := sort.SearchStrings(c, key)
+	if j < len(c) && c[j] == key {
+		return
+	}
+	c = append(c, "")
+	copy(c[j+1:], c[j:])
+	c[j] = key
+	o.chunks[i] = c
+	o.len++
+
+	if len(c) >= 2*keyOrderChunk {
+		tail := append([]string(nil), c[keyOrderChunk:]...)
+		o.chunks = append(o.chunks, nil)
+		copy(o.chunks[i+2:], o.chunks[i+1:])
+		o.chunks[i] = c[:keyOrderChunk:keyOrderChunk]
+		o.chunks[i+1] = tail
+	}
+}
+
+// remove drops key if it is present
+func (o *keyOrder) remove(key string) {
+	if len(o.chunks) == 0 {
+		return
+	}
+
+	i := o.chunkFor(key)
+	c := o.chunks[i]
+	j := sort.SearchStrings(c, key)
+	if j == len(c) || c[j] != key {
+		return
+	}
+	copy(c[j:], c[j+1:])
+	c[len(c)-1] = ""
+	c = c[:len(c)-1]
+	o.len--
+
+	if len(c) == 0 {
+		o.chunks = append(o.chunks[:i], o.chunks[i+1:]...)
+		return
+	}
+	o.chunks[i] = c
+}
+
+// each calls fn with the keys in order from position start, until fn
+// returns false
+func (o *keyOrder) each(start int, fn func(key string) bool) {
+	i := 0
+	for ; i < len(o.chunks) && start >= len(o.chunks[i]); i++ {
+		start -= len(o.chunks[i])
+	}
+	for ; i < len(o.chunks); i++ {
+		for _, key := range o.chunks[i][start:] {
+			if !fn(key) {
+				return
+			}
+		}
+		start = 0
+	}
+}
@@ -0,0 +1,98 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultReservedPrefix is the key prefix kept for kvd's own use
+const DefaultReservedPrefix = "_kvd/"
+
+// ErrReservedKey is returned for writes to keys under a reserved prefix
+var ErrReservedKey = errors.New("key is reserved")
+
+// ValidateKey checks a key against the key grammar. A key is a non-empty
+// string of valid UTF-8 without control characters (U+0000 to U+001F and
+// U+007F to U+009F). Anything else is allowed, including '/', spaces and
+// non-ASCII text; the HTTP API takes keys URL-escaped. The maximum length
+// is set per server by Config.MaxKeyLength.
+func ValidateKey(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidKey)
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: control character %U", ErrInvalidKey, r)
+		}
+	}
+	return nil
+}
+
+// SetReservedPrefixes keeps keys starting with any of prefixes from being
+// written or deleted through the database's API. It must be called before
+// the database is used.
+func (db *DB) SetReservedPrefixes(prefixes []string) {
+	db.reserved = prefixes
+}
+
+// checkWriteKey validates a key about to be written or deleted
+func (db *DB) checkWriteKey(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	for _, prefix := range db.reserved {
+		if strings.HasPrefix(key, prefix) {
+			return fmt.Errorf("%w: prefix %q is for internal use", ErrReservedKey, prefix)
+		}
+	}
+	return nil
+}
+
+// keyErrorStatus maps a key operation error to an HTTP status code
+func keyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrEmptyKey), errors.Is(err, ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrReservedKey):
+		return http.StatusForbidden
+	case errors.Is(err, ErrStoreFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// decodeVars is router middleware unescaping the route variables. The
+// router matches the escaped path so that keys may contain '/'.
+func decodeVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if len(vars) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decoded := make(map[string]string, len(vars))
+		for name, value := range vars {
+			unescaped, err := url.PathUnescape(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid escaping in %s", name), http.StatusBadRequest)
+				return
+			}
+			decoded[name] = unescaped
+		}
+		next.ServeHTTP(w, mux.SetURLVars(r, decoded))
+	})
+}
@@ -0,0 +1,91 @@
+package kvd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestValidateKey(t *testing.T) {
+	for _, key := range []string{"a", "a/b", "with space", "ünïcødé", "_mget", ".", strings.Repeat("k", 5000)} {
+		if err := ValidateKey(key); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", key, err)
+		}
+	}
+	if err := ValidateKey(""); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("Expected ErrEmptyKey, got %v", err)
+	}
+	for _, key := range []string{"a\x00b", "line\n", "tab\t", "del\x7f", "c1\u0085", "bad\xff"} {
+		if err := ValidateKey(key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Expected ErrInvalidKey for %q, got %v", key, err)
+		}
+	}
+}
+
+func TestReservedPrefixes(t *testing.T) {
+	db := newTestDB(t)
+	db.SetReservedPrefixes([]string{DefaultReservedPrefix})
+
+	if err := db.Set("_kvd/x", "1"); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey, got %v", err)
+	}
+	if err := db.BulkSet([]Record{{Key: "a", Value: "1"}, {Key: "_kvd/x", Value: "1"}}); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from a bulk set, got %v", err)
+	}
+	if _, err := db.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the bulk set to write nothing, got %v", err)
+	}
+	if _, err := db.Incr("_kvd/n", 1); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey from incr, got %v", err)
+	}
+	if err := db.Set("_kvdx", "1"); err != nil {
+		t.Errorf("Expected keys outside the prefix to be writable, got %v", err)
+	}
+}
+
+func TestEscapedKeyRoutes(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.db.SetReservedPrefixes([]string{DefaultReservedPrefix})
+
+	router := mux.NewRouter().UseEncodedPath()
+	router.Use(decodeVars)
+	router.HandleFunc("/v1/_mget", kvd.mgetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/{key}", kvd.keyPutHandler).Methods(http.MethodPut)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	for path, key := range map[string]string{
+		"/v1/a%2Fb":     "a/b",
+		"/v1/%5Fmget":   "_mget",
+		"/v1/%2E%2E":    "..",
+		"/v1/a%20b%3F":  "a b?",
+		"/v1/%C3%BCber": "über",
+	} {
+		if rec := do(http.MethodPut, path, key); rec.Code != http.StatusCreated {
+			t.Errorf("PUT %s: expected 201, got %d: %s", path, rec.Code, rec.Body)
+			continue
+		}
+		if value, err := kvd.db.Get(key); err != nil || value != key {
+			t.Errorf("Expected %q to be stored, got %q %v", key, value, err)
+		}
+		if rec := do(http.MethodGet, path, ""); rec.Body.String() != key {
+			t.Errorf("GET %s: expected %q, got %q", path, key, rec.Body)
+		}
+	}
+
+	if rec := do(http.MethodPut, "/v1/a%01b", "x"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a control character, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/v1/%5Fkvd%2Fx", "x"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a reserved key, got %d", rec.Code)
+	}
+}
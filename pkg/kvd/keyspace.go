@@ -0,0 +1,500 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Keyspace errors
+var (
+	ErrNotInteger      = errors.New("value is not an integer or out of range")
+	ErrVersionMismatch = errors.New("key was modified since it was read")
+)
+
+// KeyMeta is the metadata kept alongside a key's value. Version changes on
+// every write to the key, so it can be used for compare-and-set.
+type KeyMeta struct {
+	Flags   uint32 `json:"Flags"`
+	Version uint64 `json:"Version"`
+}
+
+// SetOptions modifies how SetWithOptions stores a value
+type SetOptions struct {
+	// TTL expires the key after the given duration when positive
+	TTL time.Duration
+	// KeepTTL retains the key's existing expiry instead of clearing it
+	KeepTTL bool
+	// OnlyIfAbsent stores the value only if the key does not exist
+	OnlyIfAbsent bool
+	// OnlyIfPresent stores the value only if the key already exists
+	OnlyIfPresent bool
+	// Flags are opaque client flags stored with the value
+	Flags uint32
+	// CompareVersion, when non-zero, stores the value only if the key's
+	// current version matches
+	CompareVersion uint64
+}
+
+// SetWithOptions stores a key-value pair subject to opts and reports
+// whether the value was stored
+func (db *DB) SetWithOptions(key string, value string, opts SetOptions) (bool, error) {
+	return db.SetWithOptionsContext(context.Background(), key, value, opts)
+}
+
+// SetWithOptionsContext is SetWithOptions with a context for tracing and
+// auditing
+func (db *DB) SetWithOptionsContext(ctx context.Context, key string, value string, opts SetOptions) (bool, error) {
+	defer db.histograms.latency[OpSet].ObserveSince(time.Now())
+	ctx, span := tracer.Start(ctx, "DB.SetWithOptions")
+	defer span.End()
+	if err := db.checkWriteKey(key); err != nil {
+		return false, err
+	}
+
+	db.mutex.LockContext(ctx)
+	defer db.mutex.Unlock()
+
+	db.expireDueLocked(key)
+	_, exists := db.store[key]
+	if opts.CompareVersion != 0 {
+		if !exists {
+			return false, ErrKeyNotFound
+		}
+		if db.meta[key].Version != opts.CompareVersion {
+			return false, ErrVersionMismatch
+		}
+	}
+	if (opts.OnlyIfAbsent && exists) || (opts.OnlyIfPresent && !exists) {
+		return false, nil
+	}
+	if err := db.roomLocked(key); err != nil {
+		return false, err
+	}
+
+	expiry, hadExpiry := db.expires[key]
+	db.overwriteLocked(key, value)
+	if opts.Flags != 0 {
+		meta := db.meta[key]
+		meta.Flags = opts.Flags
+		db.meta[key] = meta
+	}
+	if opts.TTL > 0 {
+		db.expires[key] = time.Now().Add(opts.TTL)
+	} else if opts.KeepTTL && hadExpiry {
+		db.expires[key] = expiry
+	}
+	db.auditLocked(ctx, AuditSet, key, &value)
+	atomic.AddInt64(&db.metrics.SetOps, 1)
+
+	return true, nil
+}
+
+// GetWithMeta retrieves a value along with its flags and version
+func (db *DB) GetWithMeta(key string) (string, KeyMeta, error) {
+	defer db.histograms.latency[OpGet].ObserveSince(time.Now())
+	atomic.AddInt64(&db.metrics.GetOps, 1)
+
+	if key == "" {
+		return "", KeyMeta{}, ErrEmptyKey
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	value, ok := db.lookupLocked(key)
+	db.recordReadLocked(key)
+	if !ok {
+		return "", KeyMeta{}, ErrKeyNotFound
+	}
+
+	return value, db.meta[key], nil
+}
+
+// Expire sets a key to expire after ttl. A non-positive ttl deletes the key
+// immediately.
+func (db *DB) Expire(key string, ttl time.Duration) error {
+	return db.ExpireContext(context.Background(), key, ttl)
+}
+
+// ExpireContext is Expire with a context for auditing
+func (db *DB) ExpireContext(ctx context.Context, key string, ttl time.Duration) error {
+	if err := db.checkWriteKey(key); err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.expireDueLocked(key)
+	if _, ok := db.store[key]; !ok {
+		return ErrKeyNotFound
+	}
+
+	if ttl <= 0 {
+		db.removeLocked(key)
+		db.auditLocked(ctx, AuditDelete, key, nil)
+		atomic.AddInt64(&db.metrics.DelOps, 1)
+		return nil
+	}
+	db.expires[key] = time.Now().Add(ttl)
+
+	return nil
+}
+
+// Persist removes a key's expiry and reports whether it had one
+func (db *DB) Persist(key string) (bool, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.expireDueLocked(key)
+	if _, ok := db.store[key]; !ok {
+		return false, ErrKeyNotFound
+	}
+
+	_, had := db.expires[key]
+	delete(db.expires, key)
+
+	return had, nil
+}
+
+// TTL returns the time remaining before a key expires. The boolean is
+// false if the key exists but has no expiry.
+func (db *DB) TTL(key string) (time.Duration, bool, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if _, ok := db.lookupLocked(key); !ok {
+		return 0, false, ErrKeyNotFound
+	}
+
+	expiry, ok := db.expires[key]
+	if !ok {
+		return 0, false, nil
+	}
+	return time.Until(expiry), true, nil
+}
+
+// Incr adds delta to the integer stored at key, treating a missing key as
+// zero, and returns the new value. The key's expiry and lease are kept.
+func (db *DB) Incr(key string, delta int64) (int64, error) {
+	return db.IncrContext(context.Background(), key, delta)
+}
+
+// IncrContext is Incr with a context for tracing and auditing
+func (db *DB) IncrContext(ctx context.Context, key string, delta int64) (int64, error) {
+	ctx, span := tracer.Start(ctx, "DB.Incr")
+	defer span.End()
+	if err := db.checkWriteKey(key); err != nil {
+		return 0, err
+	}
+
+	db.mutex.LockContext(ctx)
+	defer db.mutex.Unlock()
+
+	db.expireDueLocked(key)
+	if err := db.roomLocked(key); err != nil {
+		return 0, err
+	}
+
+	current := int64(0)
+	if value, ok := db.store[key]; ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		current = n
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrNotInteger
+	}
+	current += delta
+
+	value := strconv.FormatInt(current, 10)
+	db.putLocked(key, value)
+	db.auditLocked(ctx, AuditIncr, key, &value)
+	atomic.AddInt64(&db.metrics.SetOps, 1)
+
+	return current, nil
+}
+
+// IncrUnsigned adds delta to, or subtracts it from, the unsigned integer
+// stored at an existing key and returns the new value. Increments wrap
+// around on overflow and decrements stop at zero. The key's expiry, flags
+// and lease are kept.
+func (db *DB) IncrUnsigned(key string, delta uint64, decrement bool) (uint64, error) {
+	return db.IncrUnsignedContext(context.Background(), key, delta, decrement)
+}
+
+// IncrUnsignedContext is IncrUnsigned with a context for tracing and
+// auditing
+func (db *DB) IncrUnsignedContext(ctx context.Context, key string, delta uint64, decrement bool) (uint64, error) {
+	ctx, span := tracer.Start(ctx, "DB.IncrUnsigned")
+	defer span.End()
+	if err := db.checkWriteKey(key); err != nil {
+		return 0, err
+	}
+
+	db.mutex.LockContext(ctx)
+	defer db.mutex.Unlock()
+
+	db.expireDueLocked(key)
+
+	value, ok := db.store[key]
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	current, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+
+	if !decrement {
+		current += delta
+	} else if delta > current {
+		current = 0
+	} else {
+		current -= delta
+	}
+
+	value = strconv.FormatUint(current, 10)
+	db.putLocked(key, value)
+	db.auditLocked(ctx, AuditIncr, key, &value)
+	atomic.AddInt64(&db.metrics.SetOps, 1)
+
+	return current, nil
+}
+
+// Exists counts how many of the given keys exist. Repeated keys are
+// counted each time.
+func (db *DB) Exists(keys ...string) int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	count := 0
+	for _, key := range keys {
+		if _, ok := db.lookupLocked(key); ok {
+			count++
+		}
+	}
+	return count
+}
+
+// Keys returns the keys matching a glob pattern in sorted order. Patterns
+// support *, ?, [abc], [a-z], [^a] and backslash escapes.
+func (db *DB) Keys(pattern string) []string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	return db.sortedKeysLocked(pattern)
+}
+
+// Len returns the number of keys stored in constant time. Keys whose
+// expiry has passed are counted until they are deleted.
+func (db *DB) Len() int {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	return len(db.store)
+}
+
+// Scan looks at up to count keys in sorted order starting at cursor and
+// returns those matching pattern, along with the cursor to pass to the
+// next call. A page may hold fewer than count keys, or none, before the
+// scan is complete; a returned cursor of zero means it is. Cursors are
+// positions in sorted key order, so keys added or removed during a scan
+// may shift what later calls return.
+func (db *DB) Scan(cursor int, pattern string, count int) (int, []string) {
+	if count <= 0 {
+		count = 10
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	keys := []string{}
+	if cursor < 0 || cursor >= db.order.len {
+		return 0, keys
+	}
+
+	now := time.Now()
+	next := cursor
+	db.order.each(cursor, func(key string) bool {
+		if next-cursor == count {
+			return false
+		}
+		next++
+		if db.liveMatchLocked(key, pattern, now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if next >= db.order.len {
+		next = 0
+	}
+	return next, keys
+}
+
+// lookupRecords returns the records for those keys that exist, skipping
+// the rest
+func (db *DB) lookupRecords(keys []string) []Record {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	records := make([]Record, 0, len(keys))
+	for _, key := range keys {
+		if value, ok := db.lookupLocked(key); ok {
+			records = append(records, Record{Key: key, Value: value})
+		}
+	}
+	atomic.AddInt64(&db.metrics.GetOps, int64(len(records)))
+
+	return records
+}
+
+// sortedKeysLocked lists the live keys matching pattern in sorted order.
+// The caller must hold at least the read lock.
+func (db *DB) sortedKeysLocked(pattern string) []string {
+	now := time.Now()
+	keys := []string{}
+	db.order.each(0, func(key string) bool {
+		if db.liveMatchLocked(key, pattern, now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// liveMatchLocked reports whether key has not expired as of now and
+// matches pattern. The caller must hold at least the read lock.
+func (db *DB) liveMatchLocked(key string, pattern string, now time.Time) bool {
+	if expiry, ok := db.expires[key]; ok && !now.Before(expiry) {
+		return false
+	}
+	return pattern == "" || pattern == "*" || globMatch(pattern, key)
+}
+
+// expireDueLocked removes a key if its expiry has passed. The caller must
+// hold the write lock.
+func (db *DB) expireDueLocked(key string) {
+	if expiry, ok := db.expires[key]; ok && !time.Now().Before(expiry) {
+		db.removeLocked(key)
+		db.auditLocked(systemContext, AuditExpire, key, nil)
+		atomic.AddInt64(&db.metrics.DelOps, 1)
+	}
+}
+
+// expireKeys removes every key whose expiry has passed as of now and
+// returns how many were removed
+func (db *DB) expireKeys(now time.Time) int {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	expired := 0
+	for key, expiry := range db.expires {
+		if !now.Before(expiry) {
+			db.removeLocked(key)
+			db.auditLocked(systemContext, AuditExpire, key, nil)
+			expired++
+		}
+	}
+	atomic.AddInt64(&db.metrics.DelOps, int64(expired))
+
+	return expired
+}
+
+// globMatch reports whether s matches a Redis-style glob pattern. Stars
+// are matched by backtracking to the most recent star only, which keeps
+// matching linear in practice even for hostile patterns.
+func globMatch(pattern, s string) bool {
+	px, sx := 0, 0
+	starPx, starSx := -1, 0
+
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			if pattern[px] == '*' {
+				starPx, starSx = px, sx
+				px++
+				continue
+			}
+			if sx < len(s) {
+				if n, ok := matchToken(pattern[px:], s[sx]); ok {
+					px += n
+					sx++
+					continue
+				}
+			}
+		}
+		// Let the last star absorb one more byte and retry
+		if starPx >= 0 && starSx < len(s) {
+			starSx++
+			px, sx = starPx+1, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchToken matches one byte against the token at the start of pattern
+// and returns how many pattern bytes the token spans
+func matchToken(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+		return 1, c == '\\'
+
+	case '[':
+		end := 1
+		if end < len(pattern) && pattern[end] == '^' {
+			end++
+		}
+		if end < len(pattern) && pattern[end] == ']' {
+			end++
+		}
+		for end < len(pattern) && pattern[end] != ']' {
+			end++
+		}
+		if end >= len(pattern) {
+			// An unterminated class is a literal bracket
+			return 1, c == '['
+		}
+		return end + 1, classMatch(pattern[1:end], c)
+	}
+
+	return 1, pattern[0] == c
+}
+
+// classMatch reports whether c matches the body of a [...] class
+func classMatch(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
@@ -0,0 +1,130 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"h?llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{`\*literal`, "*literal", true},
+		{"[unterminated", "[unterminated", true},
+	}
+
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestKeyExpiry(t *testing.T) {
+	db := newTestDB(t)
+
+	db.SetWithOptions("session", "abc", SetOptions{TTL: time.Minute})
+	if remaining, ok, err := db.TTL("session"); err != nil || !ok || remaining <= 0 {
+		t.Errorf("Expected positive TTL, got %v, %v, %v", remaining, ok, err)
+	}
+
+	// A plain set clears the expiry
+	db.Set("session", "def")
+	if _, ok, _ := db.TTL("session"); ok {
+		t.Error("Expected Set to clear the expiry")
+	}
+
+	db.Expire("session", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := db.Get("session"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected expired key to be missing, got %v", err)
+	}
+	if n := db.expireKeys(time.Now()); n != 1 {
+		t.Errorf("Expected 1 key removed by expiry, got %d", n)
+	}
+	if db.metrics.KeysStored != 0 {
+		t.Errorf("Expected KeysStored to be 0, got %d", db.metrics.KeysStored)
+	}
+}
+
+func TestIncrAndConditionalSet(t *testing.T) {
+	db := newTestDB(t)
+
+	if n, err := db.Incr("counter", 5); err != nil || n != 5 {
+		t.Errorf("Expected 5, got %d, %v", n, err)
+	}
+	if n, _ := db.Incr("counter", -2); n != 3 {
+		t.Errorf("Expected 3, got %d", n)
+	}
+
+	db.Set("text", "abc")
+	if _, err := db.Incr("text", 1); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("Expected ErrNotInteger, got %v", err)
+	}
+
+	if stored, _ := db.SetWithOptions("text", "x", SetOptions{OnlyIfAbsent: true}); stored {
+		t.Error("Expected NX set on existing key to be skipped")
+	}
+	if stored, _ := db.SetWithOptions("missing", "x", SetOptions{OnlyIfPresent: true}); stored {
+		t.Error("Expected XX set on missing key to be skipped")
+	}
+
+	next, keys := db.Scan(0, "*", 2)
+	if next != 0 || !reflect.DeepEqual(keys, []string{"counter", "text"}) {
+		t.Errorf("Unexpected scan result %d, %v", next, keys)
+	}
+}
+
+func TestScanPages(t *testing.T) {
+	db := newTestDB(t)
+	for _, i := range rand.Perm(3000) {
+		db.Set(fmt.Sprintf("k%04d", i), "v")
+	}
+	for i := 0; i < 3000; i += 3 {
+		db.Delete(fmt.Sprintf("k%04d", i))
+	}
+	db.Set("expired", "v")
+	db.Expire("expired", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	all := db.Keys("*")
+	if len(all) != 2000 || !sort.StringsAreSorted(all) {
+		t.Fatalf("Expected 2000 sorted keys, got %d", len(all))
+	}
+
+	// A full walk returns each matching key once, in order
+	var walked []string
+	cursor, pages := 0, 0
+	for {
+		next, keys := db.Scan(cursor, "k1*", 100)
+		if len(keys) > 100 {
+			t.Fatalf("Expected at most 100 keys per page, got %d", len(keys))
+		}
+		walked = append(walked, keys...)
+		pages++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(walked, db.Keys("k1*")) {
+		t.Errorf("Expected the walk to return the matching keys, got %d keys", len(walked))
+	}
+	if pages != 21 {
+		t.Errorf("Expected 21 pages of 100 positions, got %d", pages)
+	}
+}
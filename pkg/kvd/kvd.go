@@ -3,14 +3,18 @@ package kvd
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,22 +27,165 @@ type Status struct {
 	Message string `json:"message"`
 	Ts      string `json:"ts"`
 	Version string `json:"version"`
+
+	// Uptime and GoVersion are filled in for each request
+	Uptime    string `json:"uptime"`
+	GoVersion string `json:"go_version"`
 }
 
 // Config represents the configuration for the KVD server
 type Config struct {
-	Port       int
-	MaxRecords int
-	Host       string
-	LogLevel   string
+	Port int    `yaml:"port" toml:"port"`
+	Host string `yaml:"host" toml:"host"`
+	// MaxRecords caps the number of keys stored; writes of new keys
+	// beyond it fail. Zero means no limit.
+	MaxRecords int    `yaml:"max_records" toml:"max_records"`
+	LogLevel   string `yaml:"log_level" toml:"log_level"`
+
+	// DataDir keeps the data across restarts: a snapshot is saved there on
+	// shutdown and loaded on start. Empty means the data is not kept.
+	DataDir string `yaml:"data_dir" toml:"data_dir"`
+	// SnapshotInterval also saves the data to DataDir this often while the
+	// server runs; /healthz fails when saves fall two intervals behind.
+	// Zero saves only on shutdown.
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" toml:"snapshot_interval"`
+
+	// MaxMemory makes /readyz fail while the heap is over this many bytes;
+	// zero means no check
+	MaxMemory int64 `yaml:"max_memory" toml:"max_memory"`
+	// DrainDelay keeps serving for this long after a shutdown signal,
+	// while /readyz reports draining, so load balancers can stop sending
+	// requests first
+	DrainDelay time.Duration `yaml:"drain_delay" toml:"drain_delay"`
+
+	// LogFormat is text or json; empty means text
+	LogFormat string `yaml:"log_format" toml:"log_format"`
+	// LogKeys controls how keys appear in logs: plain, hash or redact;
+	// empty means plain
+	LogKeys string `yaml:"log_keys" toml:"log_keys"`
+
+	// Limits for server-side scripts; zero values use the defaults
+	ScriptMaxSteps int           `yaml:"script_max_steps" toml:"script_max_steps"`
+	ScriptTimeout  time.Duration `yaml:"script_timeout" toml:"script_timeout"`
+
+	// RedisPort enables the Redis protocol listener when non-zero
+	RedisPort int `yaml:"redis_port" toml:"redis_port"`
+
+	// MemcachedPort enables the memcached text protocol listener when
+	// non-zero
+	MemcachedPort int `yaml:"memcached_port" toml:"memcached_port"`
+
+	// GRPCPort enables the gRPC API when non-zero
+	GRPCPort int `yaml:"grpc_port" toml:"grpc_port"`
+
+	// LegacyBulkRoutes keeps the JSON body forms of GET, PUT and DELETE on
+	// /v1/ for clients that predate the _mget, _mset and _mdelete routes
+	LegacyBulkRoutes bool `yaml:"legacy_bulk_routes" toml:"legacy_bulk_routes"`
+
+	// HotKeys tracks the most read and written keys among this many
+	// candidates when non-zero
+	HotKeys int `yaml:"hot_keys" toml:"hot_keys"`
+
+	// TraceEndpoint enables OpenTelemetry tracing, exporting spans over
+	// OTLP/HTTP to this URL, e.g. http://localhost:4318
+	TraceEndpoint string `yaml:"trace_endpoint" toml:"trace_endpoint"`
+
+	// AuditFile or AuditWebhook enables the audit log of changes, written
+	// to a rotating file or posted to a URL
+	AuditFile    string `yaml:"audit_file" toml:"audit_file"`
+	AuditWebhook string `yaml:"audit_webhook" toml:"audit_webhook"`
+	// AuditMaxSize is the size in bytes at which the audit file is
+	// rotated, keeping AuditMaxBackups old files
+	AuditMaxSize    int64 `yaml:"audit_max_size" toml:"audit_max_size"`
+	AuditMaxBackups int   `yaml:"audit_max_backups" toml:"audit_max_backups"`
+	// AuditQueueSize bounds the entries waiting to be written
+	AuditQueueSize int `yaml:"audit_queue_size" toml:"audit_queue_size"`
+	// AuditHashValues adds the SHA-256 of written values to entries
+	AuditHashValues bool `yaml:"audit_hash_values" toml:"audit_hash_values"`
+
+	// TLSCertFile and TLSKeyFile serve HTTPS instead of HTTP. Replaced
+	// files are picked up without a restart.
+	TLSCertFile string `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKeyFile  string `yaml:"tls_key" toml:"tls_key"`
+	// TLSMinVersion is 1.2 or 1.3; empty means 1.2
+	TLSMinVersion string `yaml:"tls_min_version" toml:"tls_min_version"`
+	// TLSCipherSuites restricts the TLS 1.2 cipher suites by name; empty
+	// means Go's defaults
+	TLSCipherSuites []string `yaml:"tls_cipher_suites" toml:"tls_cipher_suites"`
+	// TLSClientCAFile requires client certificates signed by one of its
+	// CAs, or only verifies them when TLSClientCertOptional is set
+	TLSClientCAFile       string `yaml:"tls_client_ca" toml:"tls_client_ca"`
+	TLSClientCertOptional bool   `yaml:"tls_client_cert_optional" toml:"tls_client_cert_optional"`
+
+	// AuthFile enables token authentication of the HTTP API, with the
+	// tokens kept in this JSON file. An admin token is created and logged
+	// if the file has none.
+	AuthFile string `yaml:"auth_file" toml:"auth_file"`
+	// AllowUnauthenticatedListeners permits the Redis, memcached and gRPC
+	// listeners alongside AuthFile. They do not check tokens, so anyone
+	// who can reach them can read and change every key.
+	AllowUnauthenticatedListeners bool `yaml:"allow_unauthenticated_listeners" toml:"allow_unauthenticated_listeners"`
+
+	// RateLimit allows each client this many HTTP requests per second, in
+	// bursts of up to RateLimitBurst; zero means no limit. Clients are
+	// told apart by token, certificate or IP address.
+	RateLimit      float64 `yaml:"rate_limit" toml:"rate_limit"`
+	RateLimitBurst int     `yaml:"rate_limit_burst" toml:"rate_limit_burst"`
+	// RateLimitRoutes and RateLimitPrefixes add per-client limits for
+	// routes and key prefixes, as "POST /v1/_mset=5:10" or "batch/=50"
+	// (RATE or RATE:BURST)
+	RateLimitRoutes   []string `yaml:"rate_limit_routes" toml:"rate_limit_routes"`
+	RateLimitPrefixes []string `yaml:"rate_limit_prefixes" toml:"rate_limit_prefixes"`
+
+	// Request limits for the HTTP and gRPC APIs; zero values use the
+	// defaults. MaxKeyLength and MaxValueSize are in bytes, MaxBulkRecords
+	// bounds the keys or records of a bulk request and MaxBodySize the
+	// size of JSON request bodies.
+	MaxKeyLength   int   `yaml:"max_key_length" toml:"max_key_length"`
+	MaxValueSize   int   `yaml:"max_value_size" toml:"max_value_size"`
+	MaxBulkRecords int   `yaml:"max_bulk_records" toml:"max_bulk_records"`
+	MaxBodySize    int64 `yaml:"max_body_size" toml:"max_body_size"`
+
+	// ReservedPrefixes are key prefixes kept for internal use, which
+	// clients cannot write or delete
+	ReservedPrefixes []string `yaml:"reserved_prefixes" toml:"reserved_prefixes"`
 }
 
 // Kvd represents the KVD server instance
 type Kvd struct {
 	config *Config
 	db     DB
+	broker Broker
 	status Status
-	logger *log.Logger
+	logger *slog.Logger
+
+	// logLevel can be changed while the server runs
+	logLevel *slog.LevelVar
+
+	// Prometheus registry and request metrics
+	metrics *httpMetrics
+
+	// TLS settings and the certificates behind them, nil without TLS
+	tlsConfig *tls.Config
+	certs     *certReloader
+
+	// API tokens, nil when authentication is off
+	tokens *tokenStore
+
+	// Settings a reload can change, with the request size and rate limits
+	live atomic.Pointer[liveSettings]
+
+	// Reads the configuration again for Reload, nil when it cannot
+	loadConfig  func() (*Config, error)
+	reloadMutex sync.Mutex
+
+	// Start time, state and checks reported by /healthz and /readyz
+	started time.Time
+	state   atomic.Int32
+	checks  healthChecks
+
+	// Outcome of the last save to the data directory
+	saves saveStatus
 }
 
 // Record represents a key-value pair
@@ -50,10 +197,27 @@ type Record struct {
 // DefaultConfig returns a default configuration for the KVD server
 func DefaultConfig() *Config {
 	return &Config{
-		Port:       8080,
-		MaxRecords: 10000,
-		Host:       "0.0.0.0",
-		LogLevel:   "info",
+		Port:      8080,
+		Host:      "0.0.0.0",
+		LogLevel:  "info",
+		LogFormat: LogFormatText,
+		LogKeys:   LogKeysPlain,
+
+		ScriptMaxSteps: DefaultScriptMaxSteps,
+		ScriptTimeout:  DefaultScriptTimeout,
+
+		AuditMaxSize:    DefaultAuditMaxSize,
+		AuditMaxBackups: DefaultAuditMaxBackups,
+		AuditQueueSize:  DefaultAuditQueueSize,
+
+		TLSMinVersion: TLSVersion12,
+
+		MaxKeyLength:   DefaultMaxKeyLength,
+		MaxValueSize:   DefaultMaxValueSize,
+		MaxBulkRecords: DefaultMaxBulkRecords,
+		MaxBodySize:    DefaultMaxBodySize,
+
+		ReservedPrefixes: []string{DefaultReservedPrefix},
 	}
 }
 
@@ -63,21 +227,82 @@ func (kvd *Kvd) Init(c *Config) error {
 	if kvd.config == nil {
 		kvd.config = DefaultConfig()
 	}
+	if err := kvd.config.Validate(); err != nil {
+		return err
+	}
 	
 	// Initialize logger
-	kvd.logger = log.New(os.Stdout, "KVD: ", log.LstdFlags)
+	logger, level, err := newLogger(os.Stdout, kvd.config)
+	if err != nil {
+		return fmt.Errorf("could not configure logging: %w", err)
+	}
+	kvd.logger = logger
+	kvd.logLevel = level
 	
 	// Initialize database
 	if err := kvd.db.Init(); err != nil {
-		kvd.logger.Printf("Error initializing database: %v", err)
+		kvd.logger.Error("Error initializing database", "err", err)
 		return fmt.Errorf("could not initialize database: %w", err)
 	}
+	kvd.db.EnableHotKeys(kvd.config.HotKeys)
+	kvd.db.SetReservedPrefixes(kvd.config.ReservedPrefixes)
+	kvd.db.SetMaxRecords(kvd.config.MaxRecords)
+	
+	// Load TLS certificates
+	kvd.tlsConfig, kvd.certs, err = newServerTLS(kvd.config, kvd.logger)
+	if err != nil {
+		return err
+	}
+	
+	// Load API tokens
+	if kvd.config.AuthFile != "" {
+		if kvd.tokens, err = loadTokenStore(kvd.config.AuthFile); err != nil {
+			return err
+		}
+		token, err := kvd.tokens.bootstrap()
+		if err != nil {
+			return err
+		}
+		if token != "" {
+			kvd.logger.Warn("Created an admin token; it is not shown again", "token", token, "file", kvd.config.AuthFile)
+		}
+	}
+	
+	// Set up request size and rate limits
+	live, err := newLiveSettings(kvd.config)
+	if err != nil {
+		return err
+	}
+	kvd.live.Store(live)
+	
+	// Initialize the audit log
+	sink, err := newAuditSink(kvd.config)
+	if err != nil {
+		return err
+	}
+	if sink != nil {
+		kvd.db.EnableAudit(sink, AuditOptions{
+			QueueSize:  kvd.config.AuditQueueSize,
+			HashValues: kvd.config.AuditHashValues,
+		}, kvd.logger)
+	}
+	
+	// Initialize pub/sub broker
+	kvd.broker.Init()
+	
+	// Initialize Prometheus metrics
+	kvd.metrics = newHTTPMetrics(kvd)
+	
+	// Register the health checks for the configured features
+	kvd.addBuiltinChecks()
 	
 	// Set server status
+	kvd.started = time.Now()
 	kvd.status = Status{
-		Status:  "ok",
-		Message: "Server initialized",
-		Version: "1.0.0",
+		Status:    "ok",
+		Message:   "Server initialized",
+		Version:   Version,
+		GoVersion: runtime.Version(),
 	}
 	
 	return nil
@@ -96,22 +321,39 @@ func (kvd *Kvd) toJSON(obj interface{}) ([]byte, error) {
 
 // statusHandler handles requests for server status
 func (kvd *Kvd) statusHandler(w http.ResponseWriter, r *http.Request) {
-	kvd.status.Ts = time.Now().Format(time.RFC3339)
+	status := kvd.status
+	status.Ts = time.Now().Format(time.RFC3339)
+	status.Uptime = kvd.uptime()
+	if state := kvd.state.Load(); state != serverReady {
+		status.Status = serverStates[state]
+	}
 	w.Header().Set("Content-Type", "application/json")
 	
-	if err := json.NewEncoder(w).Encode(kvd.status); err != nil {
-		kvd.logger.Printf("Error encoding status response: %v", err)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		kvd.log(r).Error("Error encoding status response", "err", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 }
 
-// metricsHandler handles requests for server metrics
+// metricsHandler handles requests for server metrics. Scrapers asking for
+// the Prometheus text or OpenMetrics format get it; everyone else gets JSON.
 func (kvd *Kvd) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsPrometheus(r) {
+		kvd.metrics.handler.ServeHTTP(w, r)
+		return
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
 	
-	if err := json.NewEncoder(w).Encode(kvd.db.metrics); err != nil {
-		kvd.logger.Printf("Error encoding metrics response: %v", err)
+	metrics := kvd.db.Metrics()
+	metrics.Channels = kvd.broker.Metrics()
+	if limiter := kvd.settings().limiter; limiter != nil {
+		metrics.RateLimited = limiter.Metrics()
+	}
+	
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		kvd.log(r).Error("Error encoding metrics response", "err", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -122,20 +364,20 @@ func (kvd *Kvd) keyGetHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["key"]
 
-	value, err := kvd.db.Get(key)
+	value, err := kvd.db.GetContext(r.Context(), key)
 	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrInvalidKey) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		kvd.logger.Printf("Error getting key %s: %v", key, err)
+		kvd.log(r).Error("Error getting key", "key", kvd.redactKey(key), "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	if _, err := w.Write([]byte(value)); err != nil {
-		kvd.logger.Printf("Error writing response: %v", err)
+		kvd.log(r).Error("Error writing response", "err", err)
 	}
 }
 
@@ -145,26 +387,26 @@ func (kvd *Kvd) keyDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	key := vars["key"]
 
 	// Get the value before deleting it
-	value, err := kvd.db.Get(key)
+	value, err := kvd.db.GetContext(r.Context(), key)
 	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrInvalidKey) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		kvd.logger.Printf("Error getting key %s: %v", key, err)
+		kvd.log(r).Error("Error getting key", "key", kvd.redactKey(key), "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if err := kvd.db.Delete(key); err != nil {
-		kvd.logger.Printf("Error deleting key %s: %v", key, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := kvd.db.DeleteContext(r.Context(), key); err != nil {
+		kvd.log(r).Error("Error deleting key", "key", kvd.redactKey(key), "err", err)
+		http.Error(w, err.Error(), keyErrorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	if _, err := w.Write([]byte(value)); err != nil {
-		kvd.logger.Printf("Error writing response: %v", err)
+		kvd.log(r).Error("Error writing response", "err", err)
 	}
 }
 
@@ -178,154 +420,77 @@ func (kvd *Kvd) keyPutHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, err := io.ReadAll(r.Body)
-	defer r.Body.Close()
-
-	if err != nil {
-		kvd.logger.Printf("Error reading request body: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if err := kvd.db.Set(key, string(value)); err != nil {
-		kvd.logger.Printf("Error setting key %s: %v", key, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusCreated)
-}
-
-// keyManyPutHandler handles bulk key set operations
-func (kvd *Kvd) keyManyPutHandler(w http.ResponseWriter, r *http.Request) {
-	var records []Record
-
-	if r.Body == nil {
-		http.Error(w, "Request body is required", http.StatusBadRequest)
+	if err := kvd.settings().limits.checkKey(key); err != nil {
+		http.Error(w, err.Error(), limitErrorStatus(err))
 		return
 	}
-
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
-	defer r.Body.Close()
-	
-	if err != nil {
-		kvd.logger.Printf("Error reading request body: %v", err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	value, ok := kvd.readBody(w, r, int64(kvd.settings().limits.maxValueSize), ErrValueTooLarge)
+	if !ok {
 		return
 	}
 
-	if err := json.Unmarshal(body, &records); err != nil {
-		kvd.logger.Printf("Error unmarshaling request: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-		return
-	}
-
-	if len(records) == 0 {
-		http.Error(w, "No records provided", http.StatusBadRequest)
+	// Attach the key to a lease when one is given
+	if leaseParam := r.URL.Query().Get("lease"); leaseParam != "" {
+		leaseID, err := strconv.ParseInt(leaseParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid lease ID", http.StatusBadRequest)
+			return
+		}
+		if err := kvd.db.SetWithLeaseContext(r.Context(), key, string(value), leaseID); err != nil {
+			http.Error(w, err.Error(), leaseErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
 		return
 	}
 
-	if err := kvd.db.BulkSet(records); err != nil {
-		kvd.logger.Printf("Error in bulk set operation: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := kvd.db.SetContext(r.Context(), key, string(value)); err != nil {
+		kvd.log(r).Error("Error setting key", "key", kvd.redactKey(key), "err", err)
+		http.Error(w, err.Error(), keyErrorStatus(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
-// keyManyGetHandler handles bulk key get operations
-func (kvd *Kvd) keyManyGetHandler(w http.ResponseWriter, r *http.Request) {
-	var keys []string
-
-	if r.Body == nil {
-		http.Error(w, "Request body is required", http.StatusBadRequest)
-		return
-	}
-
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
-	defer r.Body.Close()
-	
-	if err != nil {
-		kvd.logger.Printf("Error reading request body: %v", err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-
-	if err := json.Unmarshal(body, &keys); err != nil {
-		kvd.logger.Printf("Error unmarshaling request: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+// keyManyPutHandler handles legacy bulk key set operations on PUT /v1/
+func (kvd *Kvd) keyManyPutHandler(w http.ResponseWriter, r *http.Request) {
+	var records []Record
+	if !kvd.decodeJSONBody(w, r, &records) {
 		return
 	}
+	kvd.writeBulkSet(w, r, records)
+}
 
-	if len(keys) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte("[]"))
+// keyManyGetHandler handles bulk key get operations. Keys are read from
+// repeated ?key= parameters, or from a JSON body when legacy bulk routes
+// are enabled.
+func (kvd *Kvd) keyManyGetHandler(w http.ResponseWriter, r *http.Request) {
+	if keys, ok := r.URL.Query()["key"]; ok {
+		kvd.writeBulkGet(w, r, keys)
 		return
 	}
 
-	records, err := kvd.db.BulkGet(keys)
-	if err != nil {
-		kvd.logger.Printf("Error in bulk get operation: %v", err)
-		status := http.StatusInternalServerError
-		if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrInvalidKey) {
-			status = http.StatusNotFound
-		}
-		http.Error(w, err.Error(), status)
+	if !kvd.config.LegacyBulkRoutes {
+		http.Error(w, "Keys must be given as ?key= parameters or with POST /v1/_mget", http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(records); err != nil {
-		kvd.logger.Printf("Error encoding response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	var keys []string
+	if !kvd.decodeJSONBody(w, r, &keys) {
 		return
 	}
+	kvd.writeBulkGet(w, r, keys)
 }
 
-// keyManyDeletesHandler handles bulk key delete operations
+// keyManyDeletesHandler handles legacy bulk key delete operations on
+// DELETE /v1/
 func (kvd *Kvd) keyManyDeletesHandler(w http.ResponseWriter, r *http.Request) {
 	var keys []string
-
-	if r.Body == nil {
-		http.Error(w, "Request body is required", http.StatusBadRequest)
-		return
-	}
-
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1048576))
-	defer r.Body.Close()
-	
-	if err != nil {
-		kvd.logger.Printf("Error reading request body: %v", err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
-	}
-
-	if err := json.Unmarshal(body, &keys); err != nil {
-		kvd.logger.Printf("Error unmarshaling request: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
-		return
-	}
-
-	if len(keys) == 0 {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if err := kvd.db.BulkDelete(keys); err != nil {
-		kvd.logger.Printf("Error in bulk delete operation: %v", err)
-		status := http.StatusInternalServerError
-		if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrInvalidKey) {
-			status = http.StatusNotFound
-		}
-		http.Error(w, err.Error(), status)
+	if !kvd.decodeJSONBody(w, r, &keys) {
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
+	kvd.writeBulkDelete(w, r, keys)
 }
 
 // StartService starts the KVD HTTP server
@@ -337,19 +502,68 @@ func (kvd *Kvd) StartService(ctx context.Context) (context.Context, error) {
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
 	// Create and configure router
-	router := mux.NewRouter().StrictSlash(true)
+	router := mux.NewRouter().StrictSlash(true).UseEncodedPath()
+	router.Use(decodeVars, kvd.logRequests, kvd.metrics.instrument, traceRequests, kvd.rejectWhileLoading, kvd.rateLimit)
+	if kvd.tokens != nil {
+		router.Use(kvd.authorize)
+		router.HandleFunc("/admin/tokens", kvd.tokenListHandler).Methods(http.MethodGet)
+		router.HandleFunc("/admin/tokens", kvd.tokenCreateHandler).Methods(http.MethodPost)
+		router.HandleFunc("/admin/tokens/{name}", kvd.tokenDeleteHandler).Methods(http.MethodDelete)
+	}
+
+	// Lease and lock routes
+	router.HandleFunc("/v1/_leases", kvd.leaseGrantHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_leases/{id}", kvd.leaseGetHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/_leases/{id}", kvd.leaseRevokeHandler).Methods(http.MethodDelete)
+	router.HandleFunc("/v1/_leases/{id}/keepalive", kvd.leaseKeepAliveHandler).Methods(http.MethodPut)
+	router.HandleFunc("/v1/_locks/{name}", kvd.lockAcquireHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_locks/{name}", kvd.lockReleaseHandler).Methods(http.MethodDelete)
+
+	// Script routes
+	router.HandleFunc("/v1/_scripts", kvd.scriptLoadHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_scripts/{hash}", kvd.scriptRunHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_scripts/{hash}", kvd.scriptUnloadHandler).Methods(http.MethodDelete)
+
+	// Secondary index routes
+	router.HandleFunc("/v1/_indexes", kvd.indexListHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/_indexes/{name}", kvd.indexCreateHandler).Methods(http.MethodPut)
+	router.HandleFunc("/v1/_indexes/{name}", kvd.indexDropHandler).Methods(http.MethodDelete)
+	router.HandleFunc("/v1/_indexes/{name}/query", kvd.indexQueryHandler).Methods(http.MethodGet)
+
+	// Pub/sub routes
+	router.HandleFunc("/v1/_channels/{channel}", kvd.channelPublishHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_channels/{pattern}", kvd.channelSubscribeHandler).Methods(http.MethodGet)
+
+	// Bulk routes
+	router.HandleFunc("/v1/_mget", kvd.mgetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_mset", kvd.msetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_mdelete", kvd.mdeleteHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_import", kvd.importHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_export", kvd.exportHandler).Methods(http.MethodGet)
+	router.HandleFunc("/v1/", kvd.keyManyGetHandler).Methods(http.MethodGet)
+	if kvd.config.LegacyBulkRoutes {
+		router.HandleFunc("/v1/", kvd.keyManyPutHandler).Methods(http.MethodPut)
+		router.HandleFunc("/v1/", kvd.keyManyDeletesHandler).Methods(http.MethodDelete)
+	}
 
 	// API routes
-	router.HandleFunc("/v1/", kvd.keyManyPutHandler).Methods(http.MethodPut)
-	router.HandleFunc("/v1/", kvd.keyManyGetHandler).Methods(http.MethodGet)
-	router.HandleFunc("/v1/", kvd.keyManyDeletesHandler).Methods(http.MethodDelete)
 	router.HandleFunc("/v1/{key}", kvd.keyPutHandler).Methods(http.MethodPut)
 	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
 	router.HandleFunc("/v1/{key}", kvd.keyDeleteHandler).Methods(http.MethodDelete)
 	
+	// Health routes, open to probes without a token
+	router.HandleFunc("/healthz", kvd.healthzHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", kvd.readyzHandler).Methods(http.MethodGet)
+	
 	// Admin routes
 	router.HandleFunc("/status", kvd.statusHandler).Methods(http.MethodGet)
 	router.HandleFunc("/metrics", kvd.metricsHandler).Methods(http.MethodGet)
+	router.HandleFunc("/admin/backup", kvd.backupHandler).Methods(http.MethodGet)
+	router.HandleFunc("/admin/restore", kvd.restoreHandler).Methods(http.MethodPost)
+	router.HandleFunc("/admin/top", kvd.topHandler).Methods(http.MethodGet)
+	router.HandleFunc("/admin/loglevel", kvd.logLevelHandler).Methods(http.MethodGet)
+	router.HandleFunc("/admin/loglevel", kvd.logLevelSetHandler).Methods(http.MethodPut)
+	router.HandleFunc("/admin/reload", kvd.reloadHandler).Methods(http.MethodPost)
 
 	// Configure server address
 	host := kvd.config.Host
@@ -358,6 +572,16 @@ func (kvd *Kvd) StartService(ctx context.Context) (context.Context, error) {
 	}
 	serviceAddress := fmt.Sprintf("%s:%d", host, kvd.config.Port)
 
+	shutdownTracing := func(context.Context) error { return nil }
+	if kvd.config.TraceEndpoint != "" {
+		shutdown, err := kvd.startTracing(ctx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		shutdownTracing = shutdown
+	}
+
 	// Create HTTP server
 	srv := &http.Server{
 		Addr:         serviceAddress,
@@ -365,32 +589,111 @@ func (kvd *Kvd) StartService(ctx context.Context) (context.Context, error) {
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      router,
+		TLSConfig:    kvd.tlsConfig,
+		ErrorLog:     slog.NewLogLogger(kvd.logger.Handler(), slog.LevelWarn),
 	}
+	
+	// End event streams so shutdown does not wait on them
+	srv.RegisterOnShutdown(kvd.broker.Close)
 
-	// Start HTTP server
+	// Start HTTP server, answering only health checks until the data is
+	// loaded
+	if kvd.config.DataDir != "" {
+		kvd.state.Store(serverLoading)
+	}
 	go func() {
-		kvd.logger.Printf("Starting KVD server on %s", serviceAddress)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			kvd.logger.Printf("HTTP server error: %v", err)
+		kvd.logger.Info("Starting KVD server", "addr", serviceAddress, "tls", kvd.tlsConfig != nil)
+		serve := srv.ListenAndServe
+		if kvd.tlsConfig != nil {
+			serve = func() error { return srv.ListenAndServeTLS("", "") }
+		}
+		// After a shutdown signal, the shutdown handler cancels once traces
+		// are flushed
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			kvd.logger.Error("HTTP server error", "err", err)
+			cancel()
 		}
-		cancel()
 	}()
 
+	// Restore the data saved by the last shutdown
+	if err := kvd.loadDataDir(); err != nil {
+		srv.Close()
+		cancel()
+		return nil, err
+	}
+
+	// Start optional protocol listeners sharing the same database
+	if kvd.config.RedisPort != 0 {
+		if _, err := kvd.startTCPServer(ctx, "Redis", host, kvd.config.RedisPort, kvd.serveRESP); err != nil {
+			srv.Close()
+			cancel()
+			return nil, err
+		}
+	}
+	if kvd.config.MemcachedPort != 0 {
+		if _, err := kvd.startTCPServer(ctx, "memcached", host, kvd.config.MemcachedPort, kvd.serveMemcache); err != nil {
+			srv.Close()
+			cancel()
+			return nil, err
+		}
+	}
+	if kvd.config.GRPCPort != 0 {
+		if err := kvd.startGRPCServer(ctx, host, kvd.config.GRPCPort); err != nil {
+			srv.Close()
+			cancel()
+			return nil, err
+		}
+	}
+
+	// Revoke expired leases and keys in the background
+	go kvd.db.runExpiry(ctx, time.Second)
+
+	// Reload the configuration on SIGHUP
+	go kvd.reloadOnSignal(ctx)
+
+	// Pick up renewed certificates
+	if kvd.certs != nil {
+		go kvd.certs.run(ctx, tlsReloadInterval)
+	}
+
+	// Save the data periodically
+	if kvd.config.DataDir != "" && kvd.config.SnapshotInterval > 0 {
+		go kvd.runSnapshots(ctx, kvd.config.SnapshotInterval)
+	}
+
+	kvd.state.Store(serverReady)
+
 	// Handle graceful shutdown
 	go func() {
-		kvd.logger.Println("KVD started. Press Ctrl+C to stop.")
+		kvd.logger.Info("KVD started. Press Ctrl+C to stop.")
 		<-signalChan
-		kvd.logger.Println("Shutdown signal received, stopping server...")
+		kvd.logger.Info("Shutdown signal received, stopping server...")
+		
+		// Report draining so load balancers stop sending requests
+		kvd.state.Store(serverDraining)
+		if kvd.config.DrainDelay > 0 {
+			kvd.logger.Info("Draining before shutdown", "delay", kvd.config.DrainDelay)
+			time.Sleep(kvd.config.DrainDelay)
+		}
 		
 		// Create a shutdown timeout context
 		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 10*time.Second)
 		defer shutdownCancel()
 		
 		if err := srv.Shutdown(shutdownCtx); err != nil {
-			kvd.logger.Printf("Server shutdown error: %v", err)
+			kvd.logger.Error("Server shutdown error", "err", err)
+		}
+		if err := kvd.saveDataDir(); err != nil {
+			kvd.logger.Error("Data save error", "err", err)
+		}
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			kvd.logger.Error("Trace exporter shutdown error", "err", err)
+		}
+		if err := kvd.db.CloseAudit(shutdownCtx); err != nil {
+			kvd.logger.Error("Audit log shutdown error", "err", err)
 		}
 		
-		kvd.logger.Println("Server stopped")
+		kvd.logger.Info("Server stopped")
 		cancel()
 	}()
 
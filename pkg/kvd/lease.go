@@ -0,0 +1,362 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Lease and lock errors
+var (
+	ErrLeaseNotFound = errors.New("lease not found")
+	ErrInvalidTTL    = errors.New("lease TTL must be at least one second")
+	ErrEmptyLockName = errors.New("empty lock name not allowed")
+	ErrLockHeld      = errors.New("lock is held by another lease")
+	ErrLockNotHeld   = errors.New("lock is not held with the given token")
+)
+
+// lease is a time-bound grant that keys and locks can be attached to.
+// When the lease expires or is revoked, its keys are deleted and its
+// locks are released.
+type lease struct {
+	id      int64
+	ttl     time.Duration
+	expires time.Time
+	keys    map[string]struct{}
+	locks   map[string]struct{}
+	// owner is the name of the token that granted the lease, or empty
+	// when authentication is off
+	owner string
+}
+
+// lockEntry records the current holder of a named lock
+type lockEntry struct {
+	leaseID int64
+	token   int64
+}
+
+// LeaseInfo describes a lease as seen by clients
+type LeaseInfo struct {
+	ID        int64    `json:"ID"`
+	TTL       int64    `json:"TTL"`
+	Remaining int64    `json:"Remaining"`
+	Keys      []string `json:"Keys"`
+}
+
+// LockInfo describes a held lock. Token is a fencing token that strictly
+// increases every time any lock is acquired, so resources guarded by the
+// lock can reject writes carrying a stale token.
+type LockInfo struct {
+	Name    string `json:"Name"`
+	Token   int64  `json:"Token"`
+	LeaseID int64  `json:"LeaseID"`
+}
+
+// info builds the client view of a lease
+func (l *lease) info(now time.Time) LeaseInfo {
+	keys := make([]string, 0, len(l.keys))
+	for key := range l.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	remaining := l.expires.Sub(now).Round(time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return LeaseInfo{
+		ID:        l.id,
+		TTL:       int64(l.ttl / time.Second),
+		Remaining: int64(remaining / time.Second),
+		Keys:      keys,
+	}
+}
+
+// GrantLease creates a new lease that expires after ttl unless kept alive
+func (db *DB) GrantLease(ttl time.Duration) (LeaseInfo, error) {
+	return db.GrantLeaseContext(context.Background(), ttl)
+}
+
+// GrantLeaseContext is GrantLease with a context carrying the client's
+// principal, which becomes the lease's owner
+func (db *DB) GrantLeaseContext(ctx context.Context, ttl time.Duration) (LeaseInfo, error) {
+	if ttl < time.Second {
+		return LeaseInfo{}, ErrInvalidTTL
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.nextLeaseID++
+	now := time.Now()
+	l := &lease{
+		id:      db.nextLeaseID,
+		ttl:     ttl,
+		expires: now.Add(ttl),
+		keys:    make(map[string]struct{}),
+		locks:   make(map[string]struct{}),
+	}
+	if p := PrincipalFromContext(ctx); p != nil {
+		l.owner = p.Name
+	}
+	db.leases[l.id] = l
+
+	return l.info(now), nil
+}
+
+// KeepAlive renews a lease for another full TTL
+func (db *DB) KeepAlive(id int64) (LeaseInfo, error) {
+	return db.KeepAliveContext(context.Background(), id)
+}
+
+// KeepAliveContext is KeepAlive limited to the leases the client in ctx
+// may use
+func (db *DB) KeepAliveContext(ctx context.Context, id int64) (LeaseInfo, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	now := time.Now()
+	l, err := db.usableLeaseLocked(ctx, id, now)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+	l.expires = now.Add(l.ttl)
+
+	return l.info(now), nil
+}
+
+// GetLease returns the current state of a lease
+func (db *DB) GetLease(id int64) (LeaseInfo, error) {
+	return db.GetLeaseContext(context.Background(), id)
+}
+
+// GetLeaseContext is GetLease limited to the leases the client in ctx may
+// use
+func (db *DB) GetLeaseContext(ctx context.Context, id int64) (LeaseInfo, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	now := time.Now()
+	l, err := db.usableLeaseLocked(ctx, id, now)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+
+	return l.info(now), nil
+}
+
+// RevokeLease ends a lease immediately, deleting its keys and releasing
+// its locks
+func (db *DB) RevokeLease(id int64) error {
+	return db.RevokeLeaseContext(context.Background(), id)
+}
+
+// RevokeLeaseContext is RevokeLease limited to the leases the client in
+// ctx may use
+func (db *DB) RevokeLeaseContext(ctx context.Context, id int64) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	l, err := db.usableLeaseLocked(ctx, id, time.Now())
+	if err != nil {
+		return err
+	}
+	db.revokeLocked(ctx, AuditRevoke, l)
+
+	return nil
+}
+
+// SetWithLease stores a key-value pair and attaches it to a lease, so the
+// key is deleted when the lease ends
+func (db *DB) SetWithLease(key string, value string, leaseID int64) error {
+	return db.SetWithLeaseContext(context.Background(), key, value, leaseID)
+}
+
+// SetWithLeaseContext is SetWithLease with a context for tracing and
+// auditing
+func (db *DB) SetWithLeaseContext(ctx context.Context, key string, value string, leaseID int64) error {
+	ctx, span := tracer.Start(ctx, "DB.SetWithLease")
+	defer span.End()
+	if err := db.checkWriteKey(key); err != nil {
+		return err
+	}
+
+	db.mutex.LockContext(ctx)
+	defer db.mutex.Unlock()
+
+	l, err := db.usableLeaseLocked(ctx, leaseID, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := db.roomLocked(key); err != nil {
+		return err
+	}
+
+	db.overwriteLocked(key, value)
+	l.keys[key] = struct{}{}
+	db.keyLeases[key] = l.id
+	db.auditLocked(ctx, AuditSet, key, &value)
+	atomic.AddInt64(&db.metrics.SetOps, 1)
+
+	return nil
+}
+
+// Lock acquires a named lock on behalf of a lease. Acquiring a lock the
+// lease already holds returns the existing fencing token.
+func (db *DB) Lock(name string, leaseID int64) (LockInfo, error) {
+	return db.LockContext(context.Background(), name, leaseID)
+}
+
+// LockContext is Lock limited to the leases the client in ctx may use
+func (db *DB) LockContext(ctx context.Context, name string, leaseID int64) (LockInfo, error) {
+	if name == "" {
+		return LockInfo{}, ErrEmptyLockName
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	now := time.Now()
+	l, err := db.usableLeaseLocked(ctx, leaseID, now)
+	if err != nil {
+		return LockInfo{}, err
+	}
+
+	if held, ok := db.locks[name]; ok {
+		if held.leaseID == leaseID {
+			return LockInfo{Name: name, Token: held.token, LeaseID: leaseID}, nil
+		}
+		// The holder may have expired without the reaper noticing yet
+		if _, err := db.liveLeaseLocked(held.leaseID, now); err == nil {
+			return LockInfo{}, ErrLockHeld
+		}
+	}
+
+	db.fenceToken++
+	db.locks[name] = &lockEntry{leaseID: leaseID, token: db.fenceToken}
+	l.locks[name] = struct{}{}
+
+	return LockInfo{Name: name, Token: db.fenceToken, LeaseID: leaseID}, nil
+}
+
+// Unlock releases a named lock. The fencing token must match the one
+// returned when the lock was acquired.
+func (db *DB) Unlock(name string, token int64) error {
+	if name == "" {
+		return ErrEmptyLockName
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	held, ok := db.locks[name]
+	if !ok || held.token != token {
+		return ErrLockNotHeld
+	}
+
+	delete(db.locks, name)
+	if l, ok := db.leases[held.leaseID]; ok {
+		delete(l.locks, name)
+	}
+
+	return nil
+}
+
+// liveLeaseLocked looks up a lease, revoking it first if it has expired.
+// The caller must hold the write lock.
+func (db *DB) liveLeaseLocked(id int64, now time.Time) (*lease, error) {
+	l, ok := db.leases[id]
+	if !ok {
+		return nil, ErrLeaseNotFound
+	}
+	if !now.Before(l.expires) {
+		db.revokeLocked(systemContext, AuditExpire, l)
+		return nil, ErrLeaseNotFound
+	}
+	return l, nil
+}
+
+// usableLeaseLocked looks up a live lease the client in ctx may use. A
+// token limited to key prefixes may only use the leases it granted, since
+// revoking a lease deletes every key attached to it; other leases are
+// reported as not found. The caller must hold the write lock.
+func (db *DB) usableLeaseLocked(ctx context.Context, id int64, now time.Time) (*lease, error) {
+	l, err := db.liveLeaseLocked(id, now)
+	if err != nil {
+		return nil, err
+	}
+	if p := PrincipalFromContext(ctx); p != nil && p.scoped() && l.owner != p.Name {
+		return nil, ErrLeaseNotFound
+	}
+	return l, nil
+}
+
+// revokeLocked removes a lease along with its keys and locks, auditing the
+// deleted keys as op. The caller must hold the write lock.
+func (db *DB) revokeLocked(ctx context.Context, op string, l *lease) {
+	delete(db.leases, l.id)
+
+	deleted := int64(0)
+	for key := range l.keys {
+		if _, ok := db.removeLocked(key); ok {
+			db.auditLocked(ctx, op, key, nil)
+			deleted++
+		}
+	}
+	atomic.AddInt64(&db.metrics.DelOps, deleted)
+
+	for name := range l.locks {
+		if held, ok := db.locks[name]; ok && held.leaseID == l.id {
+			delete(db.locks, name)
+		}
+	}
+}
+
+// detachKeyLocked removes a key from whichever lease it was attached to.
+// The caller must hold the write lock.
+func (db *DB) detachKeyLocked(key string) {
+	id, ok := db.keyLeases[key]
+	if !ok {
+		return
+	}
+	delete(db.keyLeases, key)
+	if l, ok := db.leases[id]; ok {
+		delete(l.keys, key)
+	}
+}
+
+// expireLeases revokes every lease that has expired as of now and returns
+// how many were revoked
+func (db *DB) expireLeases(now time.Time) int {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	expired := 0
+	for _, l := range db.leases {
+		if !now.Before(l.expires) {
+			db.revokeLocked(systemContext, AuditExpire, l)
+			expired++
+		}
+	}
+	return expired
+}
+
+// runExpiry periodically revokes expired leases and removes expired keys
+// until ctx is done
+func (db *DB) runExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			db.expireLeases(now)
+			db.expireKeys(now)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package kvd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// leaseRequest is the body accepted when granting a lease
+type leaseRequest struct {
+	TTL int64 `json:"TTL"`
+}
+
+// lockRequest is the body accepted when acquiring a lock
+type lockRequest struct {
+	LeaseID int64 `json:"LeaseID"`
+}
+
+// leaseErrorStatus maps lease and lock errors to HTTP status codes
+func leaseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrLeaseNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrLockHeld), errors.Is(err, ErrLockNotHeld):
+		return http.StatusConflict
+	case errors.Is(err, ErrInvalidTTL), errors.Is(err, ErrEmptyKey), errors.Is(err, ErrInvalidKey), errors.Is(err, ErrEmptyLockName):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrReservedKey):
+		return http.StatusForbidden
+	case errors.Is(err, ErrStoreFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON encodes obj as the JSON response body with the given status
+func (kvd *Kvd) writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	body, err := kvd.toJSON(obj)
+	if err != nil {
+		kvd.logger.Error("Error encoding response", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		kvd.logger.Error("Error writing response", "err", err)
+	}
+}
+
+// leaseIDVar parses the {id} route variable
+func leaseIDVar(r *http.Request) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+}
+
+// leaseGrantHandler handles requests to grant a new lease
+func (kvd *Kvd) leaseGrantHandler(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+
+	body, ok := kvd.readBody(w, r, kvd.settings().limits.maxBodySize, ErrBodyTooLarge)
+	if !ok {
+		return
+	}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	info, err := kvd.db.GrantLeaseContext(r.Context(), time.Duration(req.TTL) * time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), leaseErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusCreated, info)
+}
+
+// leaseGetHandler handles requests for the state of a lease
+func (kvd *Kvd) leaseGetHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := leaseIDVar(r)
+	if err != nil {
+		http.Error(w, "Invalid lease ID", http.StatusBadRequest)
+		return
+	}
+
+	info, err := kvd.db.GetLeaseContext(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), leaseErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, info)
+}
+
+// leaseKeepAliveHandler handles requests to renew a lease
+func (kvd *Kvd) leaseKeepAliveHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := leaseIDVar(r)
+	if err != nil {
+		http.Error(w, "Invalid lease ID", http.StatusBadRequest)
+		return
+	}
+
+	info, err := kvd.db.KeepAliveContext(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), leaseErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, info)
+}
+
+// leaseRevokeHandler handles requests to revoke a lease
+func (kvd *Kvd) leaseRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := leaseIDVar(r)
+	if err != nil {
+		http.Error(w, "Invalid lease ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := kvd.db.RevokeLeaseContext(r.Context(), id); err != nil {
+		http.Error(w, err.Error(), leaseErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// lockAcquireHandler handles requests to acquire a named lock. It does not
+// block; a held lock is reported with 409 Conflict so clients can retry.
+func (kvd *Kvd) lockAcquireHandler(w http.ResponseWriter, r *http.Request) {
+	var req lockRequest
+	name := mux.Vars(r)["name"]
+
+	body, ok := kvd.readBody(w, r, kvd.settings().limits.maxBodySize, ErrBodyTooLarge)
+	if !ok {
+		return
+	}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	info, err := kvd.db.LockContext(r.Context(), name, req.LeaseID)
+	if err != nil {
+		http.Error(w, err.Error(), leaseErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, info)
+}
+
+// lockReleaseHandler handles requests to release a named lock
+func (kvd *Kvd) lockReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	token, err := strconv.ParseInt(r.URL.Query().Get("token"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid fencing token", http.StatusBadRequest)
+		return
+	}
+
+	if err := kvd.db.Unlock(name, token); err != nil {
+		http.Error(w, err.Error(), leaseErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
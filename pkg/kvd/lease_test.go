@@ -0,0 +1,101 @@
+package kvd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db := &DB{}
+	if err := db.Init(); err != nil {
+		t.Fatalf("Failed to init database: %v", err)
+	}
+	return db
+}
+
+func TestLeaseExpiryDeletesKeys(t *testing.T) {
+	db := newTestDB(t)
+
+	lease, err := db.GrantLease(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to grant lease: %v", err)
+	}
+	if err := db.SetWithLease("session", "abc", lease.ID); err != nil {
+		t.Fatalf("Failed to set key with lease: %v", err)
+	}
+	if err := db.Set("plain", "value"); err != nil {
+		t.Fatalf("Failed to set key: %v", err)
+	}
+
+	if n := db.expireLeases(time.Now().Add(2 * time.Second)); n != 1 {
+		t.Errorf("Expected 1 expired lease, got %d", n)
+	}
+
+	if _, err := db.Get("session"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected leased key to be deleted, got %v", err)
+	}
+	if _, err := db.Get("plain"); err != nil {
+		t.Errorf("Expected unleased key to survive, got %v", err)
+	}
+	if db.metrics.KeysStored != 1 {
+		t.Errorf("Expected KeysStored to be 1, got %d", db.metrics.KeysStored)
+	}
+	if _, err := db.KeepAlive(lease.ID); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("Expected expired lease to be gone, got %v", err)
+	}
+}
+
+func TestSetDetachesKeyFromLease(t *testing.T) {
+	db := newTestDB(t)
+
+	lease, _ := db.GrantLease(time.Minute)
+	db.SetWithLease("k", "v1", lease.ID)
+	db.Set("k", "v2")
+
+	if err := db.RevokeLease(lease.ID); err != nil {
+		t.Fatalf("Failed to revoke lease: %v", err)
+	}
+	if value, err := db.Get("k"); err != nil || value != "v2" {
+		t.Errorf("Expected overwritten key to survive revoke, got %q, %v", value, err)
+	}
+}
+
+func TestLockFencing(t *testing.T) {
+	db := newTestDB(t)
+
+	first, _ := db.GrantLease(time.Minute)
+	second, _ := db.GrantLease(time.Minute)
+
+	held, err := db.Lock("job", first.ID)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	again, err := db.Lock("job", first.ID)
+	if err != nil || again.Token != held.Token {
+		t.Errorf("Expected re-acquire to return token %d, got %d, %v", held.Token, again.Token, err)
+	}
+
+	if _, err := db.Lock("job", second.ID); !errors.Is(err, ErrLockHeld) {
+		t.Errorf("Expected ErrLockHeld, got %v", err)
+	}
+
+	if err := db.Unlock("job", held.Token+1); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("Expected ErrLockNotHeld for wrong token, got %v", err)
+	}
+
+	// Revoking the holder's lease releases the lock
+	if err := db.RevokeLease(first.ID); err != nil {
+		t.Fatalf("Failed to revoke lease: %v", err)
+	}
+
+	next, err := db.Lock("job", second.ID)
+	if err != nil {
+		t.Fatalf("Failed to acquire released lock: %v", err)
+	}
+	if next.Token <= held.Token {
+		t.Errorf("Expected fencing token to increase past %d, got %d", held.Token, next.Token)
+	}
+}
@@ -0,0 +1,163 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Default request limits, used for zero values in Config
+const (
+	DefaultMaxKeyLength   = 1024
+	DefaultMaxValueSize   = 1 << 20
+	DefaultMaxBulkRecords = 10000
+	DefaultMaxBodySize    = 8 << 20
+)
+
+var (
+	// ErrKeyTooLong is returned for keys longer than the maximum key length
+	ErrKeyTooLong = errors.New("key too long")
+	// ErrValueTooLarge is returned for values over the maximum value size
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrTooManyRecords is returned for bulk requests over the maximum
+	// record count
+	ErrTooManyRecords = errors.New("too many records")
+	// ErrBodyTooLarge is returned for request bodies over the maximum body
+	// size
+	ErrBodyTooLarge = errors.New("request body too large")
+	// ErrLimitConfig is returned for a negative limit
+	ErrLimitConfig = errors.New("invalid request limit")
+	// ErrStoreFull is returned for writes that would add keys beyond the
+	// maximum record count
+	ErrStoreFull = errors.New("store is full")
+)
+
+// requestLimits bounds what a single request may write
+type requestLimits struct {
+	maxKeyLength   int
+	maxValueSize   int
+	maxBulkRecords int
+	maxBodySize    int64
+}
+
+// newRequestLimits reads the limits from config, using the defaults for
+// zero values
+func newRequestLimits(config *Config) (requestLimits, error) {
+	if config.MaxKeyLength < 0 || config.MaxValueSize < 0 || config.MaxBulkRecords < 0 || config.MaxBodySize < 0 {
+		return requestLimits{}, fmt.Errorf("%w: limits cannot be negative", ErrLimitConfig)
+	}
+
+	limits := requestLimits{
+		maxKeyLength:   config.MaxKeyLength,
+		maxValueSize:   config.MaxValueSize,
+		maxBulkRecords: config.MaxBulkRecords,
+		maxBodySize:    config.MaxBodySize,
+	}
+	if limits.maxKeyLength == 0 {
+		limits.maxKeyLength = DefaultMaxKeyLength
+	}
+	if limits.maxValueSize == 0 {
+		limits.maxValueSize = DefaultMaxValueSize
+	}
+	if limits.maxBulkRecords == 0 {
+		limits.maxBulkRecords = DefaultMaxBulkRecords
+	}
+	if limits.maxBodySize == 0 {
+		limits.maxBodySize = DefaultMaxBodySize
+	}
+	return limits, nil
+}
+
+// checkKey returns ErrKeyTooLong for a key over the limit
+func (l requestLimits) checkKey(key string) error {
+	if len(key) > l.maxKeyLength {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrKeyTooLong, len(key), l.maxKeyLength)
+	}
+	return nil
+}
+
+// checkRecord checks the key and value of a record to be written
+func (l requestLimits) checkRecord(record Record) error {
+	if err := l.checkKey(record.Key); err != nil {
+		return err
+	}
+	if len(record.Value) > l.maxValueSize {
+		return fmt.Errorf("%w: %d bytes for key %q, the limit is %d", ErrValueTooLarge, len(record.Value), record.Key, l.maxValueSize)
+	}
+	return nil
+}
+
+// checkCount returns ErrTooManyRecords for a bulk request over the limit
+func (l requestLimits) checkCount(n int) error {
+	if n > l.maxBulkRecords {
+		return fmt.Errorf("%w: %d, the limit is %d", ErrTooManyRecords, n, l.maxBulkRecords)
+	}
+	return nil
+}
+
+// SetMaxRecords caps the number of keys the store holds; writes of new keys
+// beyond it fail with ErrStoreFull. Zero means no limit. Keys already stored
+// are kept when the cap is lowered.
+func (db *DB) SetMaxRecords(n int) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.maxRecords = n
+}
+
+// roomLocked returns ErrStoreFull if storing keys would take the store over
+// its maximum record count. The caller must hold the write lock.
+func (db *DB) roomLocked(keys ...string) error {
+	if db.maxRecords == 0 {
+		return nil
+	}
+	added := 0
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if _, ok := db.store[key]; !ok {
+			added++
+		}
+	}
+	return db.roomForLocked(added)
+}
+
+// roomForLocked returns ErrStoreFull if the store cannot take added more
+// keys. The caller must hold the write lock.
+func (db *DB) roomForLocked(added int) error {
+	if db.maxRecords == 0 || added <= 0 || len(db.store)+added <= db.maxRecords {
+		return nil
+	}
+	return fmt.Errorf("%w: %d keys stored, the limit is %d", ErrStoreFull, len(db.store), db.maxRecords)
+}
+
+// limitErrorStatus maps a limit error to an HTTP status code: 400 for a
+// malformed request, 413 for one that is too large
+func limitErrorStatus(err error) int {
+	if errors.Is(err, ErrKeyTooLong) {
+		return http.StatusBadRequest
+	}
+	return http.StatusRequestEntityTooLarge
+}
+
+// readBody reads the request body up to limit bytes. A longer body is
+// rejected with 413 and tooLarge rather than cut short.
+func (kvd *Kvd) readBody(w http.ResponseWriter, r *http.Request, limit int64, tooLarge error) ([]byte, bool) {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			http.Error(w, fmt.Sprintf("%v: the limit is %d bytes", tooLarge, limit), http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		kvd.log(r).Error("Error reading request body", "err", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
@@ -0,0 +1,134 @@
+package kvd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/drewnix/kvd/pkg/kvdpb"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newLimitedTestKvd(t *testing.T) *Kvd {
+	t.Helper()
+	kvd := &Kvd{}
+	if err := kvd.Init(&Config{MaxKeyLength: 8, MaxValueSize: 16, MaxBulkRecords: 2, MaxBodySize: 128}); err != nil {
+		t.Fatalf("Failed to init server: %v", err)
+	}
+	kvd.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return kvd
+}
+
+func TestRequestLimits(t *testing.T) {
+	kvd := newLimitedTestKvd(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/_mget", kvd.mgetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_mset", kvd.msetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/{key}", kvd.keyPutHandler).Methods(http.MethodPut)
+
+	for _, tc := range []struct {
+		method, path, body string
+		want               int
+		message            error
+	}{
+		{http.MethodPut, "/v1/" + strings.Repeat("k", 8), strings.Repeat("v", 16), http.StatusCreated, nil},
+		{http.MethodPut, "/v1/" + strings.Repeat("k", 9), "v", http.StatusBadRequest, ErrKeyTooLong},
+		{http.MethodPut, "/v1/a", strings.Repeat("v", 17), http.StatusRequestEntityTooLarge, ErrValueTooLarge},
+		{http.MethodPost, "/v1/_mget", `["a","b","c"]`, http.StatusRequestEntityTooLarge, ErrTooManyRecords},
+		{http.MethodPost, "/v1/_mset", `[{"Key":"a","Value":"` + strings.Repeat("v", 17) + `"}]`, http.StatusRequestEntityTooLarge, ErrValueTooLarge},
+		{http.MethodPost, "/v1/_mset", `[{"Key":"` + strings.Repeat("k", 9) + `","Value":"v"}]`, http.StatusBadRequest, ErrKeyTooLong},
+		// An oversize body is reported, not cut into invalid JSON
+		{http.MethodPost, "/v1/_mget", `["` + strings.Repeat("k", 200) + `"]`, http.StatusRequestEntityTooLarge, ErrBodyTooLarge},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d: %s", tc.method, tc.path, tc.want, rec.Code, rec.Body)
+		}
+		if tc.message != nil && !strings.Contains(rec.Body.String(), tc.message.Error()) {
+			t.Errorf("%s %s: expected %q, got %q", tc.method, tc.path, tc.message, rec.Body)
+		}
+	}
+
+	// Imports check each record
+	body := "{\"Key\":\"a\",\"Value\":\"1\"}\n{\"Key\":\"b\",\"Value\":\"" + strings.Repeat("v", 17) + "\"}\n"
+	rec := httptest.NewRecorder()
+	kvd.importHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_import", strings.NewReader(body)))
+	var progress ImportProgress
+	json.Unmarshal(rec.Body.Bytes(), &progress)
+	if progress.Done || !strings.Contains(progress.Error, "record 2: "+ErrValueTooLarge.Error()) {
+		t.Errorf("Expected the import to stop at the large value, got %+v", progress)
+	}
+}
+
+func TestGRPCRequestLimits(t *testing.T) {
+	kvd := newLimitedTestKvd(t)
+	client := newTestGRPCClient(t, kvd)
+	ctx := context.Background()
+
+	if _, err := client.Set(ctx, &kvdpb.SetRequest{Key: strings.Repeat("k", 9), Value: "v"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a long key, got %v", err)
+	}
+	if _, err := client.Set(ctx, &kvdpb.SetRequest{Key: "a", Value: strings.Repeat("v", 17)}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted for a large value, got %v", err)
+	}
+	if _, err := client.BulkDelete(ctx, &kvdpb.BulkDeleteRequest{Keys: []string{"a", "b", "c"}}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted for too many keys, got %v", err)
+	}
+}
+
+func TestRequestLimitConfig(t *testing.T) {
+	if _, err := newRequestLimits(&Config{MaxValueSize: -1}); !errors.Is(err, ErrLimitConfig) {
+		t.Errorf("Expected ErrLimitConfig, got %v", err)
+	}
+	limits, err := newRequestLimits(&Config{})
+	if err != nil || limits.maxKeyLength != DefaultMaxKeyLength || limits.maxBodySize != DefaultMaxBodySize {
+		t.Errorf("Expected the defaults, got %+v %v", limits, err)
+	}
+}
+
+func TestMaxRecords(t *testing.T) {
+	db := newTestDB(t)
+	db.SetMaxRecords(2)
+
+	db.Set("a", "1")
+	db.Set("b", "2")
+	if err := db.Set("c", "3"); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull, got %v", err)
+	}
+	if err := db.Set("a", "9"); err != nil {
+		t.Errorf("Expected existing keys to stay writable, got %v", err)
+	}
+	if err := db.BulkSet([]Record{{Key: "a", Value: "1"}, {Key: "c", Value: "3"}}); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull from a bulk set, got %v", err)
+	}
+	if _, err := db.Incr("n", 1); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull from incr, got %v", err)
+	}
+
+	// A script that deletes a key may add another
+	hash, _ := db.LoadScript(`del(KEYS[0]); set(KEYS[1], "x")`)
+	if _, err := db.RunScript(hash, []string{"b", "c"}, nil, ScriptLimits{}); err != nil {
+		t.Errorf("Expected the script to fit, got %v", err)
+	}
+	hash, _ = db.LoadScript(`set(KEYS[0], "x")`)
+	if _, err := db.RunScript(hash, []string{"d"}, nil, ScriptLimits{}); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull from a script, got %v", err)
+	}
+
+	db.Delete("a")
+	if err := db.Set("c2", "1"); err != nil {
+		t.Errorf("Expected room after a delete, got %v", err)
+	}
+	if status := keyErrorStatus(ErrStoreFull); status != http.StatusInsufficientStorage {
+		t.Errorf("Expected 507, got %d", status)
+	}
+}
@@ -0,0 +1,179 @@
+package kvd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Key redaction modes for logs
+const (
+	// LogKeysPlain logs keys as they are
+	LogKeysPlain = "plain"
+	// LogKeysHash logs a short SHA-256 of each key, so repeated keys can
+	// still be correlated
+	LogKeysHash = "hash"
+	// LogKeysRedact replaces keys with a placeholder
+	LogKeysRedact = "redact"
+)
+
+// RequestIDHeader carries the request ID. A valid ID sent by the client is
+// kept; otherwise one is generated. It is echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-supplied request IDs
+const maxRequestIDLength = 128
+
+// ErrInvalidLogLevel is returned for an unknown log level
+var ErrInvalidLogLevel = errors.New("invalid log level")
+
+// LogLevel is the body of the log level admin endpoint
+type LogLevel struct {
+	Level string `json:"Level"`
+}
+
+type requestIDKey struct{}
+
+// newLogger creates the server's logger from the logging settings in
+// config. The returned level can be changed while the server runs.
+func newLogger(w io.Writer, config *Config) (*slog.Logger, *slog.LevelVar, error) {
+	level := &slog.LevelVar{}
+	if config.LogLevel != "" {
+		if err := level.UnmarshalText([]byte(config.LogLevel)); err != nil {
+			return nil, nil, fmt.Errorf("%w %q", ErrInvalidLogLevel, config.LogLevel)
+		}
+	}
+
+	switch config.LogKeys {
+	case "", LogKeysPlain, LogKeysHash, LogKeysRedact:
+	default:
+		return nil, nil, fmt.Errorf("log keys must be %s, %s or %s", LogKeysPlain, LogKeysHash, LogKeysRedact)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch config.LogFormat {
+	case "", LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), level, nil
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), level, nil
+	default:
+		return nil, nil, fmt.Errorf("log format must be %s or %s", LogFormatText, LogFormatJSON)
+	}
+}
+
+// redactKey returns key as it should appear in logs
+func (kvd *Kvd) redactKey(key string) string {
+	switch kvd.settings().config.LogKeys {
+	case LogKeysHash:
+		sum := sha256.Sum256([]byte(key))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case LogKeysRedact:
+		return "[redacted]"
+	default:
+		return key
+	}
+}
+
+// log returns the logger for a request, tagged with its request ID
+func (kvd *Kvd) log(r *http.Request) *slog.Logger {
+	if id, ok := r.Context().Value(requestIDKey{}).(string); ok {
+		return kvd.logger.With("request_id", id)
+	}
+	return kvd.logger
+}
+
+// RequestID returns the request ID stored in ctx by the server
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID returns the client's request ID if it is usable, or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); id != "" && len(id) <= maxRequestIDLength {
+		printable := strings.IndexFunc(id, func(c rune) bool { return c < '!' || c > '~' }) < 0
+		if printable {
+			return id
+		}
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequests is router middleware that assigns each request an ID, records
+// its caller for auditing and writes an access log line when it completes.
+// Unless keys are logged plainly, the path is logged as its route with the
+// key redacted.
+func (kvd *Kvd) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = WithCaller(ctx, Caller{Identity: clientIdentity(r), Remote: r.RemoteAddr, RequestID: id})
+		r = r.WithContext(ctx)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		if !kvd.logger.Enabled(r.Context(), slog.LevelInfo) {
+			return
+		}
+		attrs := []any{
+			"method", r.Method,
+			"route", routeTemplate(r),
+			"status", recorder.status,
+			"bytes", recorder.bytes,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+		}
+		if logKeys := kvd.settings().config.LogKeys; logKeys == "" || logKeys == LogKeysPlain {
+			attrs = append(attrs, "path", r.URL.RequestURI())
+		} else if key, ok := mux.Vars(r)["key"]; ok {
+			attrs = append(attrs, "key", kvd.redactKey(key))
+		}
+		kvd.log(r).Info("request", attrs...)
+	})
+}
+
+// logLevelHandler reports the current log level
+func (kvd *Kvd) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	kvd.writeJSON(w, http.StatusOK, LogLevel{Level: kvd.logLevel.Level().String()})
+}
+
+// logLevelSetHandler changes the log level of the running server
+func (kvd *Kvd) logLevelSetHandler(w http.ResponseWriter, r *http.Request) {
+	var body LogLevel
+	if !kvd.decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(body.Level)); err != nil {
+		http.Error(w, fmt.Sprintf("%v %q", ErrInvalidLogLevel, body.Level), http.StatusBadRequest)
+		return
+	}
+
+	previous := kvd.logLevel.Level()
+	kvd.logLevel.Set(level)
+	kvd.log(r).Warn("Log level changed", "from", previous.String(), "to", level.String())
+	kvd.writeJSON(w, http.StatusOK, LogLevel{Level: level.String()})
+}
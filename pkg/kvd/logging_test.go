@@ -0,0 +1,108 @@
+package kvd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNewLogger(t *testing.T) {
+	for _, config := range []Config{
+		{LogLevel: "loud"},
+		{LogFormat: "xml"},
+		{LogKeys: "encrypt"},
+	} {
+		if _, _, err := newLogger(&bytes.Buffer{}, &config); err == nil {
+			t.Errorf("Expected an error for %+v", config)
+		}
+	}
+
+	_, _, err := newLogger(&bytes.Buffer{}, &Config{LogLevel: "verbose"})
+	if !errors.Is(err, ErrInvalidLogLevel) {
+		t.Errorf("Expected ErrInvalidLogLevel, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger, level, err := newLogger(&buf, &Config{LogLevel: "warn", LogFormat: LogFormatJSON})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown")
+	level.Set(-4)
+	logger.Debug("debug")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), `"msg":"shown"`) ||
+		!strings.Contains(buf.String(), `"msg":"debug"`) {
+		t.Errorf("Unexpected log output %q", buf.String())
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.config.LogKeys = LogKeysHash
+	var buf bytes.Buffer
+	kvd.logger, kvd.logLevel, _ = newLogger(&buf, kvd.config)
+	kvd.db.Set("secret", "1")
+
+	router := mux.NewRouter()
+	router.Use(kvd.logRequests)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/secret", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Errorf("Expected request ID to be echoed, got %q", rec.Header().Get(RequestIDHeader))
+	}
+	line := buf.String()
+	for _, want := range []string{"request_id=abc-123", "route=/v1/{key}", "status=200", "bytes=1", "key=sha256:"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in access log %q", want, line)
+		}
+	}
+	if strings.Contains(line, "secret") {
+		t.Errorf("Expected key to be redacted in %q", line)
+	}
+
+	// Unprintable request IDs are replaced
+	req = httptest.NewRequest(http.MethodGet, "/v1/secret", nil)
+	req.Header.Set(RequestIDHeader, "bad id")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); id == "" || id == "bad id" {
+		t.Errorf("Expected a generated request ID, got %q", id)
+	}
+}
+
+func TestLogLevelHandlers(t *testing.T) {
+	kvd := newTestKvd(t)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"Level":"debug"}`))
+	kvd.logLevelSetHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.logLevelHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/loglevel", nil))
+	var level LogLevel
+	if err := json.Unmarshal(rec.Body.Bytes(), &level); err != nil || level.Level != "DEBUG" {
+		t.Errorf("Expected DEBUG, got %q", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPut, "/admin/loglevel", strings.NewReader(`{"Level":"loud"}`))
+	kvd.logLevelSetHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown level, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,373 @@
+package kvd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on incoming memcached commands
+const (
+	maxMemcacheKeyBytes   = 250
+	maxMemcacheValueBytes = 1048576
+	maxMemcacheLineBytes  = 64 * 1024
+
+	// memcacheRelativeLimit is the largest exptime treated as a number of
+	// seconds; larger values are absolute Unix timestamps
+	memcacheRelativeLimit = 60 * 60 * 24 * 30
+)
+
+// Memcached protocol replies
+const (
+	mcError        = "ERROR"
+	mcBadFormat    = "CLIENT_ERROR bad command line format"
+	mcBadChunk     = "CLIENT_ERROR bad data chunk"
+	mcTooLarge     = "SERVER_ERROR object too large for cache"
+	mcNonNumeric   = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	mcInvalidDelta = "CLIENT_ERROR invalid numeric delta argument"
+	mcStored       = "STORED"
+	mcNotStored    = "NOT_STORED"
+	mcExists       = "EXISTS"
+	mcNotFound     = "NOT_FOUND"
+	mcDeleted      = "DELETED"
+	mcTouched      = "TOUCHED"
+	mcEnd          = "END"
+)
+
+// errMemcacheLineTooLong is returned when a command line exceeds the limit
+var errMemcacheLineTooLong = errors.New("line too long")
+
+// memcacheConn serves one memcached text protocol client
+type memcacheConn struct {
+	kvd     *Kvd
+	r       *bufio.Reader
+	w       *bufio.Writer
+	remote  string
+	noreply bool
+	quit    bool
+}
+
+// serveMemcache handles memcached text protocol commands on conn until it
+// closes
+func (kvd *Kvd) serveMemcache(conn net.Conn) {
+	c := &memcacheConn{
+		kvd: kvd,
+		r:   bufio.NewReaderSize(conn, maxMemcacheLineBytes),
+		w:   bufio.NewWriter(conn),
+
+		remote: conn.RemoteAddr().String(),
+	}
+
+	for !c.quit {
+		line, err := c.readLine()
+		if err != nil {
+			if errors.Is(err, errMemcacheLineTooLong) {
+				c.w.WriteString("CLIENT_ERROR line too long\r\n")
+				c.w.Flush()
+			}
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			c.reply(mcError)
+		} else {
+			c.dispatch(fields)
+		}
+
+		// Batch replies to pipelined commands into one write
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	c.w.Flush()
+}
+
+// context returns the context for the connection's database calls
+func (c *memcacheConn) context() context.Context {
+	return WithCaller(context.Background(), Caller{Remote: c.remote})
+}
+
+// readLine reads a CRLF-terminated line without the terminator
+func (c *memcacheConn) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errMemcacheLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply writes a single-line reply unless the command asked for noreply
+func (c *memcacheConn) reply(s string) {
+	if c.noreply {
+		return
+	}
+	c.w.WriteString(s + "\r\n")
+}
+
+// takeNoreply strips a trailing noreply argument
+func (c *memcacheConn) takeNoreply(args []string) []string {
+	c.noreply = len(args) > 0 && args[len(args)-1] == "noreply"
+	if c.noreply {
+		return args[:len(args)-1]
+	}
+	return args
+}
+
+// dispatch runs a single command
+func (c *memcacheConn) dispatch(fields []string) {
+	cmd := fields[0]
+	args := fields[1:]
+	c.noreply = false
+
+	switch cmd {
+	case "get", "gets":
+		c.get(args, cmd == "gets")
+
+	case "set", "add", "replace", "cas":
+		c.store(cmd, args)
+
+	case "delete":
+		c.delete(args)
+
+	case "incr", "decr":
+		c.incr(args, cmd == "decr")
+
+	case "touch":
+		c.touch(args)
+
+	case "version":
+		c.reply("VERSION " + c.kvd.status.Version)
+
+	case "verbosity":
+		c.takeNoreply(args)
+		c.reply("OK")
+
+	case "quit":
+		c.quit = true
+
+	default:
+		c.reply(mcError)
+	}
+}
+
+// get writes a VALUE block for every key that exists
+func (c *memcacheConn) get(keys []string, withVersion bool) {
+	if len(keys) == 0 {
+		c.reply(mcError)
+		return
+	}
+
+	db := &c.kvd.db
+	for _, key := range keys {
+		if len(key) > maxMemcacheKeyBytes {
+			c.reply(mcBadFormat)
+			return
+		}
+		value, meta, err := db.GetWithMeta(key)
+		if err != nil {
+			continue
+		}
+		if withVersion {
+			fmt.Fprintf(c.w, "VALUE %s %d %d %d\r\n", key, meta.Flags, len(value), meta.Version)
+		} else {
+			fmt.Fprintf(c.w, "VALUE %s %d %d\r\n", key, meta.Flags, len(value))
+		}
+		c.w.WriteString(value)
+		c.w.WriteString("\r\n")
+	}
+	c.reply(mcEnd)
+}
+
+// store handles set, add, replace and cas. Once the command line parses,
+// the data block is always consumed so the connection stays in sync.
+func (c *memcacheConn) store(cmd string, args []string) {
+	args = c.takeNoreply(args)
+
+	want := 4
+	if cmd == "cas" {
+		want = 5
+	}
+	if len(args) != want {
+		c.noreply = false
+		c.reply(mcBadFormat)
+		return
+	}
+
+	key := args[0]
+	flags, errFlags := strconv.ParseUint(args[1], 10, 32)
+	exptime, errExp := strconv.ParseInt(args[2], 10, 64)
+	size, errSize := strconv.Atoi(args[3])
+	if errFlags != nil || errExp != nil || errSize != nil || size < 0 || len(key) > maxMemcacheKeyBytes {
+		c.noreply = false
+		c.reply(mcBadFormat)
+		return
+	}
+
+	if size > maxMemcacheValueBytes {
+		c.reply(mcTooLarge)
+		if _, err := io.CopyN(io.Discard, c.r, int64(size)+2); err != nil {
+			c.quit = true
+		}
+		return
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		c.quit = true
+		return
+	}
+	if string(data[size:]) != "\r\n" {
+		c.reply(mcBadChunk)
+		return
+	}
+
+	opts := SetOptions{
+		Flags:         uint32(flags),
+		OnlyIfAbsent:  cmd == "add",
+		OnlyIfPresent: cmd == "replace",
+	}
+	if cmd == "cas" {
+		version, err := strconv.ParseUint(args[4], 10, 64)
+		if err != nil {
+			c.noreply = false
+			c.reply(mcBadFormat)
+			return
+		}
+		opts.CompareVersion = version
+	}
+
+	ttl, expired := memcacheTTL(exptime, time.Now())
+	if expired {
+		// An item stored with an exptime in the past is never visible, so
+		// store it already expired
+		ttl = time.Nanosecond
+	}
+	opts.TTL = ttl
+
+	stored, err := c.kvd.db.SetWithOptionsContext(c.context(), key, string(data[:size]), opts)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		c.reply(mcNotFound)
+	case errors.Is(err, ErrVersionMismatch):
+		c.reply(mcExists)
+	case err != nil:
+		c.reply("SERVER_ERROR " + err.Error())
+	case !stored:
+		c.reply(mcNotStored)
+	default:
+		c.reply(mcStored)
+	}
+}
+
+// delete removes a key. A trailing zero time argument is accepted for
+// compatibility with older clients.
+func (c *memcacheConn) delete(args []string) {
+	args = c.takeNoreply(args)
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		c.noreply = false
+		c.reply(mcBadFormat)
+		return
+	}
+
+	if err := c.kvd.db.DeleteContext(c.context(), args[0]); err != nil {
+		c.reply(mcNotFound)
+		return
+	}
+	c.reply(mcDeleted)
+}
+
+// incr adjusts an unsigned counter
+func (c *memcacheConn) incr(args []string, decrement bool) {
+	args = c.takeNoreply(args)
+	if len(args) != 2 {
+		c.noreply = false
+		c.reply(mcError)
+		return
+	}
+
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.reply(mcInvalidDelta)
+		return
+	}
+
+	value, err := c.kvd.db.IncrUnsignedContext(c.context(), args[0], delta, decrement)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		c.reply(mcNotFound)
+	case errors.Is(err, ErrNotInteger):
+		c.reply(mcNonNumeric)
+	case err != nil:
+		c.reply("SERVER_ERROR " + err.Error())
+	default:
+		c.reply(strconv.FormatUint(value, 10))
+	}
+}
+
+// touch updates a key's expiry without changing its value
+func (c *memcacheConn) touch(args []string) {
+	args = c.takeNoreply(args)
+	if len(args) != 2 {
+		c.noreply = false
+		c.reply(mcError)
+		return
+	}
+
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+
+	db := &c.kvd.db
+	ttl, expired := memcacheTTL(exptime, time.Now())
+	switch {
+	case expired:
+		err = db.ExpireContext(c.context(), args[0], 0)
+	case ttl == 0:
+		_, err = db.Persist(args[0])
+	default:
+		err = db.ExpireContext(c.context(), args[0], ttl)
+	}
+
+	if err != nil {
+		c.reply(mcNotFound)
+		return
+	}
+	c.reply(mcTouched)
+}
+
+// memcacheTTL converts a memcached exptime into a TTL. Zero means no
+// expiry, values up to 30 days are relative seconds and larger values are
+// Unix timestamps. The boolean reports an exptime that has already passed.
+func memcacheTTL(exptime int64, now time.Time) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= memcacheRelativeLimit:
+		return time.Duration(exptime) * time.Second, false
+	}
+
+	ttl := time.Unix(exptime, 0).Sub(now)
+	if ttl <= 0 {
+		return 0, true
+	}
+	return ttl, false
+}
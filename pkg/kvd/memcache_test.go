@@ -0,0 +1,107 @@
+package kvd
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemcacheCommands(t *testing.T) {
+	kvd := newTestKvd(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	go kvd.serveMemcache(server)
+
+	r := bufio.NewReader(client)
+	send := func(cmd string) {
+		if _, err := io.WriteString(client, cmd+"\r\n"); err != nil {
+			t.Fatalf("Failed to send %q: %v", cmd, err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, line := range want {
+			got, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply: %v", err)
+			}
+			if strings.TrimRight(got, "\r\n") != line {
+				t.Errorf("Expected %q, got %q", line, got)
+			}
+		}
+	}
+
+	send("set greeting 42 0 5\r\nhello")
+	expect("STORED")
+
+	send("get greeting missing")
+	expect("VALUE greeting 42 5", "hello", "END")
+
+	send("add greeting 0 0 1\r\nx")
+	expect("NOT_STORED")
+
+	send("replace missing 0 0 1\r\nx")
+	expect("NOT_STORED")
+
+	// The version reported by gets must be passed back to cas
+	send("gets greeting")
+	header, _ := r.ReadString('\n')
+	fields := strings.Fields(header)
+	expect("hello", "END")
+	if len(fields) != 5 {
+		t.Fatalf("Expected a cas value in %q", header)
+	}
+	send("cas greeting 7 0 3 999999\r\nbye")
+	expect("EXISTS")
+	send("cas greeting 7 0 3 " + fields[4] + "\r\nbye")
+	expect("STORED")
+	send("cas missing 0 0 1 1\r\nx")
+	expect("NOT_FOUND")
+
+	send("set counter 0 0 2\r\n10")
+	expect("STORED")
+	send("incr counter 5")
+	expect("15")
+	send("decr counter 100")
+	expect("0")
+	send("incr greeting 1")
+	expect("CLIENT_ERROR cannot increment or decrement non-numeric value")
+	send("incr missing 1")
+	expect("NOT_FOUND")
+
+	send("touch greeting 100")
+	expect("TOUCHED")
+	if ttl, ok, _ := kvd.db.TTL("greeting"); !ok || ttl <= 99*time.Second {
+		t.Errorf("Expected touch to set a 100s TTL, got %v", ttl)
+	}
+
+	send("delete greeting noreply")
+	send("delete greeting")
+	expect("NOT_FOUND")
+
+	send("bogus")
+	expect("ERROR")
+}
+
+func TestMemcacheTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	if ttl, expired := memcacheTTL(0, now); ttl != 0 || expired {
+		t.Errorf("Expected no expiry for 0, got %v, %v", ttl, expired)
+	}
+	if ttl, _ := memcacheTTL(60, now); ttl != time.Minute {
+		t.Errorf("Expected relative 60s, got %v", ttl)
+	}
+	if ttl, _ := memcacheTTL(1700000100, now); ttl != 100*time.Second {
+		t.Errorf("Expected absolute timestamp 100s away, got %v", ttl)
+	}
+	if _, expired := memcacheTTL(-1, now); !expired {
+		t.Error("Expected negative exptime to be expired")
+	}
+	if _, expired := memcacheTTL(1600000000, now); !expired {
+		t.Error("Expected past timestamp to be expired")
+	}
+}
@@ -0,0 +1,73 @@
+package kvd
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timedRWMutex is a sync.RWMutex that records how often it is acquired and
+// how long callers spend waiting for it. Uncontended acquisitions are not
+// timed.
+type timedRWMutex struct {
+	sync.RWMutex
+	acquisitions int64
+	waitNanos    int64
+}
+
+// Lock acquires the write lock
+func (m *timedRWMutex) Lock() {
+	m.LockContext(context.Background())
+}
+
+// RLock acquires the read lock
+func (m *timedRWMutex) RLock() {
+	m.RLockContext(context.Background())
+}
+
+// LockContext acquires the write lock, recording a span for the wait when
+// it is contended and ctx is part of a trace
+func (m *timedRWMutex) LockContext(ctx context.Context) {
+	atomic.AddInt64(&m.acquisitions, 1)
+	if m.RWMutex.TryLock() {
+		return
+	}
+	defer m.wait(ctx, "DB.Lock")()
+	m.RWMutex.Lock()
+}
+
+// RLockContext acquires the read lock, recording a span for the wait when
+// it is contended and ctx is part of a trace
+func (m *timedRWMutex) RLockContext(ctx context.Context) {
+	atomic.AddInt64(&m.acquisitions, 1)
+	if m.RWMutex.TryRLock() {
+		return
+	}
+	defer m.wait(ctx, "DB.RLock")()
+	m.RWMutex.RLock()
+}
+
+// wait starts timing a contended acquisition and returns a function that
+// records it
+func (m *timedRWMutex) wait(ctx context.Context, name string) func() {
+	start := time.Now()
+	var span trace.Span
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		_, span = tracer.Start(ctx, name)
+	}
+
+	return func() {
+		atomic.AddInt64(&m.waitNanos, int64(time.Since(start)))
+		if span != nil {
+			span.End()
+		}
+	}
+}
+
+// stats returns the number of acquisitions and the total time waited
+func (m *timedRWMutex) stats() (int64, time.Duration) {
+	return atomic.LoadInt64(&m.acquisitions), time.Duration(atomic.LoadInt64(&m.waitNanos))
+}
@@ -0,0 +1,193 @@
+package kvd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits for streaming import and export
+const (
+	DefaultTransferBatch = 1000
+	maxTransferBatch     = 100000
+
+	// importProgressInterval is the least time between progress lines
+	importProgressInterval = time.Second
+)
+
+// ImportProgress is streamed back while an import runs. The last line has
+// Done set, or Error set if the import stopped early. Batches applied
+// before an error are kept.
+type ImportProgress struct {
+	Imported int64  `json:"Imported"`
+	Batches  int64  `json:"Batches"`
+	Done     bool   `json:"Done,omitempty"`
+	Error    string `json:"Error,omitempty"`
+}
+
+// transferBatch reads the batch query parameter
+func transferBatch(r *http.Request) (int, error) {
+	param := r.URL.Query().Get("batch")
+	if param == "" {
+		return DefaultTransferBatch, nil
+	}
+
+	batch, err := strconv.Atoi(param)
+	if err != nil || batch <= 0 || batch > maxTransferBatch {
+		return 0, fmt.Errorf("batch must be between 1 and %d", maxTransferBatch)
+	}
+	return batch, nil
+}
+
+// importHandler reads NDJSON records from the request body and stores them
+// in batches, streaming NDJSON progress lines back as it goes. Each batch
+// is applied atomically; the import as a whole is not.
+func (kvd *Kvd) importHandler(w http.ResponseWriter, r *http.Request) {
+	batchSize, err := transferBatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Large imports outlive the server's timeouts, and progress is written
+	// while the body is still being read
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing read deadline", "err", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing write deadline", "err", err)
+	}
+	if err := rc.EnableFullDuplex(); err != nil {
+		kvd.log(r).Warn("Error enabling full duplex", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	progress := ImportProgress{}
+	lastReport := time.Now()
+
+	report := func() {
+		encoder.Encode(progress)
+		rc.Flush()
+		lastReport = time.Now()
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(r.Body))
+	batch := make([]Record, 0, batchSize)
+	charged := map[string]bool{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := kvd.db.BulkSetContext(r.Context(), batch); err != nil {
+			return fmt.Errorf("batch starting at record %d: %w", progress.Imported+1, err)
+		}
+		progress.Imported += int64(len(batch))
+		progress.Batches++
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && record.Key == "" {
+			err = ErrEmptyKey
+		}
+		if err == nil {
+			err = kvd.settings().limits.checkRecord(record)
+		}
+		if p := PrincipalFromContext(r.Context()); err == nil && p != nil && !p.allows(permWrite, record.Key) {
+			err = fmt.Errorf("%w for key %q", ErrForbidden, record.Key)
+		}
+		if err == nil {
+			_, err = kvd.limitKeys(r, charged, record.Key)
+		}
+		if err != nil {
+			err = fmt.Errorf("record %d: %w", progress.Imported+int64(len(batch))+1, err)
+		} else {
+			batch = append(batch, record)
+			if len(batch) < batchSize {
+				continue
+			}
+			err = flush()
+		}
+
+		if err != nil {
+			kvd.log(r).Warn("Import stopped", "err", err)
+			progress.Error = err.Error()
+			report()
+			return
+		}
+		if time.Since(lastReport) >= importProgressInterval {
+			report()
+		}
+	}
+
+	if err := flush(); err != nil {
+		kvd.log(r).Warn("Import stopped", "err", err)
+		progress.Error = err.Error()
+		report()
+		return
+	}
+
+	progress.Done = true
+	report()
+}
+
+// exportHandler streams the records whose keys match the pattern query
+// parameter as NDJSON in key order. Keys are listed once up front and
+// values are read in batches, so keys deleted during the export are
+// skipped and keys added during it are not included.
+func (kvd *Kvd) exportHandler(w http.ResponseWriter, r *http.Request) {
+	batchSize, err := transferBatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing write deadline", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	keys := kvd.db.Keys(r.URL.Query().Get("pattern"))
+	if p := PrincipalFromContext(r.Context()); p != nil && p.scoped() {
+		// Leave out keys the token may not read
+		allowed := keys[:0]
+		for _, key := range keys {
+			if p.allows(permRead, key) {
+				allowed = append(allowed, key)
+			}
+		}
+		keys = allowed
+	}
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		for _, record := range kvd.db.lookupRecords(keys[start:end]) {
+			if err := encoder.Encode(record); err != nil {
+				kvd.log(r).Warn("Export stopped", "err", err)
+				return
+			}
+		}
+		rc.Flush()
+	}
+}
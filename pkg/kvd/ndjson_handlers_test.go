@@ -0,0 +1,72 @@
+package kvd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImportExportHandlers(t *testing.T) {
+	kvd := newTestKvd(t)
+
+	var body strings.Builder
+	for i := 0; i < 25; i++ {
+		fmt.Fprintf(&body, "{\"Key\":\"k%02d\",\"Value\":\"%d\"}\n", i, i)
+	}
+
+	rec := httptest.NewRecorder()
+	kvd.importHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_import?batch=10", strings.NewReader(body.String())))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	var final ImportProgress
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &final); err != nil {
+		t.Fatalf("Failed to decode progress %q: %v", rec.Body, err)
+	}
+	if !final.Done || final.Imported != 25 || final.Batches != 3 {
+		t.Errorf("Expected 25 records in 3 batches, got %+v", final)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.exportHandler(rec, httptest.NewRequest(http.MethodGet, "/v1/_export?pattern=k1*&batch=3", nil))
+	scanner := bufio.NewScanner(rec.Body)
+	count := 0
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Failed to decode exported record %q: %v", scanner.Text(), err)
+		}
+		if record.Key != fmt.Sprintf("k1%d", count) {
+			t.Errorf("Expected key k1%d, got %s", count, record.Key)
+		}
+		count++
+	}
+	if count != 10 {
+		t.Errorf("Expected 10 exported records, got %d", count)
+	}
+}
+
+func TestImportStopsOnBadRecord(t *testing.T) {
+	kvd := newTestKvd(t)
+
+	body := "{\"Key\":\"a\",\"Value\":\"1\"}\n{\"Key\":\"b\",\"Value\":\"2\"}\n{\"Key\":\"\"}\n"
+	rec := httptest.NewRecorder()
+	kvd.importHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_import?batch=2", strings.NewReader(body)))
+
+	var progress ImportProgress
+	if err := json.Unmarshal(rec.Body.Bytes(), &progress); err != nil {
+		t.Fatalf("Failed to decode progress %q: %v", rec.Body, err)
+	}
+	if progress.Done || progress.Imported != 2 || !strings.Contains(progress.Error, "record 3") {
+		t.Errorf("Expected import to stop at record 3 after 2 records, got %+v", progress)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.importHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/_import?batch=0", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for batch=0, got %d", rec.Code)
+	}
+}
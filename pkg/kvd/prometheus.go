@@ -0,0 +1,229 @@
+package kvd
+
+import (
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// httpMetrics holds the Prometheus registry and the HTTP request metrics
+type httpMetrics struct {
+	registry *prometheus.Registry
+	handler  http.Handler
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// newHTTPMetrics creates a registry holding request, database and Go
+// runtime metrics
+func newHTTPMetrics(kvd *Kvd) *httpMetrics {
+	m := &httpMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvd_http_requests_total",
+			Help: "HTTP requests handled, by route, method and status code.",
+		}, []string{"route", "method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kvd_http_request_duration_seconds",
+			Help:    "HTTP request latency, by route and method.",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kvd_http_requests_in_flight",
+			Help: "HTTP requests currently being handled.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		dbCollector{kvd: kvd},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+
+	return m
+}
+
+// instrument is router middleware recording request counts, latency and
+// in-flight requests, labelled by the matched route template
+func (m *httpMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+
+		m.duration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+// routeTemplate returns the path template of the route that matched r
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unknown"
+}
+
+// statusRecorder captures the status code and body size written by a
+// handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+// WriteHeader records the status code before passing it on
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the bytes written
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// wantsPrometheus reports whether the Accept header asks for the Prometheus
+// text or OpenMetrics format rather than JSON
+func wantsPrometheus(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/openmetrics-text" || mediaType == "text/plain" {
+			return true
+		}
+	}
+	return false
+}
+
+// dbCollector exposes the database counters to Prometheus
+type dbCollector struct {
+	kvd *Kvd
+}
+
+var (
+	keysStoredDesc = prometheus.NewDesc("kvd_keys_stored",
+		"Keys currently stored.", nil, nil)
+	valueBytesDesc = prometheus.NewDesc("kvd_value_bytes_stored",
+		"Total size of stored values in bytes.", nil, nil)
+	operationsDesc = prometheus.NewDesc("kvd_operations_total",
+		"Key operations performed, by operation.", []string{"op"}, nil)
+	lockAcquisitionsDesc = prometheus.NewDesc("kvd_db_lock_acquisitions_total",
+		"Acquisitions of the database lock.", nil, nil)
+	lockWaitDesc = prometheus.NewDesc("kvd_db_lock_wait_seconds_total",
+		"Total time spent waiting for the database lock.", nil, nil)
+	operationDurationDesc = prometheus.NewDesc("kvd_operation_duration_seconds",
+		"Database operation latency, by operation.", []string{"op"}, nil)
+	valueSizeDesc = prometheus.NewDesc("kvd_value_size_bytes",
+		"Size of written values.", nil, nil)
+	batchSizeDesc = prometheus.NewDesc("kvd_bulk_batch_size",
+		"Keys per bulk operation.", nil, nil)
+	channelMessagesDesc = prometheus.NewDesc("kvd_channel_messages_total",
+		"Pub/sub messages, by channel and outcome.", []string{"channel", "outcome"}, nil)
+	auditEntriesDesc = prometheus.NewDesc("kvd_audit_entries_total",
+		"Audit log entries, by outcome.", []string{"outcome"}, nil)
+	rateLimitedDesc = prometheus.NewDesc("kvd_http_rate_limited_total",
+		"HTTP requests rejected by rate limits, by scope.", []string{"scope"}, nil)
+)
+
+// Describe sends the descriptors of the database metrics
+func (c dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysStoredDesc
+	ch <- valueBytesDesc
+	ch <- operationsDesc
+	ch <- lockAcquisitionsDesc
+	ch <- lockWaitDesc
+	ch <- operationDurationDesc
+	ch <- valueSizeDesc
+	ch <- batchSizeDesc
+	ch <- channelMessagesDesc
+	ch <- auditEntriesDesc
+	ch <- rateLimitedDesc
+}
+
+// Collect reads the current database metrics
+func (c dbCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := c.kvd.db.Metrics()
+
+	ch <- prometheus.MustNewConstMetric(keysStoredDesc, prometheus.GaugeValue, float64(metrics.KeysStored))
+	ch <- prometheus.MustNewConstMetric(valueBytesDesc, prometheus.GaugeValue, float64(metrics.ValueBytesStored))
+	ch <- prometheus.MustNewConstMetric(operationsDesc, prometheus.CounterValue, float64(metrics.GetOps), "get")
+	ch <- prometheus.MustNewConstMetric(operationsDesc, prometheus.CounterValue, float64(metrics.SetOps), "set")
+	ch <- prometheus.MustNewConstMetric(operationsDesc, prometheus.CounterValue, float64(metrics.DelOps), "delete")
+	ch <- prometheus.MustNewConstMetric(lockAcquisitionsDesc, prometheus.CounterValue, float64(metrics.LockAcquisitions))
+	ch <- prometheus.MustNewConstMetric(lockWaitDesc, prometheus.CounterValue, time.Duration(metrics.LockWaitNanos).Seconds())
+
+	for op, latency := range metrics.Latency {
+		ch <- constHistogram(operationDurationDesc, latency, 1/float64(time.Second), op)
+	}
+	ch <- constHistogram(valueSizeDesc, metrics.ValueSize, 1)
+	ch <- constHistogram(batchSizeDesc, metrics.BatchSize, 1)
+
+	for name, channel := range c.kvd.broker.Metrics() {
+		ch <- prometheus.MustNewConstMetric(channelMessagesDesc, prometheus.CounterValue, float64(channel.Published), name, "published")
+		ch <- prometheus.MustNewConstMetric(channelMessagesDesc, prometheus.CounterValue, float64(channel.Delivered), name, "delivered")
+		ch <- prometheus.MustNewConstMetric(channelMessagesDesc, prometheus.CounterValue, float64(channel.Dropped), name, "dropped")
+	}
+
+	if c.kvd.db.audit != nil {
+		ch <- prometheus.MustNewConstMetric(auditEntriesDesc, prometheus.CounterValue, float64(metrics.AuditWritten), "written")
+		ch <- prometheus.MustNewConstMetric(auditEntriesDesc, prometheus.CounterValue, float64(metrics.AuditDropped), "dropped")
+		ch <- prometheus.MustNewConstMetric(auditEntriesDesc, prometheus.CounterValue, float64(metrics.AuditFailed), "failed")
+	}
+
+	if limiter := c.kvd.settings().limiter; limiter != nil {
+		for scope, count := range limiter.Metrics() {
+			ch <- prometheus.MustNewConstMetric(rateLimitedDesc, prometheus.CounterValue, float64(count), scope)
+		}
+	}
+}
+
+// constHistogram converts a histogram snapshot to a Prometheus histogram,
+// multiplying bucket bounds and the sum by scale
+func constHistogram(desc *prometheus.Desc, s HistogramSnapshot, scale float64, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(s.Buckets))
+	var cumulative uint64
+	for _, b := range s.Buckets {
+		cumulative += uint64(b.Count)
+		// The unbounded bucket is implied by the count
+		if b.Le != math.MaxInt64 {
+			buckets[float64(b.Le)*scale] = cumulative
+		}
+	}
+	return prometheus.MustNewConstHistogram(desc, uint64(s.Count), float64(s.Sum)*scale, buckets, labels...)
+}
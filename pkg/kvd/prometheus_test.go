@@ -0,0 +1,67 @@
+package kvd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.db.Set("a", "1")
+
+	router := mux.NewRouter()
+	router.Use(kvd.metrics.instrument)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+	router.HandleFunc("/metrics", kvd.metricsHandler).Methods(http.MethodGet)
+
+	for _, path := range []string{"/v1/a", "/v1/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Without a Prometheus Accept header the JSON form is kept
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var metrics Metrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &metrics); err != nil || metrics.KeysStored != 1 {
+		t.Fatalf("Expected JSON metrics, got %q", rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Errorf("Expected OpenMetrics content type, got %q", rec.Header().Get("Content-Type"))
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`kvd_http_requests_total{code="200",method="GET",route="/v1/{key}"} 1`,
+		`kvd_http_requests_total{code="404",method="GET",route="/v1/{key}"} 1`,
+		`kvd_http_request_duration_seconds_count{method="GET",route="/v1/{key}"} 2`,
+		`kvd_http_requests_in_flight 1`,
+		`kvd_keys_stored 1`,
+		`kvd_operations_total{op="get"} 2`,
+		`kvd_db_lock_wait_seconds_total`,
+		`kvd_operation_duration_seconds_count{op="get"} 2`,
+		`kvd_value_size_bytes_count 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in exposition", want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "text/plain")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected the text format, got %q", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,192 @@
+package kvd
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"time"
+)
+
+// Pub/sub errors
+var (
+	ErrEmptyChannel   = errors.New("empty channel not allowed")
+	ErrInvalidPattern = errors.New("invalid channel pattern")
+)
+
+// subscriberBuffer is how many messages may queue for a subscriber before
+// further messages to it are dropped
+const subscriberBuffer = 256
+
+// maxChannelMetrics bounds the channels counted separately. Clients pick
+// channel names freely, so the rest are counted together under
+// OtherChannel to keep memory and metric labels bounded.
+const maxChannelMetrics = 1000
+
+// OtherChannel names the metrics shared by channels beyond the first
+// maxChannelMetrics published to
+const OtherChannel = "_other"
+
+// Message is a payload published to a channel
+type Message struct {
+	ID      int64  `json:"ID"`
+	Channel string `json:"Channel"`
+	Payload string `json:"Payload"`
+	Ts      string `json:"Ts"`
+}
+
+// ChannelMetrics tracks fan-out statistics for a single channel
+type ChannelMetrics struct {
+	Published int64 `json:"Published"`
+	Delivered int64 `json:"Delivered"`
+	Dropped   int64 `json:"Dropped"`
+}
+
+// Subscription receives messages for channels matching a pattern
+type Subscription struct {
+	id       int64
+	pattern  string
+	messages chan Message
+}
+
+// Messages returns the channel messages are delivered on. It is closed
+// when the subscription ends.
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Broker fans published messages out to matching subscribers. Messages are
+// not persisted; subscribers only see messages published while connected.
+type Broker struct {
+	mutex     sync.Mutex
+	subs      map[int64]*Subscription
+	channels  map[string]*ChannelMetrics
+	nextSubID int64
+	nextMsgID int64
+	closed    bool
+}
+
+// Init initializes the broker
+func (b *Broker) Init() {
+	b.subs = make(map[int64]*Subscription)
+	b.channels = make(map[string]*ChannelMetrics)
+}
+
+// Subscribe registers interest in channels matching pattern. Patterns use
+// shell glob syntax, so "orders.*" matches "orders.created".
+func (b *Broker) Subscribe(pattern string) (*Subscription, error) {
+	if pattern == "" {
+		return nil, ErrEmptyChannel
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, ErrInvalidPattern
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.nextSubID++
+	sub := &Subscription{
+		id:       b.nextSubID,
+		pattern:  pattern,
+		messages: make(chan Message, subscriberBuffer),
+	}
+	if b.closed {
+		close(sub.messages)
+		return sub, nil
+	}
+	b.subs[sub.id] = sub
+
+	return sub, nil
+}
+
+// Unsubscribe removes a subscription and closes its message channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.subs[sub.id]; ok {
+		delete(b.subs, sub.id)
+		close(sub.messages)
+	}
+}
+
+// Publish delivers a payload to every subscriber whose pattern matches the
+// channel and returns how many received it. Subscribers that are not
+// keeping up miss the message rather than blocking the publisher.
+func (b *Broker) Publish(channel string, payload string) (int, error) {
+	if channel == "" {
+		return 0, ErrEmptyChannel
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stats := b.channelStatsLocked(channel)
+
+	b.nextMsgID++
+	msg := Message{
+		ID:      b.nextMsgID,
+		Channel: channel,
+		Payload: payload,
+		Ts:      time.Now().Format(time.RFC3339Nano),
+	}
+	stats.Published++
+
+	delivered := 0
+	for _, sub := range b.subs {
+		if matched, _ := path.Match(sub.pattern, channel); !matched {
+			continue
+		}
+		select {
+		case sub.messages <- msg:
+			delivered++
+		default:
+			stats.Dropped++
+		}
+	}
+	stats.Delivered += int64(delivered)
+
+	return delivered, nil
+}
+
+// channelStatsLocked returns the counters for channel, which are those of
+// OtherChannel once maxChannelMetrics channels are counted. The caller
+// must hold the mutex.
+func (b *Broker) channelStatsLocked(channel string) *ChannelMetrics {
+	if stats, ok := b.channels[channel]; ok {
+		return stats
+	}
+	if len(b.channels) >= maxChannelMetrics {
+		channel = OtherChannel
+		if stats, ok := b.channels[channel]; ok {
+			return stats
+		}
+	}
+	stats := &ChannelMetrics{}
+	b.channels[channel] = stats
+	return stats
+}
+
+// Close ends every subscription. Later subscriptions are closed immediately.
+func (b *Broker) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	for id, sub := range b.subs {
+		delete(b.subs, id)
+		close(sub.messages)
+	}
+}
+
+// Metrics returns a copy of the per-channel counters
+func (b *Broker) Metrics() map[string]ChannelMetrics {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	channels := make(map[string]ChannelMetrics, len(b.channels))
+	for name, stats := range b.channels {
+		channels[name] = *stats
+	}
+	return channels
+}
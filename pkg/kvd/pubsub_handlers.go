@@ -0,0 +1,102 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sseHeartbeatInterval is how often an idle event stream sends a comment
+// so proxies do not close the connection
+const sseHeartbeatInterval = 15 * time.Second
+
+// publishResponse reports how many subscribers received a message
+type publishResponse struct {
+	Receivers int `json:"Receivers"`
+}
+
+// pubsubErrorStatus maps pub/sub errors to HTTP status codes
+func pubsubErrorStatus(err error) int {
+	if errors.Is(err, ErrEmptyChannel) || errors.Is(err, ErrInvalidPattern) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// channelPublishHandler handles requests to publish to a channel. The
+// request body is the message payload.
+func (kvd *Kvd) channelPublishHandler(w http.ResponseWriter, r *http.Request) {
+	channel := mux.Vars(r)["channel"]
+
+	payload, ok := kvd.readBody(w, r, int64(kvd.settings().limits.maxValueSize), ErrValueTooLarge)
+	if !ok {
+		return
+	}
+
+	receivers, err := kvd.broker.Publish(channel, string(payload))
+	if err != nil {
+		http.Error(w, err.Error(), pubsubErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, publishResponse{Receivers: receivers})
+}
+
+// channelSubscribeHandler streams messages for channels matching the
+// {pattern} route variable as server-sent events
+func (kvd *Kvd) channelSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := mux.Vars(r)["pattern"]
+
+	sub, err := kvd.broker.Subscribe(pattern)
+	if err != nil {
+		http.Error(w, err.Error(), pubsubErrorStatus(err))
+		return
+	}
+	defer kvd.broker.Unsubscribe(sub)
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing write deadline", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			data, err := kvd.toJSON(msg)
+			if err != nil {
+				kvd.log(r).Error("Error encoding message", "err", err)
+				continue
+			}
+			// toJSON terminates the data with a newline
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n", msg.ID, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package kvd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBrokerPatternSubscriptions(t *testing.T) {
+	var b Broker
+	b.Init()
+
+	orders, err := b.Subscribe("orders.*")
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+	all, _ := b.Subscribe("*")
+
+	if n, _ := b.Publish("orders.created", "1"); n != 2 {
+		t.Errorf("Expected 2 receivers, got %d", n)
+	}
+	if n, _ := b.Publish("users.created", "2"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+
+	msg := <-orders.Messages()
+	if msg.Channel != "orders.created" || msg.Payload != "1" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	if len(all.Messages()) != 2 {
+		t.Errorf("Expected 2 queued messages, got %d", len(all.Messages()))
+	}
+
+	b.Unsubscribe(orders)
+	if _, ok := <-orders.Messages(); ok {
+		t.Error("Expected message channel to be closed after unsubscribe")
+	}
+
+	stats := b.Metrics()["orders.created"]
+	if stats.Published != 1 || stats.Delivered != 2 {
+		t.Errorf("Unexpected channel metrics %+v", stats)
+	}
+}
+
+func TestBrokerDropsForSlowSubscribers(t *testing.T) {
+	var b Broker
+	b.Init()
+
+	b.Subscribe("events")
+	for i := 0; i < subscriberBuffer+5; i++ {
+		b.Publish("events", "x")
+	}
+
+	stats := b.Metrics()["events"]
+	if stats.Delivered != subscriberBuffer || stats.Dropped != 5 {
+		t.Errorf("Expected %d delivered and 5 dropped, got %+v", subscriberBuffer, stats)
+	}
+
+	if _, err := b.Subscribe("[bad"); err != ErrInvalidPattern {
+		t.Errorf("Expected ErrInvalidPattern, got %v", err)
+	}
+}
+
+func TestBrokerChannelMetricsBounded(t *testing.T) {
+	var b Broker
+	b.Init()
+
+	for i := 0; i < maxChannelMetrics+50; i++ {
+		b.Publish(fmt.Sprintf("ch%d", i), "x")
+	}
+	b.Publish("ch0", "x")
+
+	metrics := b.Metrics()
+	if len(metrics) != maxChannelMetrics+1 {
+		t.Fatalf("Expected %d tracked channels, got %d", maxChannelMetrics+1, len(metrics))
+	}
+	if metrics["ch0"].Published != 2 {
+		t.Errorf("Expected early channels to keep their counters, got %+v", metrics["ch0"])
+	}
+	if metrics[OtherChannel].Published != 50 {
+		t.Errorf("Expected later channels counted together, got %+v", metrics[OtherChannel])
+	}
+}
@@ -0,0 +1,348 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Rate limit scopes reported in metrics. Route and prefix scopes are
+// reported as "route:METHOD TEMPLATE" and "prefix:PREFIX".
+const (
+	RateScopeClient = "client"
+	RateScopeRoute  = "route"
+	RateScopePrefix = "prefix"
+)
+
+// rateLimitSweepInterval is how often buckets that have refilled are
+// dropped, so idle clients do not pile up
+const rateLimitSweepInterval = time.Minute
+
+var (
+	// ErrRateLimited is returned when a client has used up its requests
+	ErrRateLimited = errors.New("rate limit exceeded")
+	// ErrRateLimitConfig is returned for an unusable rate limit setting
+	ErrRateLimitConfig = errors.New("invalid rate limit")
+)
+
+// RateLimit is a token bucket refilled at Rate requests per second and
+// holding at most Burst requests
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// newRateLimit validates a limit; a zero burst allows one second's worth
+// of requests
+func newRateLimit(rate float64, burst int) (RateLimit, error) {
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) || burst < 0 {
+		return RateLimit{}, fmt.Errorf("%w: rate must be positive and burst not negative", ErrRateLimitConfig)
+	}
+	if burst == 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return RateLimit{Rate: rate, Burst: burst}, nil
+}
+
+// parseRateRules parses rules of the form SCOPE=RATE or SCOPE=RATE:BURST
+func parseRateRules(rules []string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit, len(rules))
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%w: %q is not SCOPE=RATE[:BURST]", ErrRateLimitConfig, rule)
+		}
+		scope, spec := strings.TrimSpace(rule[:i]), rule[i+1:]
+
+		rateText, burstText, hasBurst := strings.Cut(spec, ":")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateText), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad rate in %q", ErrRateLimitConfig, rule)
+		}
+		burst := 0
+		if hasBurst {
+			if burst, err = strconv.Atoi(strings.TrimSpace(burstText)); err != nil {
+				return nil, fmt.Errorf("%w: bad burst in %q", ErrRateLimitConfig, rule)
+			}
+		}
+		if limits[scope], err = newRateLimit(rate, burst); err != nil {
+			return nil, fmt.Errorf("%w (in %q)", err, rule)
+		}
+	}
+	return limits, nil
+}
+
+// tokenBucket holds the requests left to one client in one scope
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// rateScope is a limit that applies to a request
+type rateScope struct {
+	name  string
+	limit RateLimit
+}
+
+// rateLimiter keeps a token bucket per client and scope. Every client
+// gets the client limit across all requests, plus the limit of the
+// request's route and of the longest configured prefix of its key. Keys
+// in bulk and import bodies are charged by the handlers with limitKeys,
+// once per prefix per request.
+type rateLimiter struct {
+	client   *RateLimit
+	routes   map[string]RateLimit
+	prefixes map[string]RateLimit
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	limited   map[string]int64
+	lastSweep time.Time
+}
+
+// newRateLimiter builds a limiter from config, or returns nil when no
+// limits are set
+func newRateLimiter(config *Config) (*rateLimiter, error) {
+	if config.RateLimit == 0 && len(config.RateLimitRoutes) == 0 && len(config.RateLimitPrefixes) == 0 {
+		return nil, nil
+	}
+
+	l := &rateLimiter{
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+		limited: make(map[string]int64),
+	}
+	if config.RateLimit != 0 {
+		limit, err := newRateLimit(config.RateLimit, config.RateLimitBurst)
+		if err != nil {
+			return nil, err
+		}
+		l.client = &limit
+	}
+	var err error
+	if l.routes, err = parseRateRules(config.RateLimitRoutes); err != nil {
+		return nil, err
+	}
+	if l.prefixes, err = parseRateRules(config.RateLimitPrefixes); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// scopes returns the limits that apply to r
+func (l *rateLimiter) scopes(r *http.Request) []rateScope {
+	var scopes []rateScope
+	if l.client != nil {
+		scopes = append(scopes, rateScope{name: RateScopeClient, limit: *l.client})
+	}
+
+	route := r.Method + " " + routeTemplate(r)
+	if limit, ok := l.routes[route]; ok {
+		scopes = append(scopes, rateScope{name: RateScopeRoute + ":" + route, limit: limit})
+	}
+
+	if key, ok := mux.Vars(r)["key"]; ok {
+		if scope, ok := l.prefixScope(key); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// prefixScope returns the limit of the longest configured prefix of key
+func (l *rateLimiter) prefixScope(key string) (rateScope, bool) {
+	longest := ""
+	for prefix := range l.prefixes {
+		if strings.HasPrefix(key, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return rateScope{}, false
+	}
+	return rateScope{name: RateScopePrefix + ":" + longest, limit: l.prefixes[longest]}, true
+}
+
+// allow takes a request from each of the client's buckets in scopes, or
+// from none of them. When a bucket is empty it returns how long until it
+// has a request again and the scope that ran out.
+func (l *rateLimiter) allow(client string, scopes []rateScope) (time.Duration, string) {
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweepLocked(now)
+
+	buckets := make([]*tokenBucket, len(scopes))
+	var wait float64
+	denied := ""
+	for i, scope := range scopes {
+		id := scope.name + "\x00" + client
+		b, ok := l.buckets[id]
+		if !ok || b.limit != scope.limit {
+			b = &tokenBucket{limit: scope.limit, tokens: float64(scope.limit.Burst), last: now}
+			l.buckets[id] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			if seconds := (1 - b.tokens) / b.limit.Rate; seconds > wait {
+				wait, denied = seconds, scope.name
+			}
+		}
+		buckets[i] = b
+	}
+
+	if denied != "" {
+		l.limited[denied]++
+		return time.Duration(wait * float64(time.Second)), denied
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0, ""
+}
+
+// sweepLocked drops full buckets; they behave the same as new ones
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for id, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(l.buckets, id)
+		}
+	}
+}
+
+// Metrics returns the rejected requests by scope
+func (l *rateLimiter) Metrics() map[string]int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	limited := make(map[string]int64, len(l.limited))
+	for scope, count := range l.limited {
+		limited[scope] = count
+	}
+	return limited
+}
+
+// inherit carries over the rate limited request counts of the limiter
+// being replaced, so the totals survive a reload
+func (l *rateLimiter) inherit(old *rateLimiter) {
+	if l == nil || old == nil {
+		return
+	}
+	for scope, count := range old.Metrics() {
+		l.limited[scope] += count
+	}
+}
+
+// rateLimitClient identifies the client a request counts against: its
+// valid token, else its verified certificate, else its IP address. Limits
+// apply before authentication, so requests with a bad token count against
+// their address. The self-declared X-Client-ID header is not used, as it
+// would let a client pick a fresh bucket per request.
+func (kvd *Kvd) rateLimitClient(r *http.Request) string {
+	p := PrincipalFromContext(r.Context())
+	if p == nil && kvd.tokens != nil {
+		p, _ = kvd.tokens.authenticate(bearerToken(r))
+	}
+	if p != nil {
+		return "token:" + p.Name
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimit is router middleware replying 429 with Retry-After to
+// clients over their limits. It runs before authorize, so clients cannot
+// flood the server with requests that fail authentication.
+func (kvd *Kvd) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := kvd.settings().limiter
+		if limiter == nil || healthRoutes[routeTemplate(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := kvd.rateLimitClient(r)
+		if wait, scope := limiter.allow(client, limiter.scopes(r)); scope != "" {
+			kvd.log(r).Debug("Request rate limited", "client", client, "scope", scope)
+			writeRateLimited(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitKeys takes a request from the client's bucket of the configured
+// prefix of each key, skipping prefixes already in charged and adding the
+// ones it takes from. It returns ErrRateLimited and how long to wait when
+// a bucket is empty.
+func (kvd *Kvd) limitKeys(r *http.Request, charged map[string]bool, keys ...string) (time.Duration, error) {
+	limiter := kvd.settings().limiter
+	if limiter == nil || len(limiter.prefixes) == 0 {
+		return 0, nil
+	}
+
+	var scopes []rateScope
+	for _, key := range keys {
+		if scope, ok := limiter.prefixScope(key); ok && !charged[scope.name] {
+			charged[scope.name] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return 0, nil
+	}
+
+	client := kvd.rateLimitClient(r)
+	if wait, scope := limiter.allow(client, scopes); scope != "" {
+		kvd.log(r).Debug("Request rate limited", "client", client, "scope", scope)
+		return wait, fmt.Errorf("%w for %s", ErrRateLimited, scope)
+	}
+	return 0, nil
+}
+
+// rateLimitKeys replies with 429 and returns false when the client is over
+// the limit of the prefix of any of keys, for handlers whose keys are in
+// the request body
+func (kvd *Kvd) rateLimitKeys(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	if wait, err := kvd.limitKeys(r, map[string]bool{}, keys...); err != nil {
+		writeRateLimited(w, wait)
+		return false
+	}
+	return true
+}
+
+// writeRateLimited replies with 429 and a Retry-After of wait, rounded up
+// to whole seconds
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Max(1, math.Ceil(wait.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+}
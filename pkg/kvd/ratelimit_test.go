@@ -0,0 +1,217 @@
+package kvd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRateLimiter(t *testing.T) {
+	kvd := newTestKvd(t)
+	limiter, err := newRateLimiter(&Config{
+		RateLimit:         2,
+		RateLimitRoutes:   []string{"POST /v1/_mset=1"},
+		RateLimitPrefixes: []string{"batch.=0.1"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to configure rate limits: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+	kvd.live.Store(&liveSettings{config: kvd.config, limits: kvd.settings().limits, limiter: limiter})
+
+	router := mux.NewRouter()
+	router.Use(kvd.logRequests, kvd.rateLimit)
+	router.HandleFunc("/v1/_mset", kvd.msetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/{key}", kvd.keyPutHandler).Methods(http.MethodPut)
+
+	do := func(method, path, remote, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The client limit allows a burst of two, then one request per half second
+	for i, want := range []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests} {
+		if rec := do(http.MethodPut, "/v1/a", "10.0.0.1:1000", "x"); rec.Code != want {
+			t.Fatalf("Request %d: expected %d, got %d", i, want, rec.Code)
+		}
+	}
+	rec := do(http.MethodPut, "/v1/a", "10.0.0.1:2000", "x")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After 1 for another port of the same client, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do(http.MethodPut, "/v1/a", "10.0.0.2:1000", "x"); rec.Code != http.StatusCreated {
+		t.Errorf("Expected another client to have its own limit, got %d", rec.Code)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if rec := do(http.MethodPut, "/v1/a", "10.0.0.1:1000", "x"); rec.Code != http.StatusCreated {
+		t.Errorf("Expected a request after refilling, got %d", rec.Code)
+	}
+
+	// Route and prefix limits apply on top of the client limit
+	now = now.Add(time.Minute)
+	do(http.MethodPost, "/v1/_mset", "10.0.0.3:1", `[]`)
+	if rec := do(http.MethodPost, "/v1/_mset", "10.0.0.3:1", `[]`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the route limit, got %d", rec.Code)
+	}
+	do(http.MethodPut, "/v1/batch.a", "10.0.0.4:1", "x")
+	rec = do(http.MethodPut, "/v1/batch.b", "10.0.0.4:1", "x")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected the prefix limit with Retry-After 10, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do(http.MethodPut, "/v1/other", "10.0.0.4:1", "x"); rec.Code != http.StatusCreated {
+		t.Errorf("Expected keys outside the prefix to be unaffected, got %d", rec.Code)
+	}
+
+	metrics := limiter.Metrics()
+	if metrics[RateScopeClient] != 2 || metrics[RateScopeRoute+":POST /v1/_mset"] != 1 || metrics[RateScopePrefix+":batch."] != 1 {
+		t.Errorf("Unexpected rate limit metrics %v", metrics)
+	}
+}
+
+func TestRateLimiterPrefix(t *testing.T) {
+	limiter, err := newRateLimiter(&Config{RateLimitPrefixes: []string{"batch/=10:1", "batch/slow/=0.5"}})
+	if err != nil {
+		t.Fatalf("Failed to configure rate limits: %v", err)
+	}
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+
+	scopesFor := func(key string) []rateScope {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/", nil), map[string]string{"key": key})
+		return limiter.scopes(req)
+	}
+
+	if scopes := scopesFor("batch/slow/a"); len(scopes) != 1 || scopes[0].name != "prefix:batch/slow/" {
+		t.Fatalf("Expected the longest prefix, got %+v", scopes)
+	}
+	if scopes := scopesFor("other"); len(scopes) != 0 {
+		t.Errorf("Expected no limits, got %+v", scopes)
+	}
+
+	if _, scope := limiter.allow("c", scopesFor("batch/slow/a")); scope != "" {
+		t.Fatal("Expected the first request to pass")
+	}
+	wait, scope := limiter.allow("c", scopesFor("batch/slow/b"))
+	if scope != "prefix:batch/slow/" || wait != 2*time.Second {
+		t.Errorf("Expected a 2s wait on the prefix, got %v %q", wait, scope)
+	}
+
+	// Idle buckets are dropped once they have refilled
+	now = now.Add(rateLimitSweepInterval)
+	limiter.allow("d", nil)
+	if len(limiter.buckets) != 0 {
+		t.Errorf("Expected idle buckets to be swept, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	for _, config := range []Config{
+		{RateLimit: -1},
+		{RateLimit: 1, RateLimitBurst: -1},
+		{RateLimitRoutes: []string{"GET /v1/{key}"}},
+		{RateLimitRoutes: []string{"GET /v1/{key}=fast"}},
+		{RateLimitPrefixes: []string{"a/=1:x"}},
+		{RateLimitPrefixes: []string{"a/=0"}},
+	} {
+		if _, err := newRateLimiter(&config); !errors.Is(err, ErrRateLimitConfig) {
+			t.Errorf("Expected ErrRateLimitConfig for %+v, got %v", config, err)
+		}
+	}
+
+	if limiter, err := newRateLimiter(&Config{}); limiter != nil || err != nil {
+		t.Errorf("Expected no rate limits by default, got %v %v", limiter, err)
+	}
+	limiter, _ := newRateLimiter(&Config{RateLimit: 2.5})
+	if limiter.client.Burst != 3 {
+		t.Errorf("Expected a default burst of 3, got %d", limiter.client.Burst)
+	}
+}
+
+func TestRateLimitBulkKeys(t *testing.T) {
+	kvd := newTestKvd(t)
+	limiter, err := newRateLimiter(&Config{RateLimitPrefixes: []string{"batch.=0.1"}})
+	if err != nil {
+		t.Fatalf("Failed to configure rate limits: %v", err)
+	}
+	limiter.now = func() time.Time { return time.Unix(1000, 0) }
+	kvd.live.Store(&liveSettings{config: kvd.config, limits: kvd.settings().limits, limiter: limiter})
+
+	router := mux.NewRouter()
+	router.Use(kvd.rateLimit)
+	router.HandleFunc("/v1/_mset", kvd.msetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_mget", kvd.mgetHandler).Methods(http.MethodPost)
+	router.HandleFunc("/v1/_import", kvd.importHandler).Methods(http.MethodPost)
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	// A bulk request takes one request from each prefix it touches
+	if rec := do("/v1/_mset", `[{"Key":"batch.a","Value":"1"},{"Key":"batch.b","Value":"2"}]`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected the first batch to pass, got %d %s", rec.Code, rec.Body)
+	}
+	rec := do("/v1/_mget", `["other","batch.a"]`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected the prefix limit on bulk keys, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("/v1/_mget", `["other"]`); rec.Code == http.StatusTooManyRequests {
+		t.Errorf("Expected keys outside the prefix to be unaffected")
+	}
+	rec = do("/v1/_import", "{\"Key\":\"other\",\"Value\":\"1\"}\n{\"Key\":\"batch.c\",\"Value\":\"1\"}\n")
+	if !strings.Contains(rec.Body.String(), ErrRateLimited.Error()) {
+		t.Errorf("Expected the import to stop at the limited prefix, got %s", rec.Body)
+	}
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	kvd := newTestKvd(t)
+	store, err := loadTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatalf("Failed to load tokens: %v", err)
+	}
+	kvd.tokens = store
+	admin, _ := store.bootstrap()
+	limiter, err := newRateLimiter(&Config{RateLimit: 1, RateLimitBurst: 2})
+	if err != nil {
+		t.Fatalf("Failed to configure rate limits: %v", err)
+	}
+	limiter.now = func() time.Time { return time.Unix(1000, 0) }
+	kvd.live.Store(&liveSettings{config: kvd.config, limits: kvd.settings().limits, limiter: limiter})
+
+	router := mux.NewRouter()
+	router.Use(kvd.rateLimit, kvd.authorize)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+
+	do := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/a", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Bad tokens count against the address
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if code := do("wrong"); code != want {
+			t.Errorf("Request %d: expected %d, got %d", i, want, code)
+		}
+	}
+	// A valid token from the same address has its own limit
+	if code := do(admin); code != http.StatusNotFound {
+		t.Errorf("Expected the valid token to pass, got %d", code)
+	}
+}
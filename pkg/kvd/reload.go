@@ -0,0 +1,220 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// ErrReloadUnavailable is returned by Reload when the server has no
+// configuration source to read again
+var ErrReloadUnavailable = errors.New("no configuration source to reload from")
+
+// liveSettingNames are the settings a reload applies to the running
+// server. Changes to any other setting are reported as needing a restart.
+var liveSettingNames = map[string]bool{
+	"log_level":           true,
+	"log_keys":            true,
+	"max_records":         true,
+	"script_max_steps":    true,
+	"script_timeout":      true,
+	"rate_limit":          true,
+	"rate_limit_burst":    true,
+	"rate_limit_routes":   true,
+	"rate_limit_prefixes": true,
+	"max_key_length":      true,
+	"max_value_size":      true,
+	"max_bulk_records":    true,
+	"max_body_size":       true,
+}
+
+// liveSettings holds what a reload can change for requests. It is
+// replaced as a whole, so a request sees either the old or the new
+// settings and never a mix.
+type liveSettings struct {
+	// config is the configuration in effect; only the live settings in it
+	// may differ from those the server started with
+	config  *Config
+	limits  requestLimits
+	limiter *rateLimiter
+}
+
+// ReloadResult reports what a configuration reload changed
+type ReloadResult struct {
+	// Applied lists the settings changed in the running server
+	Applied []string `json:"Applied"`
+	// RestartRequired lists the changed settings that only take effect
+	// after a restart
+	RestartRequired []string `json:"RestartRequired"`
+	// TokensReloaded is set when the auth file was read again
+	TokensReloaded bool `json:"TokensReloaded"`
+}
+
+// settings returns the live settings in effect
+func (kvd *Kvd) settings() *liveSettings {
+	return kvd.live.Load()
+}
+
+// newLiveSettings builds the live settings for config
+func newLiveSettings(config *Config) (*liveSettings, error) {
+	limits, err := newRequestLimits(config)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := newRateLimiter(config)
+	if err != nil {
+		return nil, err
+	}
+	return &liveSettings{config: config, limits: limits, limiter: limiter}, nil
+}
+
+// SetConfigLoader sets how Reload reads the configuration again, usually
+// from the same file, environment and flags the server started with
+func (kvd *Kvd) SetConfigLoader(load func() (*Config, error)) {
+	kvd.loadConfig = load
+}
+
+// Reload reads the configuration again and applies the changed settings
+// that can change while the server runs. A configuration that does not
+// validate changes nothing. The auth file and TLS certificates are read
+// again as well.
+func (kvd *Kvd) Reload() (ReloadResult, error) {
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}}
+	if kvd.loadConfig == nil {
+		return result, ErrReloadUnavailable
+	}
+
+	kvd.reloadMutex.Lock()
+	defer kvd.reloadMutex.Unlock()
+
+	config, err := kvd.loadConfig()
+	if err != nil {
+		return result, err
+	}
+	if err := config.Validate(); err != nil {
+		return result, err
+	}
+
+	current := kvd.settings()
+	live := *current
+	changed := map[string]bool{}
+	for _, name := range configChanges(current.config, config) {
+		changed[name] = true
+		if liveSettingNames[name] {
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, name)
+		}
+	}
+
+	// Keep the restart-only settings as the server runs with them
+	live.config = &Config{}
+	*live.config = *current.config
+	from := reflect.ValueOf(config).Elem()
+	to := reflect.ValueOf(live.config).Elem()
+	for i := 0; i < to.NumField(); i++ {
+		if liveSettingNames[configSettingName(to.Type().Field(i))] {
+			to.Field(i).Set(from.Field(i))
+		}
+	}
+
+	// Build everything before applying anything
+	var level slog.Level
+	if changed["log_level"] {
+		if err := level.UnmarshalText([]byte(live.config.LogLevel)); err != nil {
+			return result, fmt.Errorf("%w %q", ErrInvalidLogLevel, live.config.LogLevel)
+		}
+	}
+	if live.limits, err = newRequestLimits(live.config); err != nil {
+		return result, err
+	}
+	if changed["rate_limit"] || changed["rate_limit_burst"] || changed["rate_limit_routes"] || changed["rate_limit_prefixes"] {
+		if live.limiter, err = newRateLimiter(live.config); err != nil {
+			return result, err
+		}
+		live.limiter.inherit(current.limiter)
+	}
+	var tokens *tokenStore
+	if kvd.tokens != nil && !changed["auth_file"] {
+		if tokens, err = loadTokenStore(kvd.tokens.path); err != nil {
+			return result, err
+		}
+	}
+
+	kvd.live.Store(&live)
+	if changed["log_level"] {
+		kvd.logLevel.Set(level)
+	}
+	if changed["max_records"] {
+		kvd.db.SetMaxRecords(live.config.MaxRecords)
+	}
+	if changed["max_body_size"] && live.config.GRPCPort != 0 {
+		kvd.logger.Warn("The gRPC listener keeps its message size limit until a restart", "max_body_size", live.config.MaxBodySize)
+	}
+	if tokens != nil {
+		kvd.tokens.replace(tokens)
+		result.TokensReloaded = true
+	}
+	if kvd.certs != nil {
+		if err := kvd.certs.Reload(); err != nil {
+			kvd.logger.Error("Error reloading TLS certificates", "err", err)
+		}
+	}
+
+	kvd.logger.Info("Configuration reloaded", "applied", result.Applied, "restart_required", result.RestartRequired)
+	return result, nil
+}
+
+// configChanges returns the names of the settings that differ between two
+// configurations
+func configChanges(old, new *Config) []string {
+	var changes []string
+	a := reflect.ValueOf(old).Elem()
+	b := reflect.ValueOf(new).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changes = append(changes, configSettingName(a.Type().Field(i)))
+		}
+	}
+	return changes
+}
+
+// reloadOnSignal reloads the configuration on each SIGHUP until ctx is done
+func (kvd *Kvd) reloadOnSignal(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			if _, err := kvd.Reload(); err != nil {
+				kvd.logger.Error("Configuration reload failed", "err", err)
+			}
+		}
+	}
+}
+
+// reloadHandler handles requests to reload the configuration
+func (kvd *Kvd) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := kvd.Reload()
+	switch {
+	case errors.Is(err, ErrReloadUnavailable):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		kvd.log(r).Error("Configuration reload failed", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, result)
+}
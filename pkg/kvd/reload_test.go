@@ -0,0 +1,89 @@
+package kvd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	authFile := filepath.Join(t.TempDir(), "auth.json")
+	started := DefaultConfig()
+	started.AuthFile = authFile
+	started.LogLevel = "warn"
+
+	kvd := &Kvd{}
+	if err := kvd.Init(started); err != nil {
+		t.Fatal(err)
+	}
+	kvd.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	if _, err := kvd.Reload(); !errors.Is(err, ErrReloadUnavailable) {
+		t.Errorf("Expected ErrReloadUnavailable without a loader, got %v", err)
+	}
+
+	next := *started
+	kvd.SetConfigLoader(func() (*Config, error) {
+		config := next
+		return &config, nil
+	})
+
+	next.LogLevel = "debug"
+	next.MaxValueSize = 4
+	next.MaxRecords = 1
+	next.RateLimitPrefixes = []string{"batch/=1"}
+	next.Port = 9999
+	os.WriteFile(authFile, []byte(`{"tokens":[{"name":"ops","role":"admin","token":"added"}]}`), 0o600)
+
+	result, err := kvd.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(result.Applied, ",") != "max_records,log_level,rate_limit_prefixes,max_value_size" {
+		t.Errorf("Unexpected applied settings %v", result.Applied)
+	}
+	if strings.Join(result.RestartRequired, ",") != "port" || !result.TokensReloaded {
+		t.Errorf("Unexpected reload result %+v", result)
+	}
+
+	live := kvd.settings()
+	if live.limits.maxValueSize != 4 || live.limiter == nil || live.config.Port != started.Port {
+		t.Errorf("Expected the live settings to change and the port to stay, got %+v", live)
+	}
+	if kvd.logLevel.Level() != slog.LevelDebug {
+		t.Errorf("Expected the debug log level, got %v", kvd.logLevel.Level())
+	}
+	kvd.db.Set("a", "1")
+	if err := kvd.db.Set("b", "1"); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected the new record limit, got %v", err)
+	}
+	if _, ok := kvd.tokens.authenticate("added"); !ok {
+		t.Error("Expected the tokens to be read again")
+	}
+
+	// An invalid configuration changes nothing
+	next.MaxValueSize = -1
+	next.LogLevel = "info"
+	if _, err := kvd.Reload(); !errors.Is(err, ErrConfig) {
+		t.Errorf("Expected ErrConfig, got %v", err)
+	}
+	if kvd.settings() != live || kvd.logLevel.Level() != slog.LevelDebug {
+		t.Error("Expected a failed reload to leave the settings alone")
+	}
+
+	next.MaxValueSize = 4
+	rec := httptest.NewRecorder()
+	kvd.reloadHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	var reply ReloadResult
+	json.Unmarshal(rec.Body.Bytes(), &reply)
+	if rec.Code != http.StatusOK || strings.Join(reply.Applied, ",") != "log_level" {
+		t.Errorf("Expected the handler to report the log level change, got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,583 @@
+package kvd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on incoming RESP commands
+const (
+	maxRESPArgs      = 1024 * 1024
+	maxRESPBulkBytes = 64 * 1048576
+	maxRESPLineBytes = 64 * 1024
+)
+
+// errRESPProtocol is returned for malformed client input
+var errRESPProtocol = errors.New("Protocol error")
+
+// respConn serves one Redis protocol client. Replies use RESP2 until the
+// client switches to RESP3 with HELLO.
+type respConn struct {
+	kvd    *Kvd
+	r      *bufio.Reader
+	w      *bufio.Writer
+	proto  int
+	name   string
+	remote string
+	quit   bool
+}
+
+// serveRESP handles Redis protocol commands on conn until it closes
+func (kvd *Kvd) serveRESP(conn net.Conn) {
+	c := &respConn{
+		kvd:    kvd,
+		r:      bufio.NewReaderSize(conn, maxRESPLineBytes),
+		w:      bufio.NewWriter(conn),
+		proto:  2,
+		remote: conn.RemoteAddr().String(),
+	}
+
+	for !c.quit {
+		args, err := c.readCommand()
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				c.writeError("ERR " + err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		c.dispatch(args)
+
+		// Batch replies to pipelined commands into one write
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	c.w.Flush()
+}
+
+// readLine reads a CRLF-terminated line without the terminator
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) || len(line) > maxRESPLineBytes {
+		return "", fmt.Errorf("%w: line too long", errRESPProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// readCommand reads either a multibulk array of bulk strings or an inline
+// command separated by spaces
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 || count > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+
+	// The count is unverified, so grow args as the arguments arrive
+	args := make([]string, 0, min(count, 16))
+	for i := 0; i < count; i++ {
+		header, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errRESPProtocol, header)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 || size > maxRESPBulkBytes {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// Reply writers
+
+func (c *respConn) writeSimple(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) writeError(s string) {
+	c.w.WriteString("-" + s + "\r\n")
+}
+
+func (c *respConn) writeInt(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *respConn) writeBulk(s string) {
+	c.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n")
+	c.w.WriteString(s)
+	c.w.WriteString("\r\n")
+}
+
+func (c *respConn) writeNull() {
+	if c.proto == 3 {
+		c.w.WriteString("_\r\n")
+		return
+	}
+	c.w.WriteString("$-1\r\n")
+}
+
+func (c *respConn) writeArrayHeader(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMapHeader starts a map of n pairs, flattened to an array in RESP2
+func (c *respConn) writeMapHeader(n int) {
+	if c.proto == 3 {
+		c.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	c.writeArrayHeader(n * 2)
+}
+
+func (c *respConn) writeBulks(items []string) {
+	c.writeArrayHeader(len(items))
+	for _, item := range items {
+		c.writeBulk(item)
+	}
+}
+
+// writeDBError reports a DB error in Redis style
+func (c *respConn) writeDBError(err error) {
+	c.writeError("ERR " + err.Error())
+}
+
+func (c *respConn) wrongArgs(cmd string) {
+	c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+// context returns the context for the connection's database calls,
+// identifying the client by the name it set with CLIENT SETNAME
+func (c *respConn) context() context.Context {
+	return WithCaller(context.Background(), Caller{Identity: c.name, Remote: c.remote})
+}
+
+// dispatch runs a single command
+func (c *respConn) dispatch(args []string) {
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+	db := &c.kvd.db
+
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 0:
+			c.writeSimple("PONG")
+		case 1:
+			c.writeBulk(args[0])
+		default:
+			c.wrongArgs(cmd)
+		}
+
+	case "ECHO":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.writeBulk(args[0])
+
+	case "QUIT":
+		c.writeSimple("OK")
+		c.quit = true
+
+	case "HELLO":
+		c.hello(args)
+
+	case "SELECT":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		if args[0] != "0" {
+			c.writeError("ERR DB index is out of range")
+			return
+		}
+		c.writeSimple("OK")
+
+	case "CLIENT":
+		if len(args) >= 2 && strings.EqualFold(args[0], "SETNAME") {
+			c.name = args[1]
+		}
+		if len(args) >= 1 && strings.EqualFold(args[0], "GETNAME") {
+			if c.name == "" {
+				c.writeNull()
+			} else {
+				c.writeBulk(c.name)
+			}
+			return
+		}
+		c.writeSimple("OK")
+
+	case "COMMAND":
+		c.writeArrayHeader(0)
+
+	case "INFO":
+		c.writeBulk(fmt.Sprintf("# Server\r\nredis_version:7.0.0\r\nkvd_version:%s\r\n"+
+			"# Keyspace\r\ndb0:keys=%d\r\n", c.kvd.status.Version, db.Len()))
+
+	case "DBSIZE":
+		c.writeInt(int64(db.Len()))
+
+	case "GET":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		value, err := db.Get(args[0])
+		if errors.Is(err, ErrKeyNotFound) {
+			c.writeNull()
+			return
+		}
+		if err != nil {
+			c.writeDBError(err)
+			return
+		}
+		c.writeBulk(value)
+
+	case "SET":
+		c.set(args)
+
+	case "DEL", "UNLINK":
+		if len(args) == 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		deleted := int64(0)
+		for _, key := range args {
+			if err := db.DeleteContext(c.context(), key); err == nil {
+				deleted++
+			}
+		}
+		c.writeInt(deleted)
+
+	case "MGET":
+		if len(args) == 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.writeArrayHeader(len(args))
+		for _, key := range args {
+			if value, err := db.Get(key); err == nil {
+				c.writeBulk(value)
+			} else {
+				c.writeNull()
+			}
+		}
+
+	case "MSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		records := make([]Record, 0, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			records = append(records, Record{Key: args[i], Value: args[i+1]})
+		}
+		if err := db.BulkSetContext(c.context(), records); err != nil {
+			c.writeDBError(err)
+			return
+		}
+		c.writeSimple("OK")
+
+	case "EXISTS":
+		if len(args) == 0 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.writeInt(int64(db.Exists(args...)))
+
+	case "KEYS":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		c.writeBulks(db.Keys(args[0]))
+
+	case "SCAN":
+		c.scan(args)
+
+	case "EXPIRE", "PEXPIRE":
+		if len(args) != 2 {
+			c.wrongArgs(cmd)
+			return
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			c.writeError("ERR value is not an integer or out of range")
+			return
+		}
+		unit := time.Second
+		if cmd == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		if limit := math.MaxInt64 / int64(unit); n > limit || n < -limit {
+			c.writeError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd)))
+			return
+		}
+		if err := db.ExpireContext(c.context(), args[0], time.Duration(n)*unit); err != nil {
+			c.writeInt(0)
+			return
+		}
+		c.writeInt(1)
+
+	case "PERSIST":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		if had, err := db.Persist(args[0]); err == nil && had {
+			c.writeInt(1)
+			return
+		}
+		c.writeInt(0)
+
+	case "TTL", "PTTL":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+		remaining, hasTTL, err := db.TTL(args[0])
+		switch {
+		case err != nil:
+			c.writeInt(-2)
+		case !hasTTL:
+			c.writeInt(-1)
+		case cmd == "PTTL":
+			c.writeInt(int64(remaining / time.Millisecond))
+		default:
+			c.writeInt(int64((remaining + 500*time.Millisecond) / time.Second))
+		}
+
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		c.incr(cmd, args)
+
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown command '%s'", truncate(cmd)))
+	}
+}
+
+// truncate limits how much of an unknown command is echoed back
+func truncate(cmd string) string {
+	if len(cmd) > 128 {
+		return cmd[:128]
+	}
+	return cmd
+}
+
+// hello handles HELLO [protover [AUTH user pass] [SETNAME name]]
+func (c *respConn) hello(args []string) {
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(args[0])
+		if err != nil || (proto != 2 && proto != 3) {
+			c.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		c.proto = proto
+
+		for i := 1; i < len(args); i++ {
+			switch {
+			case strings.EqualFold(args[i], "SETNAME") && i+1 < len(args):
+				c.name = args[i+1]
+				i++
+			case strings.EqualFold(args[i], "AUTH") && i+2 < len(args):
+				i += 2
+			default:
+				c.writeError("ERR syntax error")
+				return
+			}
+		}
+	}
+
+	c.writeMapHeader(6)
+	c.writeBulk("server")
+	c.writeBulk("kvd")
+	c.writeBulk("version")
+	c.writeBulk(c.kvd.status.Version)
+	c.writeBulk("proto")
+	c.writeInt(int64(c.proto))
+	c.writeBulk("mode")
+	c.writeBulk("standalone")
+	c.writeBulk("role")
+	c.writeBulk("master")
+	c.writeBulk("modules")
+	c.writeArrayHeader(0)
+}
+
+// set handles SET key value [EX s | PX ms | KEEPTTL] [NX | XX]
+func (c *respConn) set(args []string) {
+	if len(args) < 2 {
+		c.wrongArgs("SET")
+		return
+	}
+
+	var opts SetOptions
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.OnlyIfAbsent = true
+		case "XX":
+			opts.OnlyIfPresent = true
+		case "KEEPTTL":
+			opts.KeepTTL = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				c.writeError("ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				c.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+			unit := time.Second
+			if strings.EqualFold(args[i], "PX") {
+				unit = time.Millisecond
+			}
+			if n > math.MaxInt64/int64(unit) {
+				c.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+			opts.TTL = time.Duration(n) * unit
+			i++
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+	if (opts.OnlyIfAbsent && opts.OnlyIfPresent) || (opts.KeepTTL && opts.TTL > 0) {
+		c.writeError("ERR syntax error")
+		return
+	}
+
+	stored, err := c.kvd.db.SetWithOptionsContext(c.context(), args[0], args[1], opts)
+	if err != nil {
+		c.writeDBError(err)
+		return
+	}
+	if !stored {
+		c.writeNull()
+		return
+	}
+	c.writeSimple("OK")
+}
+
+// scan handles SCAN cursor [MATCH pattern] [COUNT count]
+func (c *respConn) scan(args []string) {
+	if len(args) == 0 {
+		c.wrongArgs("SCAN")
+		return
+	}
+
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		c.writeError("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				c.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		case "TYPE":
+			// Every value is a string
+			if !strings.EqualFold(args[i+1], "string") {
+				pattern = ""
+				count = 0
+			}
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	next, keys := 0, []string{}
+	if pattern != "" {
+		next, keys = c.kvd.db.Scan(cursor, pattern, count)
+	}
+
+	c.writeArrayHeader(2)
+	c.writeBulk(strconv.Itoa(next))
+	c.writeBulks(keys)
+}
+
+// incr handles INCR, DECR, INCRBY and DECRBY
+func (c *respConn) incr(cmd string, args []string) {
+	delta := int64(1)
+	switch cmd {
+	case "INCR", "DECR":
+		if len(args) != 1 {
+			c.wrongArgs(cmd)
+			return
+		}
+	default:
+		if len(args) != 2 {
+			c.wrongArgs(cmd)
+			return
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			c.writeError("ERR value is not an integer or out of range")
+			return
+		}
+		delta = n
+	}
+	if strings.HasPrefix(cmd, "DECR") {
+		if delta == math.MinInt64 {
+			c.writeError("ERR value is not an integer or out of range")
+			return
+		}
+		delta = -delta
+	}
+
+	n, err := c.kvd.db.IncrContext(c.context(), args[0], delta)
+	if err != nil {
+		c.writeDBError(err)
+		return
+	}
+	c.writeInt(n)
+}
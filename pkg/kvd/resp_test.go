@@ -0,0 +1,155 @@
+package kvd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func newTestKvd(t *testing.T) *Kvd {
+	t.Helper()
+	kvd := &Kvd{}
+	if err := kvd.Init(nil); err != nil {
+		t.Fatalf("Failed to init server: %v", err)
+	}
+	kvd.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return kvd
+}
+
+func TestRESPCommands(t *testing.T) {
+	kvd := newTestKvd(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	go kvd.serveRESP(server)
+
+	r := bufio.NewReader(client)
+	send := func(cmd string) {
+		if _, err := io.WriteString(client, cmd+"\r\n"); err != nil {
+			t.Fatalf("Failed to send %q: %v", cmd, err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, line := range want {
+			got, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read reply: %v", err)
+			}
+			if strings.TrimRight(got, "\r\n") != line {
+				t.Errorf("Expected %q, got %q", line, got)
+			}
+		}
+	}
+
+	send("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue")
+	expect("+OK")
+
+	send("GET key")
+	expect("$5", "value")
+
+	send("SET other value EX 9223372036854775807")
+	expect("-ERR invalid expire time in 'set' command")
+
+	send("GET missing")
+	expect("$-1")
+
+	send("INCR hits")
+	expect(":1")
+
+	send("DECRBY hits -9223372036854775808")
+	expect("-ERR value is not an integer or out of range")
+
+	send("MGET key missing")
+	expect("*2", "$5", "value", "$-1")
+
+	send("EXPIRE key 100")
+	expect(":1")
+
+	send("TTL key")
+	expect(":100")
+
+	send("PEXPIRE key 9223372036854775807")
+	expect("-ERR invalid expire time in 'pexpire' command")
+
+	send("EXPIRE key -9223372036854775807")
+	expect("-ERR invalid expire time in 'expire' command")
+
+	send("DBSIZE")
+	expect(":2")
+
+	send("DEL key missing")
+	expect(":1")
+
+	send("HELLO 3")
+	expect("%6")
+	for {
+		// Skip the server properties up to the empty modules list
+		line, err := r.ReadString('\n')
+		if err != nil || line == "*0\r\n" {
+			break
+		}
+	}
+
+	send("GET missing")
+	expect("_")
+
+	send("BOGUS")
+	expect("-ERR unknown command 'BOGUS'")
+
+	// Commands over RESP share the HTTP API's metrics
+	if metrics := kvd.db.Metrics(); metrics.SetOps != 2 || metrics.DelOps != 1 {
+		t.Errorf("Unexpected metrics %+v", metrics)
+	}
+}
+
+func TestRESPBadMultibulk(t *testing.T) {
+	kvd := newTestKvd(t)
+	for _, header := range []string{"*-1", "*-2147483648", "*99999999999999999999", "*1048577"} {
+		client, server := net.Pipe()
+		go kvd.serveRESP(server)
+
+		io.WriteString(client, header+"\r\n")
+		got, err := bufio.NewReader(client).ReadString('\n')
+		if err != nil || got != "-ERR Protocol error: invalid multibulk length\r\n" {
+			t.Errorf("%s: expected a protocol error, got %q %v", header, got, err)
+		}
+		client.Close()
+	}
+}
+
+func TestTCPServerRecover(t *testing.T) {
+	kvd := newTestKvd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := kvd.startTCPServer(ctx, "test", "127.0.0.1", 0, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line == "panic\n" {
+			panic("bad client")
+		}
+		io.WriteString(conn, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(line string) (string, error) {
+		conn, err := net.Dial("tcp", s.listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		io.WriteString(conn, line)
+		return bufio.NewReader(conn).ReadString('\n')
+	}
+	if _, err := send("panic\n"); err == nil {
+		t.Error("Expected the panicking connection to be closed")
+	}
+	if got, err := send("hello\n"); err != nil || got != "hello\n" {
+		t.Errorf("Expected the server to keep serving, got %q %v", got, err)
+	}
+}
@@ -0,0 +1,222 @@
+package kvd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Script errors
+var (
+	ErrScriptNotFound  = errors.New("script not found")
+	ErrScriptSyntax    = errors.New("script syntax error")
+	ErrScriptRuntime   = errors.New("script runtime error")
+	ErrScriptStepLimit = errors.New("script exceeded step limit")
+	ErrScriptTimeout   = errors.New("script exceeded time limit")
+)
+
+// Script limits used when the configuration leaves them unset
+const (
+	DefaultScriptMaxSteps = 100000
+	DefaultScriptTimeout  = 100 * time.Millisecond
+
+	// maxScriptString bounds strings built by concatenation in a script
+	maxScriptString = 1048576
+
+	// scriptClockInterval is how many steps run between deadline checks
+	scriptClockInterval = 1024
+)
+
+// ScriptLimits bounds the work a single script invocation may do
+type ScriptLimits struct {
+	MaxSteps int
+	Timeout  time.Duration
+}
+
+// scriptStore holds registered scripts by hash
+type scriptStore struct {
+	mutex   sync.RWMutex
+	scripts map[string]scriptNode
+}
+
+// scriptVM holds the state of a single script invocation. Writes are
+// buffered and only applied to the store when the script succeeds.
+type scriptVM struct {
+	db       *DB
+	vars     map[string]interface{}
+	writes   map[string]*string
+	order    []string
+	steps    int
+	limits   ScriptLimits
+	deadline time.Time
+	result   interface{}
+	returned bool
+}
+
+// ScriptHash returns the hash a script's source is registered under
+func ScriptHash(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadScript compiles and registers a script, returning its hash
+func (db *DB) LoadScript(src string) (string, error) {
+	program, err := parseScript(src)
+	if err != nil {
+		return "", err
+	}
+
+	hash := ScriptHash(src)
+
+	db.scripts.mutex.Lock()
+	defer db.scripts.mutex.Unlock()
+
+	if db.scripts.scripts == nil {
+		db.scripts.scripts = make(map[string]scriptNode)
+	}
+	db.scripts.scripts[hash] = program
+
+	return hash, nil
+}
+
+// UnloadScript removes a registered script
+func (db *DB) UnloadScript(hash string) error {
+	db.scripts.mutex.Lock()
+	defer db.scripts.mutex.Unlock()
+
+	if _, ok := db.scripts.scripts[hash]; !ok {
+		return ErrScriptNotFound
+	}
+	delete(db.scripts.scripts, hash)
+
+	return nil
+}
+
+// RunScript executes a registered script atomically with the given keys
+// and arguments. Other operations are blocked while it runs, and none of
+// its writes are applied if it fails or exceeds its limits.
+func (db *DB) RunScript(hash string, keys []string, args []string, limits ScriptLimits) (interface{}, error) {
+	return db.RunScriptContext(context.Background(), hash, keys, args, limits)
+}
+
+// RunScriptContext is RunScript with a context for auditing the script's
+// writes
+func (db *DB) RunScriptContext(ctx context.Context, hash string, keys []string, args []string, limits ScriptLimits) (interface{}, error) {
+	db.scripts.mutex.RLock()
+	program, ok := db.scripts.scripts[hash]
+	db.scripts.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrScriptNotFound
+	}
+
+	if limits.MaxSteps <= 0 {
+		limits.MaxSteps = DefaultScriptMaxSteps
+	}
+	if limits.Timeout <= 0 {
+		limits.Timeout = DefaultScriptTimeout
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	if args == nil {
+		args = []string{}
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	vm := &scriptVM{
+		db:       db,
+		vars:     map[string]interface{}{"KEYS": keys, "ARGS": args},
+		writes:   make(map[string]*string),
+		limits:   limits,
+		deadline: time.Now().Add(limits.Timeout),
+	}
+
+	if _, err := program.eval(vm); err != nil {
+		return nil, err
+	}
+	if _, isList := vm.result.([]string); isList {
+		return nil, fmt.Errorf("%w: cannot return a list", ErrScriptRuntime)
+	}
+
+	if err := vm.room(); err != nil {
+		return nil, err
+	}
+	vm.commit(ctx)
+
+	return vm.result, nil
+}
+
+// step counts one unit of work against the script's limits
+func (vm *scriptVM) step() error {
+	vm.steps++
+	if vm.steps > vm.limits.MaxSteps {
+		return ErrScriptStepLimit
+	}
+	if vm.steps%scriptClockInterval == 0 && time.Now().After(vm.deadline) {
+		return ErrScriptTimeout
+	}
+	return nil
+}
+
+// read returns a key's value as the script currently sees it
+func (vm *scriptVM) read(key string) (string, bool) {
+	atomic.AddInt64(&vm.db.metrics.GetOps, 1)
+
+	if value, ok := vm.writes[key]; ok {
+		if value == nil {
+			return "", false
+		}
+		return *value, true
+	}
+	return vm.db.lookupLocked(key)
+}
+
+// write buffers a set, or a delete when value is nil
+func (vm *scriptVM) write(key string, value *string) {
+	if _, ok := vm.writes[key]; !ok {
+		vm.order = append(vm.order, key)
+	}
+	vm.writes[key] = value
+}
+
+// room checks that the buffered writes fit in the store, counting the keys
+// they add less the keys they delete. The caller must hold the write lock.
+func (vm *scriptVM) room() error {
+	added := 0
+	for key, value := range vm.writes {
+		_, exists := vm.db.store[key]
+		switch {
+		case value != nil && !exists:
+			added++
+		case value == nil && exists:
+			added--
+		}
+	}
+	return vm.db.roomForLocked(added)
+}
+
+// commit applies and audits the buffered writes. The caller must hold the
+// write lock.
+func (vm *scriptVM) commit(ctx context.Context) {
+	for _, key := range vm.order {
+		value := vm.writes[key]
+		if value == nil {
+			if _, ok := vm.db.removeLocked(key); ok {
+				vm.db.auditLocked(ctx, AuditDelete, key, nil)
+				atomic.AddInt64(&vm.db.metrics.DelOps, 1)
+			}
+			continue
+		}
+		vm.db.overwriteLocked(key, *value)
+		vm.db.auditLocked(ctx, AuditSet, key, value)
+		atomic.AddInt64(&vm.db.metrics.SetOps, 1)
+	}
+}
@@ -0,0 +1,99 @@
+package kvd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// scriptLoadResponse reports the hash a script was registered under
+type scriptLoadResponse struct {
+	Hash string `json:"Hash"`
+}
+
+// scriptRunRequest is the body accepted when running a script
+type scriptRunRequest struct {
+	Keys []string `json:"Keys"`
+	Args []string `json:"Args"`
+}
+
+// scriptRunResponse carries the value a script returned
+type scriptRunResponse struct {
+	Result interface{} `json:"Result"`
+}
+
+// scriptErrorStatus maps script errors to HTTP status codes
+func scriptErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrScriptNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrScriptSyntax):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrScriptRuntime), errors.Is(err, ErrScriptStepLimit), errors.Is(err, ErrScriptTimeout):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrStoreFull):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// scriptLoadHandler handles requests to register a script. The request
+// body is the script source.
+func (kvd *Kvd) scriptLoadHandler(w http.ResponseWriter, r *http.Request) {
+	src, ok := kvd.readBody(w, r, kvd.settings().limits.maxBodySize, ErrBodyTooLarge)
+	if !ok {
+		return
+	}
+
+	hash, err := kvd.db.LoadScript(string(src))
+	if err != nil {
+		http.Error(w, err.Error(), scriptErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusCreated, scriptLoadResponse{Hash: hash})
+}
+
+// scriptRunHandler handles requests to run a registered script
+func (kvd *Kvd) scriptRunHandler(w http.ResponseWriter, r *http.Request) {
+	var req scriptRunRequest
+	hash := mux.Vars(r)["hash"]
+
+	body, ok := kvd.readBody(w, r, kvd.settings().limits.maxBodySize, ErrBodyTooLarge)
+	if !ok {
+		return
+	}
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	config := kvd.settings().config
+	limits := ScriptLimits{
+		MaxSteps: config.ScriptMaxSteps,
+		Timeout:  config.ScriptTimeout,
+	}
+	result, err := kvd.db.RunScriptContext(r.Context(), hash, req.Keys, req.Args, limits)
+	if err != nil {
+		http.Error(w, err.Error(), scriptErrorStatus(err))
+		return
+	}
+
+	kvd.writeJSON(w, http.StatusOK, scriptRunResponse{Result: result})
+}
+
+// scriptUnloadHandler handles requests to remove a registered script
+func (kvd *Kvd) scriptUnloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := kvd.db.UnloadScript(mux.Vars(r)["hash"]); err != nil {
+		http.Error(w, err.Error(), scriptErrorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,103 @@
+package kvd
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScriptTransfer(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("alice", "100")
+	db.Set("bob", "5")
+
+	const transfer = `
+		# move ARGS[0] from KEYS[0] to KEYS[1]
+		let amount = int(ARGS[0])
+		let from = int(get(KEYS[0]))
+		if from < amount {
+			return "insufficient funds"
+		}
+		set(KEYS[0], from - amount)
+		set(KEYS[1], int(get(KEYS[1])) + amount)
+		return from - amount
+	`
+
+	hash, err := db.LoadScript(transfer)
+	if err != nil {
+		t.Fatalf("Failed to load script: %v", err)
+	}
+	if hash != ScriptHash(transfer) {
+		t.Errorf("Expected script to be registered under its source hash")
+	}
+
+	result, err := db.RunScript(hash, []string{"alice", "bob"}, []string{"30"}, ScriptLimits{})
+	if err != nil {
+		t.Fatalf("Failed to run script: %v", err)
+	}
+	if result != int64(70) {
+		t.Errorf("Expected result 70, got %v", result)
+	}
+	if v, _ := db.Get("bob"); v != "35" {
+		t.Errorf("Expected bob to be 35, got %s", v)
+	}
+
+	result, _ = db.RunScript(hash, []string{"alice", "bob"}, []string{"500"}, ScriptLimits{})
+	if result != "insufficient funds" {
+		t.Errorf("Expected insufficient funds, got %v", result)
+	}
+}
+
+func TestScriptFailureDiscardsWrites(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("a", "1")
+
+	hash, err := db.LoadScript(`set(KEYS[0], "2"); del("b"); return int("not a number")`)
+	if err != nil {
+		t.Fatalf("Failed to load script: %v", err)
+	}
+
+	if _, err := db.RunScript(hash, []string{"a"}, nil, ScriptLimits{}); !errors.Is(err, ErrScriptRuntime) {
+		t.Fatalf("Expected runtime error, got %v", err)
+	}
+	if v, _ := db.Get("a"); v != "1" {
+		t.Errorf("Expected failed script to leave a unchanged, got %s", v)
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	db := newTestDB(t)
+
+	hash, err := db.LoadScript(`let i = 0; while true { i = i + 1 }`)
+	if err != nil {
+		t.Fatalf("Failed to load script: %v", err)
+	}
+
+	if _, err := db.RunScript(hash, nil, nil, ScriptLimits{MaxSteps: 1000}); !errors.Is(err, ErrScriptStepLimit) {
+		t.Errorf("Expected step limit error, got %v", err)
+	}
+	if _, err := db.RunScript(hash, nil, nil, ScriptLimits{MaxSteps: 1 << 40, Timeout: 10 * time.Millisecond}); !errors.Is(err, ErrScriptTimeout) {
+		t.Errorf("Expected timeout error, got %v", err)
+	}
+}
+
+func TestScriptSyntaxErrors(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, src := range []string{
+		`let = 1`,
+		`if true { return 1`,
+		`unknown(1)`,
+		`get(1, 2)`,
+		`"unterminated`,
+		`return 1 @ 2`,
+	} {
+		if _, err := db.LoadScript(src); !errors.Is(err, ErrScriptSyntax) {
+			t.Errorf("Expected syntax error for %q, got %v", src, err)
+		}
+	}
+
+	if _, err := db.RunScript("missing", nil, nil, ScriptLimits{}); !errors.Is(err, ErrScriptNotFound) {
+		t.Errorf("Expected ErrScriptNotFound, got %v", err)
+	}
+}
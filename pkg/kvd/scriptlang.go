@@ -0,0 +1,900 @@
+package kvd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements the small sandboxed language used by server-side
+// scripts. A script is a sequence of statements:
+//
+//	let total = int(get(KEYS[0])) + int(ARGS[0])   # declare a variable
+//	total = total * 2                              # assign to it
+//	if total > 100 { return "too big" } else { set(KEYS[0], total) }
+//	while exists(KEYS[1]) { del(KEYS[1]) }
+//	return total
+//
+// Values are nil, booleans, 64-bit integers and strings; KEYS and ARGS are
+// read-only lists. Scripts can only reach the database through the
+// built-in functions get, set, del and exists, plus the helpers int, str
+// and len.
+
+// tokenKind identifies the lexical class of a token
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokOp
+)
+
+// token is a lexical unit of a script
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// scriptKeywords may not be used as variable names
+var scriptKeywords = map[string]bool{
+	"let": true, "if": true, "else": true, "while": true, "return": true,
+	"true": true, "false": true, "nil": true,
+}
+
+// lexScript splits a script into tokens
+func lexScript(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i], start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(src) && unicode.IsDigit(rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokInt, src[start:i], start})
+		case c == '"':
+			start := i
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("%w: unterminated string at offset %d", ErrScriptSyntax, start)
+			}
+			i++
+			text, err := strconv.Unquote(src[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid string at offset %d", ErrScriptSyntax, start)
+			}
+			tokens = append(tokens, token{tokString, text, start})
+		default:
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, token{tokOp, two, i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%<>!=(){}[],;", c) {
+				return nil, fmt.Errorf("%w: unexpected character %q at offset %d", ErrScriptSyntax, c, i)
+			}
+			tokens = append(tokens, token{tokOp, string(c), i})
+			i++
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(src)}), nil
+}
+
+// scriptNode is a compiled statement or expression
+type scriptNode interface {
+	eval(vm *scriptVM) (interface{}, error)
+}
+
+// scriptParser builds a node tree from tokens by recursive descent
+type scriptParser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// maxScriptDepth bounds how deeply expressions and blocks may nest
+const maxScriptDepth = 64
+
+// enter tracks nesting so hostile scripts cannot exhaust the stack
+func (p *scriptParser) enter() error {
+	p.depth++
+	if p.depth > maxScriptDepth {
+		return fmt.Errorf("%w: nesting deeper than %d", ErrScriptSyntax, maxScriptDepth)
+	}
+	return nil
+}
+
+func (p *scriptParser) leave() {
+	p.depth--
+}
+
+// parseScript compiles a script's source into a runnable block
+func parseScript(src string) (scriptNode, error) {
+	tokens, err := lexScript(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &scriptParser{tokens: tokens}
+	var stmts []scriptNode
+	for {
+		for p.accept(";") {
+		}
+		if p.peek().kind == tokEOF {
+			break
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return &blockNode{stmts: stmts}, nil
+}
+
+func (p *scriptParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *scriptParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *scriptParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("%w: expected %q at offset %d", ErrScriptSyntax, text, t.pos)
+	}
+	return nil
+}
+
+func (p *scriptParser) statement() (scriptNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokIdent && t.text == "let":
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent || scriptKeywords[name.text] {
+			return nil, fmt.Errorf("%w: expected variable name at offset %d", ErrScriptSyntax, name.pos)
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &assignNode{name: name.text, value: value, declare: true}, nil
+
+	case t.kind == tokIdent && t.text == "if":
+		return p.ifStatement()
+
+	case t.kind == tokIdent && t.text == "while":
+		p.next()
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return &whileNode{cond: cond, body: body}, nil
+
+	case t.kind == tokIdent && t.text == "return":
+		p.next()
+		if n := p.peek(); n.kind == tokEOF || (n.kind == tokOp && (n.text == "}" || n.text == ";")) {
+			return &returnNode{value: &literalNode{}}, nil
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &returnNode{value: value}, nil
+
+	case t.kind == tokIdent && !scriptKeywords[t.text] &&
+		p.tokens[p.pos+1].kind == tokOp && p.tokens[p.pos+1].text == "=":
+		p.pos += 2
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return &assignNode{name: t.text, value: value}, nil
+	}
+
+	return p.expression()
+}
+
+func (p *scriptParser) ifStatement() (scriptNode, error) {
+	p.next()
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &ifNode{cond: cond, then: then}
+	if p.accept("else") {
+		if t := p.peek(); t.kind == tokIdent && t.text == "if" {
+			node.otherwise, err = p.ifStatement()
+		} else {
+			node.otherwise, err = p.block()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (p *scriptParser) block() (scriptNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var stmts []scriptNode
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			return nil, fmt.Errorf("%w: unterminated block", ErrScriptSyntax)
+		}
+		if p.accept(";") {
+			continue
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return &blockNode{stmts: stmts}, nil
+}
+
+// binaryLevels lists binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *scriptParser) expression() (scriptNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	return p.binary(0)
+}
+
+func (p *scriptParser) binary(level int) (scriptNode, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		matched := false
+		if t.kind == tokOp {
+			for _, op := range binaryLevels[level] {
+				if t.text == op {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *scriptParser) unary() (scriptNode, error) {
+	if t := p.peek(); t.kind == tokOp && (t.text == "!" || t.text == "-") {
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, operand: operand}, nil
+	}
+	return p.postfix()
+}
+
+func (p *scriptParser) postfix() (scriptNode, error) {
+	node, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("[") {
+		index, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		node = &indexNode{list: node, index: index}
+	}
+	return node, nil
+}
+
+func (p *scriptParser) primary() (scriptNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: integer out of range at offset %d", ErrScriptSyntax, t.pos)
+		}
+		return &literalNode{value: n}, nil
+
+	case tokString:
+		return &literalNode{value: t.text}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "nil":
+			return &literalNode{}, nil
+		}
+		if scriptKeywords[t.text] {
+			return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrScriptSyntax, t.text, t.pos)
+		}
+
+		if !p.accept("(") {
+			return &varNode{name: t.text}, nil
+		}
+
+		fn, ok := scriptBuiltins[t.text]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown function %q at offset %d", ErrScriptSyntax, t.text, t.pos)
+		}
+		call := &callNode{name: t.text, fn: fn}
+		for !p.accept(")") {
+			if len(call.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		if len(call.args) != fn.arity {
+			return nil, fmt.Errorf("%w: %s expects %d arguments at offset %d", ErrScriptSyntax, t.text, fn.arity, t.pos)
+		}
+		return call, nil
+
+	case tokOp:
+		if t.text == "(" {
+			node, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return node, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrScriptSyntax, t.text, t.pos)
+}
+
+// Node types
+
+type literalNode struct {
+	value interface{}
+}
+
+type varNode struct {
+	name string
+}
+
+type assignNode struct {
+	name    string
+	value   scriptNode
+	declare bool
+}
+
+type blockNode struct {
+	stmts []scriptNode
+}
+
+type ifNode struct {
+	cond      scriptNode
+	then      scriptNode
+	otherwise scriptNode
+}
+
+type whileNode struct {
+	cond scriptNode
+	body scriptNode
+}
+
+type returnNode struct {
+	value scriptNode
+}
+
+type unaryNode struct {
+	op      string
+	operand scriptNode
+}
+
+type binaryNode struct {
+	op          string
+	left, right scriptNode
+}
+
+type indexNode struct {
+	list, index scriptNode
+}
+
+type callNode struct {
+	name string
+	fn   scriptBuiltin
+	args []scriptNode
+}
+
+func (n *literalNode) eval(vm *scriptVM) (interface{}, error) {
+	return n.value, vm.step()
+}
+
+func (n *varNode) eval(vm *scriptVM) (interface{}, error) {
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+	value, ok := vm.vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("%w: undefined variable %q", ErrScriptRuntime, n.name)
+	}
+	return value, nil
+}
+
+func (n *assignNode) eval(vm *scriptVM) (interface{}, error) {
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+	if _, ok := vm.vars[n.name]; !ok && !n.declare {
+		return nil, fmt.Errorf("%w: assignment to undeclared variable %q", ErrScriptRuntime, n.name)
+	}
+	if n.name == "KEYS" || n.name == "ARGS" {
+		return nil, fmt.Errorf("%w: %s is read-only", ErrScriptRuntime, n.name)
+	}
+	value, err := n.value.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+	vm.vars[n.name] = value
+	return nil, nil
+}
+
+func (n *blockNode) eval(vm *scriptVM) (interface{}, error) {
+	for _, stmt := range n.stmts {
+		if _, err := stmt.eval(vm); err != nil {
+			return nil, err
+		}
+		if vm.returned {
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (n *ifNode) eval(vm *scriptVM) (interface{}, error) {
+	cond, err := n.cond.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return n.then.eval(vm)
+	}
+	if n.otherwise != nil {
+		return n.otherwise.eval(vm)
+	}
+	return nil, nil
+}
+
+func (n *whileNode) eval(vm *scriptVM) (interface{}, error) {
+	for !vm.returned {
+		cond, err := n.cond.eval(vm)
+		if err != nil {
+			return nil, err
+		}
+		if !truthy(cond) {
+			break
+		}
+		if _, err := n.body.eval(vm); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (n *returnNode) eval(vm *scriptVM) (interface{}, error) {
+	value, err := n.value.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+	vm.result = value
+	vm.returned = true
+	return nil, nil
+}
+
+func (n *unaryNode) eval(vm *scriptVM) (interface{}, error) {
+	operand, err := n.operand.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(operand), nil
+	}
+	i, ok := operand.(int64)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot negate %s", ErrScriptRuntime, typeName(operand))
+	}
+	return -i, nil
+}
+
+func (n *binaryNode) eval(vm *scriptVM) (interface{}, error) {
+	left, err := n.left.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(vm)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(vm)
+		return truthy(right), err
+	}
+
+	right, err := n.right.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		_, llist := left.([]string)
+		_, rlist := right.([]string)
+		if llist || rlist {
+			return nil, fmt.Errorf("%w: cannot compare lists", ErrScriptRuntime)
+		}
+	}
+
+	switch n.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+
+	// String concatenation and comparison
+	if ls, ok := left.(string); ok {
+		if rs, ok := right.(string); ok {
+			switch n.op {
+			case "+":
+				if len(ls)+len(rs) > maxScriptString {
+					return nil, fmt.Errorf("%w: string exceeds %d bytes", ErrScriptRuntime, maxScriptString)
+				}
+				return ls + rs, nil
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+
+	li, lok := left.(int64)
+	ri, rok := right.(int64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%w: invalid operands for %s: %s and %s", ErrScriptRuntime, n.op, typeName(left), typeName(right))
+	}
+
+	switch n.op {
+	case "+":
+		return li + ri, nil
+	case "-":
+		return li - ri, nil
+	case "*":
+		return li * ri, nil
+	case "/", "%":
+		if ri == 0 {
+			return nil, fmt.Errorf("%w: division by zero", ErrScriptRuntime)
+		}
+		if n.op == "/" {
+			return li / ri, nil
+		}
+		return li % ri, nil
+	case "<":
+		return li < ri, nil
+	case "<=":
+		return li <= ri, nil
+	case ">":
+		return li > ri, nil
+	default:
+		return li >= ri, nil
+	}
+}
+
+func (n *indexNode) eval(vm *scriptVM) (interface{}, error) {
+	list, err := n.list.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := list.([]string)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot index %s", ErrScriptRuntime, typeName(list))
+	}
+	i, ok := index.(int64)
+	if !ok || i < 0 || i >= int64(len(items)) {
+		return nil, fmt.Errorf("%w: index %v out of range", ErrScriptRuntime, index)
+	}
+	return items[i], nil
+}
+
+func (n *callNode) eval(vm *scriptVM) (interface{}, error) {
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(vm)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return n.fn.call(vm, args)
+}
+
+// truthy reports whether a value counts as true in a condition
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case string:
+		return v != ""
+	case []string:
+		return len(v) > 0
+	}
+	return true
+}
+
+// typeName describes a value's type for error messages
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toString converts a value to the string stored in the database
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// scriptBuiltin is a function callable from scripts
+type scriptBuiltin struct {
+	arity int
+	call  func(vm *scriptVM, args []interface{}) (interface{}, error)
+}
+
+// scriptBuiltins maps function names to their implementations
+var scriptBuiltins map[string]scriptBuiltin
+
+func init() {
+	scriptBuiltins = map[string]scriptBuiltin{
+		"get": {1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			key, err := keyArg("get", args[0])
+			if err != nil {
+				return nil, err
+			}
+			if value, ok := vm.read(key); ok {
+				return value, nil
+			}
+			return nil, nil
+		}},
+		"exists": {1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			key, err := keyArg("exists", args[0])
+			if err != nil {
+				return nil, err
+			}
+			_, ok := vm.read(key)
+			return ok, nil
+		}},
+		"set": {2, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			key, err := writeKeyArg(vm, "set", args[0])
+			if err != nil {
+				return nil, err
+			}
+			value := toString(args[1])
+			vm.write(key, &value)
+			return nil, nil
+		}},
+		"del": {1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			key, err := writeKeyArg(vm, "del", args[0])
+			if err != nil {
+				return nil, err
+			}
+			_, existed := vm.read(key)
+			vm.write(key, nil)
+			return existed, nil
+		}},
+		"int": {1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case int64:
+				return v, nil
+			case nil:
+				return int64(0), nil
+			case bool:
+				if v {
+					return int64(1), nil
+				}
+				return int64(0), nil
+			case string:
+				n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %q is not an integer", ErrScriptRuntime, v)
+				}
+				return n, nil
+			}
+			return nil, fmt.Errorf("%w: cannot convert %s to int", ErrScriptRuntime, typeName(args[0]))
+		}},
+		"str": {1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			return toString(args[0]), nil
+		}},
+		"len": {1, func(vm *scriptVM, args []interface{}) (interface{}, error) {
+			switch v := args[0].(type) {
+			case string:
+				return int64(len(v)), nil
+			case []string:
+				return int64(len(v)), nil
+			case nil:
+				return int64(0), nil
+			}
+			return nil, fmt.Errorf("%w: len of %s", ErrScriptRuntime, typeName(args[0]))
+		}},
+	}
+}
+
+// writeKeyArg validates a key passed to a built-in that writes it
+func writeKeyArg(vm *scriptVM, fn string, v interface{}) (string, error) {
+	key, err := keyArg(fn, v)
+	if err != nil {
+		return "", err
+	}
+	if err := vm.db.checkWriteKey(key); err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrScriptRuntime, fn, err)
+	}
+	return key, nil
+}
+
+// keyArg validates a key passed to a built-in
+func keyArg(fn string, v interface{}) (string, error) {
+	key, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s key must be a string, got %s", ErrScriptRuntime, fn, typeName(v))
+	}
+	if err := ValidateKey(key); err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrScriptRuntime, fn, err)
+	}
+	return key, nil
+}
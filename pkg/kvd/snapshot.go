@@ -0,0 +1,268 @@
+package kvd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Snapshot errors
+var (
+	ErrSnapshotFormat   = errors.New("not a valid kvd snapshot")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+// Snapshot format identifiers
+const (
+	snapshotFormat  = "kvd-snapshot"
+	SnapshotVersion = 1
+)
+
+// maxSnapshotPrealloc bounds how many records are allocated up front from
+// the key count in a snapshot header, which is not trusted
+const maxSnapshotPrealloc = 4096
+
+// RestoreMode selects how a snapshot is applied
+type RestoreMode string
+
+// Restore modes
+const (
+	// RestoreReplace removes every existing key before loading the snapshot
+	RestoreReplace RestoreMode = "replace"
+	// RestoreMerge loads the snapshot over the existing keys, keeping keys
+	// the snapshot does not contain
+	RestoreMerge RestoreMode = "merge"
+)
+
+// SnapshotHeader is the first line of a snapshot
+type SnapshotHeader struct {
+	Format  string `json:"Format"`
+	Version int    `json:"Version"`
+	Created string `json:"Created"`
+	Keys    int64  `json:"Keys"`
+}
+
+// RestoreResult reports what a restore changed
+type RestoreResult struct {
+	Mode     RestoreMode `json:"Mode"`
+	Restored int64       `json:"Restored"`
+	Removed  int64       `json:"Removed"`
+	Expired  int64       `json:"Expired"`
+}
+
+// snapshotEntry is a record line, or the trailer line when Checksum is set
+type snapshotEntry struct {
+	Checksum  string `json:"Checksum,omitempty"`
+	Key       string `json:"Key,omitempty"`
+	Value     string `json:"Value,omitempty"`
+	Flags     uint32 `json:"Flags,omitempty"`
+	ExpiresAt int64  `json:"ExpiresAt,omitempty"`
+	Keys      int64  `json:"Keys,omitempty"`
+}
+
+// WriteSnapshot writes a consistent point-in-time copy of the database to
+// w and returns how many keys it contains. The database is only locked
+// while the copy is taken, not while it is written.
+//
+// A snapshot is NDJSON: a header line, one line per key with its value,
+// flags and absolute expiry in Unix milliseconds, and a trailer line with
+// the SHA-256 of every preceding byte. Keys attached to leases are left
+// out, since the leases themselves do not survive a restore.
+func (db *DB) WriteSnapshot(w io.Writer) (int64, error) {
+	db.mutex.RLock()
+	now := time.Now()
+	entries := make([]snapshotEntry, 0, len(db.store))
+	for key, value := range db.store {
+		if _, leased := db.keyLeases[key]; leased {
+			continue
+		}
+		entry := snapshotEntry{Key: key, Value: value, Flags: db.meta[key].Flags}
+		if expiry, ok := db.expires[key]; ok {
+			if !now.Before(expiry) {
+				continue
+			}
+			entry.ExpiresAt = expiry.UnixMilli()
+		}
+		entries = append(entries, entry)
+	}
+	db.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+
+	hash := sha256.New()
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(io.MultiWriter(buffered, hash))
+
+	header := SnapshotHeader{
+		Format:  snapshotFormat,
+		Version: SnapshotVersion,
+		Created: now.UTC().Format(time.RFC3339),
+		Keys:    int64(len(entries)),
+	}
+	if err := encoder.Encode(header); err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return 0, err
+		}
+	}
+
+	trailer := snapshotEntry{Checksum: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Keys: int64(len(entries))}
+	if err := json.NewEncoder(buffered).Encode(trailer); err != nil {
+		return 0, err
+	}
+
+	return int64(len(entries)), buffered.Flush()
+}
+
+// RestoreSnapshot reads a snapshot written by WriteSnapshot and applies it
+// atomically. The whole snapshot is read, its checksum verified and its
+// keys checked as any other write's would be before anything is changed.
+// Keys whose expiry has passed are skipped.
+func (db *DB) RestoreSnapshot(r io.Reader, mode RestoreMode) (RestoreResult, error) {
+	return db.RestoreSnapshotContext(context.Background(), r, mode)
+}
+
+// RestoreSnapshotContext is RestoreSnapshot with a context for auditing
+// each key the restore removes or sets
+func (db *DB) RestoreSnapshotContext(ctx context.Context, r io.Reader, mode RestoreMode) (RestoreResult, error) {
+	result := RestoreResult{Mode: mode}
+	if mode != RestoreReplace && mode != RestoreMerge {
+		return result, fmt.Errorf("unknown restore mode %q", mode)
+	}
+
+	entries, err := readSnapshot(r)
+	if err != nil {
+		return result, err
+	}
+
+	// Refuse keys no other write would accept, before anything is changed
+	now := time.Now()
+	keys := make([]string, 0, len(entries))
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if err := db.checkWriteKey(entry.Key); err != nil {
+			return result, fmt.Errorf("key %q: %w", entry.Key, err)
+		}
+		if entry.ExpiresAt != 0 && !now.Before(time.UnixMilli(entry.ExpiresAt)) {
+			continue
+		}
+		if _, ok := seen[entry.Key]; !ok {
+			seen[entry.Key] = struct{}{}
+			keys = append(keys, entry.Key)
+		}
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if mode == RestoreReplace {
+		err = db.roomForLocked(len(keys) - len(db.store))
+	} else {
+		err = db.roomLocked(keys...)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if mode == RestoreReplace {
+		for key := range db.store {
+			if _, ok := db.removeLocked(key); ok {
+				db.auditLocked(ctx, AuditRestore, key, nil)
+				result.Removed++
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		var expiry time.Time
+		if entry.ExpiresAt != 0 {
+			expiry = time.UnixMilli(entry.ExpiresAt)
+			if !now.Before(expiry) {
+				result.Expired++
+				continue
+			}
+		}
+
+		db.overwriteLocked(entry.Key, entry.Value)
+		if entry.Flags != 0 {
+			meta := db.meta[entry.Key]
+			meta.Flags = entry.Flags
+			db.meta[entry.Key] = meta
+		}
+		if !expiry.IsZero() {
+			db.expires[entry.Key] = expiry
+		}
+		value := entry.Value
+		db.auditLocked(ctx, AuditRestore, entry.Key, &value)
+		result.Restored++
+	}
+
+	return result, nil
+}
+
+// readSnapshot parses and verifies a snapshot, returning its records
+func readSnapshot(r io.Reader) ([]snapshotEntry, error) {
+	reader := bufio.NewReader(r)
+	hash := sha256.New()
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, ErrSnapshotFormat
+	}
+	hash.Write(line)
+
+	var header SnapshotHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != snapshotFormat {
+		return nil, ErrSnapshotFormat
+	}
+	if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, header.Version)
+	}
+
+	if header.Keys < 0 {
+		return nil, fmt.Errorf("%w: negative key count", ErrSnapshotFormat)
+	}
+
+	entries := make([]snapshotEntry, 0, min(header.Keys, maxSnapshotPrealloc))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// The trailer was never reached, so the snapshot is truncated
+			return nil, fmt.Errorf("%w: missing trailer", ErrSnapshotFormat)
+		}
+
+		var entry snapshotEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrSnapshotFormat, len(entries)+2, err)
+		}
+
+		if entry.Checksum != "" {
+			if entry.Checksum != "sha256:"+hex.EncodeToString(hash.Sum(nil)) {
+				return nil, ErrSnapshotChecksum
+			}
+			if entry.Keys != int64(len(entries)) {
+				return nil, fmt.Errorf("%w: expected %d keys, found %d", ErrSnapshotFormat, entry.Keys, len(entries))
+			}
+			if _, err := reader.Peek(1); err != io.EOF {
+				return nil, fmt.Errorf("%w: data after trailer", ErrSnapshotFormat)
+			}
+			return entries, nil
+		}
+
+		hash.Write(line)
+		if entry.Key == "" {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrSnapshotFormat, len(entries)+2, ErrEmptyKey)
+		}
+		entries = append(entries, entry)
+	}
+}
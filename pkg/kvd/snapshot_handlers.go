@@ -0,0 +1,74 @@
+package kvd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// backupHandler streams a snapshot of the database
+func (kvd *Kvd) backupHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing write deadline", "err", err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="kvd-%s.snapshot"`, time.Now().UTC().Format("20060102T150405Z")))
+	w.WriteHeader(http.StatusOK)
+
+	count, err := kvd.db.WriteSnapshot(w)
+	if err != nil {
+		kvd.log(r).Warn("Backup stopped", "err", err)
+		return
+	}
+	kvd.log(r).Info("Backup written", "keys", count)
+}
+
+// restoreHandler applies a snapshot from the request body. The mode query
+// parameter is replace (the default) or merge.
+func (kvd *Kvd) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	mode := RestoreMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = RestoreReplace
+	}
+	if mode != RestoreReplace && mode != RestoreMerge {
+		http.Error(w, fmt.Sprintf("mode must be %s or %s", RestoreReplace, RestoreMerge), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// Large restores outlive the server's timeouts, and the result is only
+	// written once the whole snapshot has been applied
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing read deadline", "err", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		kvd.log(r).Warn("Error clearing write deadline", "err", err)
+	}
+
+	result, err := kvd.db.RestoreSnapshotContext(r.Context(), r.Body, mode)
+	if err != nil {
+		kvd.log(r).Warn("Restore failed", "err", err)
+		http.Error(w, err.Error(), snapshotErrorStatus(err))
+		return
+	}
+
+	kvd.log(r).Info("Snapshot restored", "mode", mode, "restored", result.Restored, "removed", result.Removed, "expired", result.Expired)
+	kvd.writeJSON(w, http.StatusOK, result)
+}
+
+// snapshotErrorStatus maps restore errors to HTTP status codes
+func snapshotErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrSnapshotFormat), errors.Is(err, ErrSnapshotChecksum):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrSnapshotVersion):
+		return http.StatusUnprocessableEntity
+	default:
+		return keyErrorStatus(err)
+	}
+}
@@ -0,0 +1,153 @@
+package kvd
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("a", "1")
+	db.SetWithOptions("b", "2", SetOptions{TTL: time.Hour, Flags: 7})
+	lease, _ := db.GrantLease(time.Minute)
+	db.SetWithLease("leased", "x", lease.ID)
+
+	var buf bytes.Buffer
+	count, err := db.WriteSnapshot(&buf)
+	if err != nil || count != 2 {
+		t.Fatalf("Expected 2 keys in snapshot, got %d: %v", count, err)
+	}
+
+	restored := newTestDB(t)
+	restored.Set("a", "old")
+	restored.Set("extra", "kept?")
+	result, err := restored.RestoreSnapshot(bytes.NewReader(buf.Bytes()), RestoreMerge)
+	if err != nil || result.Restored != 2 || result.Removed != 0 {
+		t.Fatalf("Unexpected merge result %+v: %v", result, err)
+	}
+	if value, _ := restored.Get("a"); value != "1" {
+		t.Errorf("Expected a overwritten with 1, got %q", value)
+	}
+	if _, err := restored.Get("extra"); err != nil {
+		t.Errorf("Expected merge to keep extra: %v", err)
+	}
+	if _, meta, _ := restored.GetWithMeta("b"); meta.Flags != 7 {
+		t.Errorf("Expected flags 7 on b, got %d", meta.Flags)
+	}
+	if ttl, ok, _ := restored.TTL("b"); !ok || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected b to keep its expiry, got %v", ttl)
+	}
+
+	result, err = restored.RestoreSnapshot(bytes.NewReader(buf.Bytes()), RestoreReplace)
+	if err != nil || result.Removed != 3 {
+		t.Fatalf("Unexpected replace result %+v: %v", result, err)
+	}
+	if _, err := restored.Get("extra"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected replace to remove extra, got %v", err)
+	}
+}
+
+func TestSnapshotRejectsDamage(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("a", "1")
+	db.Set("b", "2")
+
+	var buf bytes.Buffer
+	db.WriteSnapshot(&buf)
+	snapshot := buf.String()
+	lines := strings.SplitAfter(snapshot, "\n")
+
+	cases := map[string]struct {
+		data string
+		want error
+	}{
+		"tampered":  {strings.Replace(snapshot, `"Value":"1"`, `"Value":"9"`, 1), ErrSnapshotChecksum},
+		"truncated": {strings.Join(lines[:2], ""), ErrSnapshotFormat},
+		"garbage":   {"hello\n", ErrSnapshotFormat},
+		"version":   {strings.Replace(snapshot, `"Version":1`, `"Version":99`, 1), ErrSnapshotVersion},
+		"trailing":  {snapshot + "{}\n", ErrSnapshotFormat},
+		"negative":  {strings.Replace(snapshot, `"Keys":2`, `"Keys":-1`, 1), ErrSnapshotFormat},
+		"huge":      {strings.Replace(snapshot, `"Keys":2`, `"Keys":9223372036854775807`, 1), ErrSnapshotChecksum},
+	}
+
+	for name, tc := range cases {
+		target := newTestDB(t)
+		target.Set("keep", "me")
+		_, err := target.RestoreSnapshot(strings.NewReader(tc.data), RestoreReplace)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, err)
+		}
+		if _, err := target.Get("keep"); err != nil {
+			t.Errorf("%s: a failed restore changed the database", name)
+		}
+	}
+}
+
+func TestSnapshotRestoreChecksKeys(t *testing.T) {
+	source := newTestDB(t)
+	source.Set("a", "1")
+	source.Set("b", "2")
+	source.Set("_kvd/x", "3")
+	var buf bytes.Buffer
+	source.WriteSnapshot(&buf)
+	reserved := buf.String()
+
+	source.Delete("_kvd/x")
+	buf.Reset()
+	source.WriteSnapshot(&buf)
+	plain := buf.String()
+
+	target := newTestDB(t)
+	target.SetReservedPrefixes([]string{DefaultReservedPrefix})
+	target.Set("keep", "me")
+	if _, err := target.RestoreSnapshot(strings.NewReader(reserved), RestoreReplace); !errors.Is(err, ErrReservedKey) {
+		t.Errorf("Expected ErrReservedKey, got %v", err)
+	}
+
+	target.SetMaxRecords(2)
+	if _, err := target.RestoreSnapshot(strings.NewReader(plain), RestoreMerge); !errors.Is(err, ErrStoreFull) {
+		t.Errorf("Expected ErrStoreFull merging past max records, got %v", err)
+	}
+	if _, err := target.Get("keep"); err != nil {
+		t.Errorf("A refused restore changed the database")
+	}
+	if _, err := target.RestoreSnapshot(strings.NewReader(plain), RestoreReplace); err != nil {
+		t.Errorf("Expected a replace within max records to succeed: %v", err)
+	}
+}
+
+func TestSnapshotHandlers(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.db.Set("a", "1")
+
+	rec := httptest.NewRecorder()
+	kvd.backupHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from backup, got %d", rec.Code)
+	}
+	snapshot := rec.Body.String()
+
+	kvd.db.Set("b", "2")
+	rec = httptest.NewRecorder()
+	kvd.restoreHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader(snapshot)))
+	if rec.Code != http.StatusOK || kvd.db.Metrics().KeysStored != 1 {
+		t.Errorf("Expected replace restore to leave 1 key, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.restoreHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/restore?mode=upsert", strings.NewReader(snapshot)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown mode, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	kvd.restoreHandler(rec, httptest.NewRequest(http.MethodPost, "/admin/restore", strings.NewReader("not a snapshot")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad snapshot, got %d", rec.Code)
+	}
+}
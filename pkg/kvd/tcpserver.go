@@ -0,0 +1,103 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// tcpServer accepts connections for a wire protocol served next to the
+// HTTP API, such as the Redis or memcached listeners. Each connection is
+// handled on its own goroutine against the same DB.
+type tcpServer struct {
+	name     string
+	listener net.Listener
+	handle   func(conn net.Conn)
+	mutex    sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// startTCPServer listens on host:port and serves connections with handle
+// until ctx is done
+func (kvd *Kvd) startTCPServer(ctx context.Context, name string, host string, port int, handle func(conn net.Conn)) (*tcpServer, error) {
+	address := fmt.Sprintf("%s:%d", host, port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("could not start %s listener: %w", name, err)
+	}
+
+	s := &tcpServer{
+		name:     name,
+		listener: listener,
+		handle:   handle,
+		conns:    make(map[net.Conn]struct{}),
+	}
+
+	kvd.logger.Info("Starting "+name+" listener", "addr", listener.Addr().String())
+	go s.serve(kvd)
+	go func() {
+		<-ctx.Done()
+		s.close()
+	}()
+
+	return s, nil
+}
+
+// serve accepts connections until the listener is closed
+func (s *tcpServer) serve(kvd *Kvd) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				kvd.logger.Error(s.name+" listener error", "err", err)
+			}
+			return
+		}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mutex.Lock()
+				delete(s.conns, conn)
+				s.mutex.Unlock()
+				conn.Close()
+			}()
+			// A bad client must not take the server down with it
+			defer func() {
+				if r := recover(); r != nil {
+					kvd.logger.Error(s.name+" connection panic", "remote", conn.RemoteAddr().String(), "panic", r)
+				}
+			}()
+			s.handle(conn)
+		}()
+	}
+}
+
+// close stops accepting connections, closes open ones and waits for their
+// handlers to return
+func (s *tcpServer) close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
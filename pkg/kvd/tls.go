@@ -0,0 +1,243 @@
+package kvd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLS versions accepted as Config.TLSMinVersion
+const (
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// tlsReloadInterval is how often certificate files are checked for changes
+const tlsReloadInterval = 30 * time.Second
+
+// ErrTLSConfig is returned for an unusable TLS configuration
+var ErrTLSConfig = errors.New("invalid TLS configuration")
+
+// certReloader serves the certificate and client CA bundle from files,
+// picking up replaced files without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	logger   *slog.Logger
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// newCertReloader loads the certificate, key and, if caFile is set, the
+// client CA bundle
+func newCertReloader(certFile, keyFile, caFile string, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files lists the files the reloader reads
+func (r *certReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	return files
+}
+
+// load reads the files and swaps them in. On error the previous
+// certificate stays in use.
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("could not load TLS files: %w", err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		if clientCAs, err = loadCertPool(r.caFile); err != nil {
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// changed reports whether any of the files was modified since it was
+// loaded
+func (r *certReloader) changed() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for file, loaded := range r.modTimes {
+		if info, err := os.Stat(file); err == nil && !info.ModTime().Equal(loaded) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads the files again if any of them changed
+func (r *certReloader) Reload() error {
+	if !r.changed() {
+		return nil
+	}
+	if err := r.load(); err != nil {
+		return err
+	}
+	r.logger.Info("TLS certificates reloaded", "cert", r.certFile)
+	return nil
+}
+
+// run reloads changed files every interval until ctx is done
+func (r *certReloader) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				r.logger.Error("Error reloading TLS certificates, keeping the current ones", "err", err)
+			}
+		}
+	}
+}
+
+// getCertificate returns the current certificate for a handshake
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// loadCertPool reads a PEM bundle of CA certificates
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%w: no certificates in %s", ErrTLSConfig, file)
+	}
+	return pool, nil
+}
+
+// tlsVersion parses a minimum TLS version; empty means TLS 1.2
+func tlsVersion(version string) (uint16, error) {
+	switch version {
+	case "", TLSVersion12:
+		return tls.VersionTLS12, nil
+	case TLSVersion13:
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w: minimum version must be %s or %s", ErrTLSConfig, TLSVersion12, TLSVersion13)
+	}
+}
+
+// cipherSuites maps cipher suite names to IDs. Only suites Go considers
+// secure are accepted.
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown or insecure cipher suite %q", ErrTLSConfig, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// newServerTLS builds the HTTP server's TLS configuration from config, or
+// returns nil when TLS is off. The certificate and client CA bundle are
+// served by the returned reloader.
+func newServerTLS(config *Config, logger *slog.Logger) (*tls.Config, *certReloader, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		if config.TLSClientCAFile != "" {
+			return nil, nil, fmt.Errorf("%w: client certificates require a server certificate", ErrTLSConfig)
+		}
+		return nil, nil, nil
+	}
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, nil, fmt.Errorf("%w: both a certificate and a key file are needed", ErrTLSConfig)
+	}
+
+	minVersion, err := tlsVersion(config.TLSMinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	suites, err := cipherSuites(config.TLSCipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile, config.TLSClientCAFile, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   suites,
+		GetCertificate: certs.getCertificate,
+	}
+	if config.TLSClientCAFile != "" {
+		clientAuth := tls.RequireAndVerifyClientCert
+		if config.TLSClientCertOptional {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+
+		// Hand out the CA bundle current at each handshake
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			certs.mutex.RLock()
+			defer certs.mutex.RUnlock()
+			c := tlsConfig.Clone()
+			c.GetConfigForClient = nil
+			c.ClientAuth = clientAuth
+			c.ClientCAs = certs.clientCAs
+			return c, nil
+		}
+	}
+
+	return tlsConfig, certs, nil
+}
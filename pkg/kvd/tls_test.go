@@ -0,0 +1,228 @@
+package kvd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{dir: t.TempDir()}
+	ca.cert, ca.key = ca.issue(t, "ca", "Test CA", big.NewInt(1), true)
+	return ca
+}
+
+// issue creates a certificate signed by the CA, or a self-signed CA
+// certificate, and writes it to name.pem and name-key.pem
+func (ca *testCA) issue(t *testing.T, name, cn string, serial *big.Int, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	parent, parentKey := ca.cert, ca.key
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	writePEM(t, filepath.Join(ca.dir, name+".pem"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(ca.dir, name+"-key.pem"), "EC PRIVATE KEY", keyDER)
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func (ca *testCA) path(name string) string {
+	return filepath.Join(ca.dir, name)
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// serveTLS serves handler over TLS on a local port and returns its URL
+func serveTLS(t *testing.T, config *tls.Config, handler http.Handler) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	// Rejected handshakes are expected; keep them out of the test output
+	srv := &http.Server{Handler: handler, ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(listener)
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + listener.Addr().String()
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", "kvd", big.NewInt(2), false)
+	ca.issue(t, "client", "alice", big.NewInt(3), false)
+
+	config := &Config{
+		TLSCertFile:     ca.path("server.pem"),
+		TLSKeyFile:      ca.path("server-key.pem"),
+		TLSClientCAFile: ca.path("ca.pem"),
+	}
+	tlsConfig, _, err := newServerTLS(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Failed to configure TLS: %v", err)
+	}
+
+	url := serveTLS(t, tlsConfig, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, clientIdentity(r))
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	if resp, err := client().Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("Expected a request without a client certificate to fail")
+	}
+
+	cert, err := tls.LoadX509KeyPair(ca.path("client.pem"), ca.path("client-key.pem"))
+	if err != nil {
+		t.Fatalf("Failed to load client certificate: %v", err)
+	}
+	resp, err := client(cert).Get(url)
+	if err != nil {
+		t.Fatalf("Request with a client certificate failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "alice" {
+		t.Errorf("Expected identity alice, got %q", body)
+	}
+}
+
+func TestServerTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", "kvd", big.NewInt(2), false)
+
+	config := &Config{TLSCertFile: ca.path("server.pem"), TLSKeyFile: ca.path("server-key.pem"), TLSMinVersion: TLSVersion13}
+	tlsConfig, certs, err := newServerTLS(config, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("Failed to configure TLS: %v", err)
+	}
+	url := serveTLS(t, tlsConfig, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	serial := func() int64 {
+		t.Helper()
+		// A new transport per request forces a new handshake
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.TLS.Version != tls.VersionTLS13 {
+			t.Errorf("Expected TLS 1.3, got %x", resp.TLS.Version)
+		}
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+
+	if got := serial(); got != 2 {
+		t.Fatalf("Expected serial 2, got %d", got)
+	}
+
+	ca.issue(t, "server", "kvd", big.NewInt(4), false)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(ca.path("server.pem"), later, later)
+	if err := certs.Reload(); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	if got := serial(); got != 4 {
+		t.Errorf("Expected the renewed certificate, got serial %d", got)
+	}
+
+	// A broken replacement keeps the current certificate
+	os.WriteFile(ca.path("server.pem"), []byte("garbage"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(ca.path("server.pem"), later, later)
+	if err := certs.Reload(); err == nil {
+		t.Error("Expected an error reloading a broken certificate")
+	}
+	if got := serial(); got != 4 {
+		t.Errorf("Expected the previous certificate to stay, got serial %d", got)
+	}
+}
+
+func TestServerTLSConfigErrors(t *testing.T) {
+	ca := newTestCA(t)
+	ca.issue(t, "server", "kvd", big.NewInt(2), false)
+	cert, key := ca.path("server.pem"), ca.path("server-key.pem")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for _, config := range []Config{
+		{TLSCertFile: cert},
+		{TLSClientCAFile: ca.path("ca.pem")},
+		{TLSCertFile: cert, TLSKeyFile: key, TLSMinVersion: "1.0"},
+		{TLSCertFile: cert, TLSKeyFile: key, TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{TLSCertFile: cert, TLSKeyFile: key, TLSClientCAFile: key},
+	} {
+		if _, _, err := newServerTLS(&config, logger); !errors.Is(err, ErrTLSConfig) {
+			t.Errorf("Expected ErrTLSConfig for %+v, got %v", config, err)
+		}
+	}
+
+	if tlsConfig, _, err := newServerTLS(&Config{}, logger); tlsConfig != nil || err != nil {
+		t.Errorf("Expected TLS off by default, got %v %v", tlsConfig, err)
+	}
+
+	config := Config{TLSCertFile: cert, TLSKeyFile: key, TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}
+	if tlsConfig, _, err := newServerTLS(&config, logger); err != nil || len(tlsConfig.CipherSuites) != 1 {
+		t.Errorf("Expected one cipher suite, got %v", err)
+	}
+}
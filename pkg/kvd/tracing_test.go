@@ -0,0 +1,61 @@
+package kvd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceRequests(t *testing.T) {
+	kvd := newTestKvd(t)
+	kvd.db.Set("a", "1")
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	router := mux.NewRouter()
+	router.Use(traceRequests)
+	router.HandleFunc("/v1/{key}", kvd.keyGetHandler).Methods(http.MethodGet)
+
+	// Hold the lock so the read has to wait for it
+	kvd.db.mutex.Lock()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		kvd.db.mutex.Unlock()
+	}()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/v1/a", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("Span %s is not part of the caller's trace", span.Name())
+		}
+		spans[span.Name()] = span
+	}
+
+	server, ok := spans["GET /v1/{key}"]
+	if !ok || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("Expected a server span under the caller's span, got %v", spans)
+	}
+	get, ok := spans["DB.Get"]
+	if !ok || get.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatalf("Expected DB.Get under the server span, got %v", spans)
+	}
+	wait, ok := spans["DB.RLock"]
+	if !ok || wait.Parent().SpanID() != get.SpanContext().SpanID() {
+		t.Fatalf("Expected a lock wait span under DB.Get, got %v", spans)
+	}
+	if wait.EndTime().Sub(wait.StartTime()) < 10*time.Millisecond {
+		t.Errorf("Expected the lock wait span to cover the wait")
+	}
+}
@@ -0,0 +1,99 @@
+package kvd
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Watch errors
+var (
+	ErrWatchOverflow = errors.New("watcher fell too far behind")
+)
+
+// watcherBuffer is how many events may queue for a watcher before it is
+// cancelled
+const watcherBuffer = 1024
+
+// Watch event types
+const (
+	WatchPut    = "put"
+	WatchDelete = "delete"
+)
+
+// WatchEvent describes a change to a key
+type WatchEvent struct {
+	Type    string `json:"Type"`
+	Key     string `json:"Key"`
+	Value   string `json:"Value,omitempty"`
+	Version uint64 `json:"Version,omitempty"`
+}
+
+// Watcher receives events for keys matching a pattern
+type Watcher struct {
+	id      int64
+	pattern string
+	events  chan WatchEvent
+	err     atomic.Value
+}
+
+// Events returns the channel events are delivered on. It is closed when
+// the watcher is cancelled or overflows.
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Err reports why the events channel was closed, or nil if it was closed
+// by Unwatch
+func (w *Watcher) Err() error {
+	if err, ok := w.err.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Watch registers interest in changes to keys matching a glob pattern. An
+// empty pattern matches every key. Events are delivered in the order the
+// changes were applied. A watcher that does not keep up is cancelled with
+// ErrWatchOverflow rather than silently missing events.
+func (db *DB) Watch(pattern string) *Watcher {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	db.nextWatcherID++
+	w := &Watcher{
+		id:      db.nextWatcherID,
+		pattern: pattern,
+		events:  make(chan WatchEvent, watcherBuffer),
+	}
+	db.watchers[w.id] = w
+
+	return w
+}
+
+// Unwatch cancels a watcher and closes its events channel
+func (db *DB) Unwatch(w *Watcher) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, ok := db.watchers[w.id]; ok {
+		delete(db.watchers, w.id)
+		close(w.events)
+	}
+}
+
+// notifyLocked delivers an event to every matching watcher. The caller
+// must hold the write lock.
+func (db *DB) notifyLocked(event WatchEvent) {
+	for id, w := range db.watchers {
+		if w.pattern != "" && w.pattern != "*" && !globMatch(w.pattern, event.Key) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			w.err.Store(ErrWatchOverflow)
+			delete(db.watchers, id)
+			close(w.events)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+// Package kvdpb holds the gRPC service definition for KVD and the code
+// generated from it. Regenerate after editing kvd.proto with go generate.
+package kvdpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kvd.proto